@@ -20,13 +20,195 @@ type Server struct {
 	DefaultTarget string `mapstructure:"default_target"`
 	TimeOut       int    `mapstructure:"timeout"`
 	OAuth2        OAuth2 `mapstructure:"oauth2"`
+	Rego          Rego   `mapstructure:"rego"`
+	CSRF          CSRF   `mapstructure:"csrf"`
+}
+
+// CSRF protects the state-changing requests authenticated by a cookie (the
+// browser session of the gateway or a backend session cookie) with a
+// double-submit token and an Origin/Referer check; bearer requests are exempt
+type CSRF struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TrustedOrigins lists the origins (scheme://host[:port]) allowed besides
+	// the gateway itself
+	TrustedOrigins []string `mapstructure:"trusted_origins"`
+	// SessionCookies lists the backend cookies carrying a session, relayed by
+	// the proxy; "*" treats any cookie as a credential
+	SessionCookies []string `mapstructure:"session_cookies"`
+	// CookieName and HeaderName carry the token (csrf_token and X-CSRF-Token
+	// by default)
+	CookieName string `mapstructure:"cookie_name"`
+	HeaderName string `mapstructure:"header_name"`
+}
+
+// Rego configures the in-process evaluation of Rego policies. Only a subset
+// of the OPA language is supported: else, with, glob.match, io.jwt.* and the
+// other missing built-ins are rejected at startup, and numbers are float64
+// (integers beyond 2^53 lose precision)
+type Rego struct {
+	// Directory holds the .rego files, loaded and compiled at startup
+	Directory string `mapstructure:"directory"`
+	// DecisionLogs logs every evaluation with its input and result
+	DecisionLogs bool `mapstructure:"decision_logs"`
 }
 
 // Route defines a routing rule
 type Route struct {
-	Path   string `mapstructure:"path"`
+	// Path is matched against the request path according to Match; it may
+	// declare named parameters such as /api/users/:userId/orders, which the
+	// Target can reuse as http://backend/users/:userId/orders
+	Path string `mapstructure:"path"`
+	// Match is "prefix" (default: the path and its subpaths, segment by
+	// segment), "exact" or "regex" (Path is a regular expression matching the
+	// full request path, whose named groups are path parameters)
+	Match string `mapstructure:"match"`
+	// Priority breaks ties between routes matching the same request (0 by
+	// default, the highest wins); otherwise a route for the exact host wins
+	// over a wildcard host, then over a route for any host, then an exact
+	// route wins over the longest prefix route, which wins over regex routes.
+	// The declaration order never breaks ties: routes a request can match
+	// without these rules telling them apart, such as crossing path params or
+	// regex routes of the same priority, are refused at startup.
+	Priority int `mapstructure:"priority"`
+	// Hosts restricts the route to these request hosts (any host when
+	// empty); *.example.com matches every subdomain of example.com
+	Hosts []string `mapstructure:"hosts"`
+	// Headers and Query restrict the route to requests carrying these
+	// headers and query parameters
+	Headers []ValueMatcher `mapstructure:"headers"`
+	Query   []ValueMatcher `mapstructure:"query"`
+	// Target receives the request; the rest of the request path after the
+	// route Path is appended to it
+	Target string `mapstructure:"target"`
+	// StripPrefix is removed from the request path before it is appended to
+	// the Target (the route Path by default); "/" forwards the full path
+	StripPrefix string `mapstructure:"strip_prefix"`
+	// Rewrite builds the path appended to the Target from a regular
+	// expression; requests it does not match fall back to StripPrefix
+	Rewrite Rewrite `mapstructure:"rewrite"`
+	// Params binds the named path parameters to token claims
+	Params []PathParam `mapstructure:"params"`
+	// Tenancy isolates the tenants of the route
+	Tenancy Tenancy `mapstructure:"tenancy"`
+	// AccessRule holds the requirements applying to every method
+	AccessRule `mapstructure:",squash"`
+	// Rules adds per-method requirements; when set, methods not listed in
+	// any rule are rejected with 405. HEAD follows the rule of GET unless
+	// listed; CORS preflight requests are answered before the rules apply,
+	// other OPTIONS requests must be listed
+	Rules []MethodRule `mapstructure:"rules"`
+	// Auth is "required" (default when access rules are set), "optional"
+	// (default otherwise: a token is validated only if present) or "none"
+	// (the token is never validated nor rejected, which excludes access
+	// rules, path params and tenancy)
+	Auth string `mapstructure:"auth"`
+	// Validators overrides the token validator chain of the providers
+	Validators []string `mapstructure:"validators"`
+	// Issuers and Audiences override the server-level accepted iss and aud/azp
+	Issuers   []string `mapstructure:"issuers"`
+	Audiences []string `mapstructure:"audiences"`
+	// Providers restricts the identity providers accepted on the route
+	// (all providers by default)
+	Providers []string `mapstructure:"providers"`
+	// ExtAuthz calls an external authorization service before proxying
+	ExtAuthz ExtAuthz `mapstructure:"ext_authz"`
+}
+
+// ValueMatcher requires a request header or query parameter
+type ValueMatcher struct {
+	Name string `mapstructure:"name"`
+	// Value must equal one of the values, or Regex match one of them; the
+	// presence of the header or parameter is enough when both are empty
+	Value string `mapstructure:"value"`
+	Regex string `mapstructure:"regex"`
+}
+
+// Rewrite rewrites the request path before it is appended to the route target
+type Rewrite struct {
+	// Regex is matched against the full request path
+	Regex string `mapstructure:"regex"`
+	// Template is the rewritten path; it may reference the capture groups of
+	// Regex as $1 or ${name}
+	Template string `mapstructure:"template"`
+}
+
+// PathParam requires a named path parameter to match a token claim
+type PathParam struct {
+	// Name of the parameter, without the leading colon
+	Name string `mapstructure:"name"`
+	// Claim must equal the parameter when it is a single value, or contain it
+	// when it is a list; nested claims are separated by dots
+	// (such as realm_access.roles)
+	Claim string `mapstructure:"claim"`
+}
+
+// Tenancy enforces that callers only reach their own tenant and routes each
+// tenant to its backend
+type Tenancy struct {
+	// Claim holds the tenant of the caller, such as tenant_id; a list claim
+	// grants several tenants. Tenancy is disabled when empty
+	Claim string `mapstructure:"claim"`
+	// Param names the path parameter holding the requested tenant
+	Param string `mapstructure:"param"`
+	// Header holds the requested tenant when no path parameter is set, and
+	// carries the tenant to the backend (X-Tenant-ID by default)
+	Header string `mapstructure:"header"`
+	// Targets overrides the route target for some tenants
+	Targets []TenantTarget `mapstructure:"targets"`
+}
+
+// TenantTarget is the backend serving a tenant
+type TenantTarget struct {
+	Tenant string `mapstructure:"tenant"`
 	Target string `mapstructure:"target"`
-	Teams  []Team `mapstructure:"teams"`
+}
+
+// ExtAuthz configures the call to an external authorization service, which
+// receives the request metadata and the validated claims and decides whether
+// the request is proxied
+type ExtAuthz struct {
+	// URL of the service; the callout is disabled when empty
+	URL string `mapstructure:"url"`
+	// TimeoutMs bounds the call (1000 ms by default)
+	TimeoutMs int `mapstructure:"timeout_ms"`
+	// FailOpen lets requests through when the service is unreachable, times
+	// out or answers 5xx; they are rejected with 503 otherwise. A 4xx answer
+	// is always a denial.
+	FailOpen bool `mapstructure:"fail_open"`
+	// IncludeHeaders lists the request headers sent to the service
+	IncludeHeaders []string `mapstructure:"include_headers"`
+	// CacheTTL caches decisions for this many seconds (0 disables the cache)
+	CacheTTL int `mapstructure:"cache_ttl"`
+	// CacheMaxEntries bounds the decision cache (10000 by default)
+	CacheMaxEntries int `mapstructure:"cache_max_entries"`
+}
+
+// AccessRule groups the authorization requirements of a route or a method rule
+type AccessRule struct {
+	Teams []Team `mapstructure:"teams"`
+	// Match is "any" (default: membership of one team is enough) or "all"
+	// (membership of every team is required)
+	Match string `mapstructure:"match"`
+	// DenyTeams rejects members of these teams, whatever their other teams
+	DenyTeams []Team `mapstructure:"deny_teams"`
+	// Roles, ClientRoles and Scopes must all be granted to the token
+	// (realm_access roles, resource_access roles and scope claim)
+	Roles       []string      `mapstructure:"roles"`
+	ClientRoles []ClientRoles `mapstructure:"client_roles"`
+	Scopes      []string      `mapstructure:"scopes"`
+	// Policy is an expression on the request and the token claims, such as
+	// claims.email.endsWith("@corp.com") && "billing" in claims.groups;
+	// the request is rejected unless it evaluates to true
+	Policy string `mapstructure:"policy"`
+	// Rego is the Rego rule deciding the access, such as
+	// data.httpapi.authz.allow; the request is rejected unless it is true
+	Rego string `mapstructure:"rego"`
+}
+
+// MethodRule defines additional requirements for some HTTP methods of a route
+type MethodRule struct {
+	Methods    []string `mapstructure:"methods"`
+	AccessRule `mapstructure:",squash"`
 }
 
 // Team defines a team with name and description
@@ -35,18 +217,129 @@ type Team struct {
 	Description string `mapstructure:"description"`
 }
 
+// ClientRoles defines the roles required on a client (resource_access)
+type ClientRoles struct {
+	Client string   `mapstructure:"client"`
+	Roles  []string `mapstructure:"roles"`
+}
+
 // OAuth2 holds OAuth2-related configuration
 type OAuth2 struct {
-	// ClientID     string          `mapstructure:"client_id"`
-	// ClientSecret string          `mapstructure:"client_secret"`
-	// RedirectURL  string          `mapstructure:"redirect_url"`
+	// Provider describes the default identity provider
+	Provider `mapstructure:",squash"`
+	// Providers lists additional named identity providers (e.g. one
+	// Keycloak realm per population); tokens are routed to a provider
+	// according to their iss claim
+	Providers []Provider `mapstructure:"providers"`
+	Cache     TokenCache `mapstructure:"cache"`
+	// Issuers lists the accepted iss claims (defaults to the issuers of the
+	// accepted providers)
+	Issuers []string `mapstructure:"issuers"`
+	// Audiences lists the accepted aud/azp claims; empty means any audience.
+	// Userinfo responses carry neither, so audiences cannot be combined with
+	// the userinfo validator
+	Audiences []string `mapstructure:"audiences"`
+	// Login enables the browser login flow of the first provider
+	Login Login `mapstructure:"login"`
+}
+
+// Login configures the authorization code flow with PKCE offered to browsers
+// on /login, the redirect_url callback and /logout
+type Login struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Scopes requested from the provider (openid, profile and email by default)
+	Scopes []string `mapstructure:"scopes"`
+	// CookieSecret is the base64 encoded 32-byte key encrypting the login
+	// cookies; a random key is generated at startup when empty, which signs
+	// users out on restart and does not work with several instances
+	CookieSecret string `mapstructure:"cookie_secret"`
+	// DefaultRedirect is where users land after login or logout when no
+	// local redirect is requested ("/" by default)
+	DefaultRedirect string `mapstructure:"default_redirect"`
+	// PostLogoutRedirectURL is where the provider sends users back after
+	// /logout closed its session; it must be registered with the provider
+	PostLogoutRedirectURL string `mapstructure:"post_logout_redirect_url"`
+	// BackchannelLogoutPath receives the logout tokens of the provider
+	// ("/backchannel-logout" by default)
+	BackchannelLogoutPath string `mapstructure:"backchannel_logout_path"`
+	// Session configures the server-side storage of the browser tokens
+	Session Session `mapstructure:"session"`
+}
+
+// Session configures the browser sessions: the tokens obtained at login stay
+// on the gateway and the browser only holds an encrypted session cookie
+type Session struct {
+	// Store is "memory" (default) or "file"
+	Store string `mapstructure:"store"`
+	// Directory holds the session files of the "file" store
+	Directory string `mapstructure:"directory"`
+	// MaxAge is the session lifetime in seconds (8 hours by default)
+	MaxAge int `mapstructure:"max_age"`
+	// RefreshBefore is the number of seconds before its expiration at which
+	// the access token is refreshed with the refresh token (60 by default)
+	RefreshBefore int `mapstructure:"refresh_before"`
+	// SameSite is the SameSite attribute of the session cookie: "lax"
+	// (default) or "strict"
+	SameSite string `mapstructure:"same_site"`
+}
+
+// Provider describes an OAuth2 / OpenID Connect identity provider
+type Provider struct {
+	// Name identifies the provider in routes (defaults to "default" for the
+	// provider declared directly under oauth2)
+	Name string `mapstructure:"name"`
+	// ClientID and ClientSecret authenticate the gateway against the
+	// introspection and token endpoints
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	// RedirectURL is the callback of the browser login flow
+	RedirectURL string `mapstructure:"redirect_url"`
+	// Issuer is the OpenID Connect issuer URL. When set, endpoints are
+	// discovered from its /.well-known/openid-configuration document and
+	// explicit values in Endpoints take precedence over discovered ones.
+	Issuer    string          `mapstructure:"issuer"`
+	Discovery Discovery       `mapstructure:"discovery"`
 	Endpoints OAuth2Endpoints `mapstructure:"endpoints"`
+	// Validators is the ordered chain of token validators used by default:
+	// "userinfo" (default) calls the userinfo endpoint, "jwks" verifies JWT
+	// signatures locally and "introspection" queries the RFC 7662 endpoint.
+	// A validator that does not support the token format (e.g. an opaque
+	// token for "jwks") hands over to the next one.
+	Validators []string `mapstructure:"validators"`
+	JWKS       JWKS     `mapstructure:"jwks"`
+}
+
+// Discovery holds the settings of the OpenID Connect discovery
+type Discovery struct {
+	// RefreshInterval is the number of seconds between two discovery refreshes
+	RefreshInterval int `mapstructure:"refresh_interval"`
+}
+
+// JWKS holds the settings used for local JWT validation
+type JWKS struct {
+	// RefreshInterval is the number of seconds between two key set refreshes
+	RefreshInterval int `mapstructure:"refresh_interval"`
+	// Leeway is the clock skew tolerance, in seconds, applied to exp/nbf/iat
+	Leeway int `mapstructure:"leeway"`
+}
+
+// TokenCache holds the settings of the token validation cache
+type TokenCache struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TTL is the maximum number of seconds a validation is cached; an entry
+	// never outlives the exp claim of its token
+	TTL int `mapstructure:"ttl"`
+	// MaxEntries bounds the cache size, least recently used entries are evicted first
+	MaxEntries int `mapstructure:"max_entries"`
 }
 
 // OAuth2Endpoints holds the URLs for various OAuth2 endpoints
 type OAuth2Endpoints struct {
-	AuthURL      string `mapstructure:"auth_url"`
-	TokenURL     string `mapstructure:"token_url"`
-	TokenInfoURL string `mapstructure:"tokeninfo_url"`
-	UserInfoURL  string `mapstructure:"userinfo_url"`
+	AuthURL          string `mapstructure:"auth_url"`
+	TokenURL         string `mapstructure:"token_url"`
+	TokenInfoURL     string `mapstructure:"tokeninfo_url"`
+	UserInfoURL      string `mapstructure:"userinfo_url"`
+	IntrospectionURL string `mapstructure:"introspection_url"`
+	JWKSURL          string `mapstructure:"jwks_url"`
+	EndSessionURL    string `mapstructure:"end_session_url"`
 }
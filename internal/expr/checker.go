@@ -0,0 +1,346 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checker computes the static type of each node and rejects expressions that
+// can never be evaluated successfully
+type checker struct {
+	vars map[string]*Type
+}
+
+// check returns the type of n; scope holds the comprehension variables
+func (c *checker) check(n node, scope map[string]*Type) (*Type, error) {
+	switch n := n.(type) {
+	case *literalNode:
+		return &Type{Kind: kindOf(n.value)}, nil
+	case *identNode:
+		if t, ok := scope[n.name]; ok {
+			return t, nil
+		}
+		if t, ok := c.vars[n.name]; ok {
+			return t, nil
+		}
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("undeclared reference to %q", n.name)}
+	case *selectNode:
+		return c.checkSelect(n, scope)
+	case *hasNode:
+		if _, err := c.checkSelect(n.target, scope); err != nil {
+			return nil, err
+		}
+		return Bool, nil
+	case *indexNode:
+		return c.checkIndex(n, scope)
+	case *callNode:
+		return c.checkCall(n, scope)
+	case *unaryNode:
+		operand, err := c.check(n.operand, scope)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" {
+			if !operand.is(KindBool) {
+				return nil, mismatch(n.pos, n.op, operand)
+			}
+			return Bool, nil
+		}
+		if !operand.numeric() {
+			return nil, mismatch(n.pos, n.op, operand)
+		}
+		return operand, nil
+	case *binaryNode:
+		return c.checkBinary(n, scope)
+	case *conditionalNode:
+		cond, err := c.check(n.cond, scope)
+		if err != nil {
+			return nil, err
+		}
+		if !cond.is(KindBool) {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("condition must be bool, got %s", cond)}
+		}
+		ifTrue, err := c.check(n.ifTrue, scope)
+		if err != nil {
+			return nil, err
+		}
+		ifFalse, err := c.check(n.ifFalse, scope)
+		if err != nil {
+			return nil, err
+		}
+		if same(ifTrue, ifFalse) {
+			return ifTrue, nil
+		}
+		return Dyn, nil
+	case *listNode:
+		var elem *Type
+		for _, element := range n.elements {
+			t, err := c.check(element, scope)
+			if err != nil {
+				return nil, err
+			}
+			if elem == nil {
+				elem = t
+			} else if !same(elem, t) {
+				elem = Dyn
+			}
+		}
+		if elem == nil {
+			elem = Dyn
+		}
+		return ListOf(elem), nil
+	case *mapNode:
+		var elem *Type
+		for i := range n.keys {
+			key, err := c.check(n.keys[i], scope)
+			if err != nil {
+				return nil, err
+			}
+			if !key.is(KindString) {
+				return nil, &Error{Pos: n.keys[i].position(), Msg: fmt.Sprintf("map keys must be strings, got %s", key)}
+			}
+			t, err := c.check(n.values[i], scope)
+			if err != nil {
+				return nil, err
+			}
+			if elem == nil {
+				elem = t
+			} else if !same(elem, t) {
+				elem = Dyn
+			}
+		}
+		if elem == nil {
+			elem = Dyn
+		}
+		return MapOf(elem), nil
+	case *comprehensionNode:
+		return c.checkComprehension(n, scope)
+	}
+	return nil, &Error{Pos: n.position(), Msg: "unsupported expression"}
+}
+
+func (c *checker) checkSelect(n *selectNode, scope map[string]*Type) (*Type, error) {
+	operand, err := c.check(n.operand, scope)
+	if err != nil {
+		return nil, err
+	}
+	switch operand.Kind {
+	case KindDyn:
+		return Dyn, nil
+	case KindMap:
+		return operand.elem(), nil
+	case KindObject:
+		if field, ok := operand.Fields[n.field]; ok {
+			return field, nil
+		}
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("undefined field %q", n.field)}
+	}
+	return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("type %s does not support field selection", operand)}
+}
+
+func (c *checker) checkIndex(n *indexNode, scope map[string]*Type) (*Type, error) {
+	operand, err := c.check(n.operand, scope)
+	if err != nil {
+		return nil, err
+	}
+	index, err := c.check(n.index, scope)
+	if err != nil {
+		return nil, err
+	}
+	switch operand.Kind {
+	case KindDyn:
+		return Dyn, nil
+	case KindList:
+		if !index.is(KindInt) {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("list index must be int, got %s", index)}
+		}
+		return operand.elem(), nil
+	case KindMap:
+		if !index.is(KindString) {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("map key must be string, got %s", index)}
+		}
+		return operand.elem(), nil
+	case KindObject:
+		if !index.is(KindString) {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("field name must be string, got %s", index)}
+		}
+		if literal, ok := n.index.(*literalNode); ok {
+			return c.checkSelect(&selectNode{pos: n.pos, operand: n.operand, field: literal.value.(string)}, scope)
+		}
+		return Dyn, nil
+	}
+	return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("type %s does not support indexing", operand)}
+}
+
+func (c *checker) checkCall(n *callNode, scope map[string]*Type) (*Type, error) {
+	fn, ok := functions[n.function]
+	args := n.args
+	if n.target != nil {
+		fn, ok = methods[n.function]
+		args = append([]node{n.target}, n.args...)
+	}
+	if !ok {
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("undeclared function %q", n.function)}
+	}
+	if len(args) != len(fn.params) {
+		expected := len(fn.params)
+		if n.target != nil {
+			expected--
+		}
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("%s() expects %d argument(s)", n.function, expected)}
+	}
+	for i, arg := range args {
+		t, err := c.check(arg, scope)
+		if err != nil {
+			return nil, err
+		}
+		if !t.is(fn.params[i]...) {
+			names := make([]string, len(fn.params[i]))
+			for j, kind := range fn.params[i] {
+				names[j] = kindName(kind)
+			}
+			what := fmt.Sprintf("argument %d", i+1)
+			if n.target != nil {
+				what = "target"
+				if i > 0 {
+					what = fmt.Sprintf("argument %d", i)
+				}
+			}
+			return nil, &Error{Pos: arg.position(), Msg: fmt.Sprintf("%s of %s() must be %s, got %s", what, n.function, strings.Join(names, " or "), t)}
+		}
+	}
+	// literal regular expressions are validated at compile time
+	if n.function == "matches" {
+		if literal, ok := args[1].(*literalNode); ok {
+			if _, err := compileRegexp(literal.value.(string)); err != nil {
+				return nil, &Error{Pos: literal.pos, Msg: err.Error()}
+			}
+		}
+	}
+	return fn.result, nil
+}
+
+func (c *checker) checkBinary(n *binaryNode, scope map[string]*Type) (*Type, error) {
+	left, err := c.check(n.left, scope)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.check(n.right, scope)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&&", "||":
+		if !left.is(KindBool) || !right.is(KindBool) {
+			return nil, mismatch(n.pos, n.op, left, right)
+		}
+		return Bool, nil
+	case "==", "!=":
+		if !comparable(left, right) {
+			return nil, mismatch(n.pos, n.op, left, right)
+		}
+		return Bool, nil
+	case "<", "<=", ">", ">=":
+		if (left.numeric() && right.numeric()) || (left.is(KindString) && right.is(KindString)) {
+			return Bool, nil
+		}
+		return nil, mismatch(n.pos, n.op, left, right)
+	case "in":
+		switch right.Kind {
+		case KindDyn:
+		case KindList:
+			if !comparable(left, right.elem()) {
+				return nil, mismatch(n.pos, n.op, left, right)
+			}
+		case KindMap, KindObject:
+			if !left.is(KindString) {
+				return nil, mismatch(n.pos, n.op, left, right)
+			}
+		default:
+			return nil, mismatch(n.pos, n.op, left, right)
+		}
+		return Bool, nil
+	case "+":
+		switch {
+		case left.Kind == KindDyn || right.Kind == KindDyn:
+			if (left.is(KindInt, KindDouble, KindString, KindList)) && right.is(KindInt, KindDouble, KindString, KindList) {
+				return Dyn, nil
+			}
+		case left.Kind == KindString && right.Kind == KindString:
+			return String, nil
+		case left.Kind == KindList && right.Kind == KindList:
+			if same(left, right) {
+				return left, nil
+			}
+			return ListOf(Dyn), nil
+		case left.numeric() && right.numeric():
+			return arithmeticType(left, right), nil
+		}
+		return nil, mismatch(n.pos, n.op, left, right)
+	case "-", "*", "/", "%":
+		if left.numeric() && right.numeric() {
+			return arithmeticType(left, right), nil
+		}
+		return nil, mismatch(n.pos, n.op, left, right)
+	}
+	return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("unsupported operator %q", n.op)}
+}
+
+func (c *checker) checkComprehension(n *comprehensionNode, scope map[string]*Type) (*Type, error) {
+	rangeOf, err := c.check(n.rangeOf, scope)
+	if err != nil {
+		return nil, err
+	}
+	var variable *Type
+	switch rangeOf.Kind {
+	case KindDyn:
+		variable = Dyn
+	case KindList:
+		variable = rangeOf.elem()
+	case KindMap:
+		variable = String
+	default:
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("%s() cannot range over %s", n.macro, rangeOf)}
+	}
+
+	inner := make(map[string]*Type, len(scope)+1)
+	for name, t := range scope {
+		inner[name] = t
+	}
+	inner[n.variable] = variable
+
+	body, err := c.check(n.body, inner)
+	if err != nil {
+		return nil, err
+	}
+	if n.macro == "map" {
+		return ListOf(body), nil
+	}
+	if !body.is(KindBool) {
+		return nil, &Error{Pos: n.body.position(), Msg: fmt.Sprintf("%s() predicate must be bool, got %s", n.macro, body)}
+	}
+	if n.macro == "filter" {
+		return ListOf(variable), nil
+	}
+	return Bool, nil
+}
+
+// arithmeticType returns the type of an arithmetic operation on numbers
+func arithmeticType(left, right *Type) *Type {
+	switch {
+	case left.Kind == KindInt && right.Kind == KindInt:
+		return Int
+	case left.Kind == KindDyn || right.Kind == KindDyn:
+		return Dyn
+	}
+	return Double
+}
+
+// mismatch reports an operator applied to operands of unsupported types
+func mismatch(pos int, op string, operands ...*Type) error {
+	names := make([]string, len(operands))
+	for i, t := range operands {
+		names[i] = t.String()
+	}
+	return &Error{Pos: pos, Msg: fmt.Sprintf("no matching overload for %q applied to (%s)", op, strings.Join(names, ", "))}
+}
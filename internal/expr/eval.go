@@ -0,0 +1,526 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// evaluator evaluates a checked syntax tree against variables
+type evaluator struct {
+	vars map[string]any
+}
+
+// eval returns the value of n; scope holds the comprehension variables
+func (e *evaluator) eval(n node, scope map[string]any) (any, error) {
+	switch n := n.(type) {
+	case *literalNode:
+		return n.value, nil
+	case *identNode:
+		if value, ok := scope[n.name]; ok {
+			return value, nil
+		}
+		if value, ok := e.vars[n.name]; ok {
+			return native(value), nil
+		}
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("no value for variable %q", n.name)}
+	case *selectNode:
+		operand, err := e.eval(n.operand, scope)
+		if err != nil {
+			return nil, err
+		}
+		return e.field(n, operand, n.field)
+	case *hasNode:
+		operand, err := e.eval(n.target.operand, scope)
+		if err != nil {
+			return nil, err
+		}
+		fields, ok := operand.(map[string]any)
+		if !ok {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("has() cannot test a field of %s", kindName(kindOf(operand)))}
+		}
+		_, found := fields[n.target.field]
+		return found, nil
+	case *indexNode:
+		return e.index(n, scope)
+	case *callNode:
+		return e.call(n, scope)
+	case *unaryNode:
+		operand, err := e.eval(n.operand, scope)
+		if err != nil {
+			return nil, err
+		}
+		switch value := operand.(type) {
+		case bool:
+			if n.op == "!" {
+				return !value, nil
+			}
+		case int64:
+			if n.op == "-" {
+				return -value, nil
+			}
+		case float64:
+			if n.op == "-" {
+				return -value, nil
+			}
+		}
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("no matching overload for %q applied to %s", n.op, kindName(kindOf(operand)))}
+	case *binaryNode:
+		if n.op == "&&" || n.op == "||" {
+			return e.logical(n, scope)
+		}
+		left, err := e.eval(n.left, scope)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.eval(n.right, scope)
+		if err != nil {
+			return nil, err
+		}
+		return e.binary(n, left, right)
+	case *conditionalNode:
+		cond, err := e.eval(n.cond, scope)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := cond.(bool)
+		if !ok {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("condition must be bool, got %s", kindName(kindOf(cond)))}
+		}
+		if value {
+			return e.eval(n.ifTrue, scope)
+		}
+		return e.eval(n.ifFalse, scope)
+	case *listNode:
+		list := make([]any, len(n.elements))
+		for i, element := range n.elements {
+			value, err := e.eval(element, scope)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return list, nil
+	case *mapNode:
+		result := make(map[string]any, len(n.keys))
+		for i := range n.keys {
+			key, err := e.eval(n.keys[i], scope)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, &Error{Pos: n.keys[i].position(), Msg: "map keys must be strings"}
+			}
+			value, err := e.eval(n.values[i], scope)
+			if err != nil {
+				return nil, err
+			}
+			result[name] = value
+		}
+		return result, nil
+	case *comprehensionNode:
+		return e.comprehension(n, scope)
+	}
+	return nil, &Error{Pos: n.position(), Msg: "unsupported expression"}
+}
+
+// field reads a field of a map, failing when it is absent as CEL does;
+// has() tests the presence of a field beforehand
+func (e *evaluator) field(n node, operand any, name string) (any, error) {
+	fields, ok := operand.(map[string]any)
+	if !ok {
+		return nil, &Error{Pos: n.position(), Msg: fmt.Sprintf("%s does not support field selection", kindName(kindOf(operand)))}
+	}
+	value, found := fields[name]
+	if !found {
+		return nil, &Error{Pos: n.position(), Msg: fmt.Sprintf("no such key: %s", name)}
+	}
+	return native(value), nil
+}
+
+func (e *evaluator) index(n *indexNode, scope map[string]any) (any, error) {
+	operand, err := e.eval(n.operand, scope)
+	if err != nil {
+		return nil, err
+	}
+	index, err := e.eval(n.index, scope)
+	if err != nil {
+		return nil, err
+	}
+	switch container := operand.(type) {
+	case []any:
+		i, ok := index.(int64)
+		if !ok {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("list index must be int, got %s", kindName(kindOf(index)))}
+		}
+		if i < 0 || i >= int64(len(container)) {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("index %d out of range", i)}
+		}
+		return native(container[i]), nil
+	case map[string]any:
+		key, ok := index.(string)
+		if !ok {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("map key must be string, got %s", kindName(kindOf(index)))}
+		}
+		return e.field(n, container, key)
+	}
+	return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("%s does not support indexing", kindName(kindOf(operand)))}
+}
+
+func (e *evaluator) call(n *callNode, scope map[string]any) (any, error) {
+	fn, ok := functions[n.function]
+	args := n.args
+	if n.target != nil {
+		fn, ok = methods[n.function]
+		args = append([]node{n.target}, n.args...)
+	}
+	if !ok || len(args) != len(fn.params) {
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("undeclared function %q", n.function)}
+	}
+	values := make([]any, len(args))
+	for i, arg := range args {
+		value, err := e.eval(arg, scope)
+		if err != nil {
+			return nil, err
+		}
+		if !accepts(fn.params[i], value) {
+			return nil, &Error{Pos: arg.position(), Msg: fmt.Sprintf("%s() does not accept %s", n.function, kindName(kindOf(value)))}
+		}
+		values[i] = value
+	}
+	result, err := fn.eval(values)
+	if err != nil {
+		return nil, &Error{Pos: n.pos, Msg: err.Error()}
+	}
+	return result, nil
+}
+
+// logical evaluates && and || with the commutative error handling of CEL:
+// an error on one side is ignored when the other side decides the result
+func (e *evaluator) logical(n *binaryNode, scope map[string]any) (any, error) {
+	decisive := n.op == "||"
+	left, leftErr := e.boolean(n.left, scope)
+	if leftErr == nil && left == decisive {
+		return decisive, nil
+	}
+	right, rightErr := e.boolean(n.right, scope)
+	if rightErr == nil && right == decisive {
+		return decisive, nil
+	}
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	if rightErr != nil {
+		return nil, rightErr
+	}
+	return !decisive, nil
+}
+
+// boolean evaluates n and requires a bool
+func (e *evaluator) boolean(n node, scope map[string]any) (bool, error) {
+	value, err := e.eval(n, scope)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, &Error{Pos: n.position(), Msg: fmt.Sprintf("expected bool, got %s", kindName(kindOf(value)))}
+	}
+	return result, nil
+}
+
+func (e *evaluator) binary(n *binaryNode, left, right any) (any, error) {
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "<", "<=", ">", ">=":
+		cmp, ok := compare(left, right)
+		if !ok {
+			break
+		}
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	case "in":
+		switch container := right.(type) {
+		case []any:
+			for _, element := range container {
+				if equal(left, native(element)) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			key, ok := left.(string)
+			if !ok {
+				break
+			}
+			_, found := container[key]
+			return found, nil
+		}
+	case "+":
+		switch l := left.(type) {
+		case string:
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		case []any:
+			if r, ok := right.([]any); ok {
+				return append(append(make([]any, 0, len(l)+len(r)), l...), r...), nil
+			}
+		default:
+			return arithmetic(n, left, right)
+		}
+	case "-", "*", "/", "%":
+		return arithmetic(n, left, right)
+	}
+	return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("no matching overload for %q applied to (%s, %s)", n.op, kindName(kindOf(left)), kindName(kindOf(right)))}
+}
+
+func (e *evaluator) comprehension(n *comprehensionNode, scope map[string]any) (any, error) {
+	rangeOf, err := e.eval(n.rangeOf, scope)
+	if err != nil {
+		return nil, err
+	}
+	var elements []any
+	switch container := rangeOf.(type) {
+	case []any:
+		elements = container
+	case map[string]any:
+		keys := make([]string, 0, len(container))
+		for key := range container {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			elements = append(elements, key)
+		}
+	default:
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("%s() cannot range over %s", n.macro, kindName(kindOf(rangeOf)))}
+	}
+
+	inner := make(map[string]any, len(scope)+1)
+	for name, value := range scope {
+		inner[name] = value
+	}
+
+	var (
+		matches  int
+		firstErr error
+		results  = []any{}
+	)
+	for _, element := range elements {
+		element = native(element)
+		inner[n.variable] = element
+		if n.macro == "map" {
+			value, err := e.eval(n.body, inner)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, value)
+			continue
+		}
+		ok, err := e.boolean(n.body, inner)
+		if err != nil {
+			// exists() and all() ignore errors when another element decides the result
+			if n.macro == "exists" || n.macro == "all" {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			return nil, err
+		}
+		switch {
+		case n.macro == "exists" && ok:
+			return true, nil
+		case n.macro == "all" && !ok:
+			return false, nil
+		case n.macro == "filter" && ok:
+			results = append(results, element)
+		case ok:
+			matches++
+		}
+	}
+
+	switch n.macro {
+	case "exists", "all":
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return n.macro == "all", nil
+	case "exists_one":
+		return matches == 1, nil
+	}
+	return results, nil
+}
+
+// arithmetic applies a numeric operator; mixing int and double yields a double
+func arithmetic(n *binaryNode, left, right any) (any, error) {
+	if l, ok := left.(int64); ok {
+		if r, ok := right.(int64); ok {
+			switch n.op {
+			case "+":
+				return l + r, nil
+			case "-":
+				return l - r, nil
+			case "*":
+				return l * r, nil
+			case "/", "%":
+				if r == 0 {
+					return nil, &Error{Pos: n.pos, Msg: "division by zero"}
+				}
+				if n.op == "/" {
+					return l / r, nil
+				}
+				return l % r, nil
+			}
+		}
+	}
+	l, lok := toDouble(left)
+	r, rok := toDouble(right)
+	if !lok || !rok {
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("no matching overload for %q applied to (%s, %s)", n.op, kindName(kindOf(left)), kindName(kindOf(right)))}
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		return l / r, nil
+	default:
+		return math.Mod(l, r), nil
+	}
+}
+
+// equal compares two values; numbers are compared by value whatever their kind
+func equal(left, right any) bool {
+	if l, ok := toDouble(left); ok {
+		r, ok := toDouble(right)
+		return ok && l == r
+	}
+	switch l := left.(type) {
+	case nil:
+		return right == nil
+	case bool, string:
+		return left == right
+	case []any:
+		r, ok := right.([]any)
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for i := range l {
+			if !equal(native(l[i]), native(r[i])) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		r, ok := right.(map[string]any)
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for key, value := range l {
+			other, found := r[key]
+			if !found || !equal(native(value), native(other)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compare orders two numbers or two strings
+func compare(left, right any) (int, bool) {
+	if l, ok := left.(string); ok {
+		r, ok := right.(string)
+		return strings.Compare(l, r), ok
+	}
+	if l, ok := left.(int64); ok {
+		if r, ok := right.(int64); ok {
+			switch {
+			case l < r:
+				return -1, true
+			case l > r:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	l, lok := toDouble(left)
+	r, rok := toDouble(right)
+	if !lok || !rok {
+		return 0, false
+	}
+	switch {
+	case l < r:
+		return -1, true
+	case l > r:
+		return 1, true
+	}
+	return 0, true
+}
+
+func toDouble(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// accepts reports whether a runtime value matches one of the given kinds
+func accepts(kinds []Kind, value any) bool {
+	kind := kindOf(value)
+	for _, accepted := range kinds {
+		if accepted == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// native converts the usual Go types to the representation used by the
+// evaluator; nested values are converted when they are accessed
+func native(value any) any {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case []string:
+		list := make([]any, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return list
+	case map[string]string:
+		fields := make(map[string]any, len(v))
+		for key, s := range v {
+			fields[key] = s
+		}
+		return fields
+	}
+	return value
+}
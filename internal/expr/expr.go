@@ -0,0 +1,68 @@
+package expr
+
+import "fmt"
+
+// Env declares the variables available to expressions
+type Env struct {
+	vars map[string]*Type
+}
+
+// NewEnv creates an environment declaring the given variables
+func NewEnv(vars map[string]*Type) *Env {
+	return &Env{vars: vars}
+}
+
+// Program is a compiled, type-checked expression
+type Program struct {
+	source string
+	root   node
+	result *Type
+}
+
+// Compile parses and type-checks an expression
+func (env *Env) Compile(source string) (*Program, error) {
+	root, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+	result, err := (&checker{vars: env.vars}).check(root, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{source: source, root: root, result: result}, nil
+}
+
+// CompileBool compiles an expression which must evaluate to a bool
+func (env *Env) CompileBool(source string) (*Program, error) {
+	program, err := env.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	if !program.result.is(KindBool) {
+		return nil, &Error{Pos: 0, Msg: fmt.Sprintf("expression must evaluate to bool, got %s", program.result)}
+	}
+	return program, nil
+}
+
+// String returns the source of the expression
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the expression against the given variables
+func (p *Program) Eval(vars map[string]any) (any, error) {
+	return (&evaluator{vars: vars}).eval(p.root, nil)
+}
+
+// EvalBool evaluates an expression which must evaluate to a bool
+func (p *Program) EvalBool(vars map[string]any) (bool, error) {
+	value, err := p.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression evaluated to %s, not bool", kindName(kindOf(value)))
+	}
+	return result, nil
+}
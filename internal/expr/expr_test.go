@@ -0,0 +1,265 @@
+package expr
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEnv mirrors the variables declared by the gateway policies
+var testEnv = NewEnv(map[string]*Type{
+	"request": ObjectOf(map[string]*Type{
+		"method":  String,
+		"path":    String,
+		"headers": MapOf(String),
+	}),
+	"claims": MapOf(Dyn),
+	"count":  Int,
+	"ratio":  Double,
+	"tags":   ListOf(String),
+})
+
+// testVars are values matching testEnv
+func testVars() map[string]any {
+	return map[string]any{
+		"request": map[string]any{
+			"method":  "GET",
+			"path":    "/api/orders/42",
+			"headers": map[string]any{"x-tenant": "acme"},
+		},
+		"claims": map[string]any{
+			"email":  "alice@corp.com",
+			"groups": []any{"billing", "ops"},
+			"age":    float64(42),
+			"level":  int64(3),
+			"active": true,
+			"tenant": map[string]any{"id": "acme"},
+			"name":   "alice",
+			"regex":  "(",
+		},
+		"count": int64(3),
+		"ratio": 0.5,
+		"tags":  []string{"a", "b"},
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{name: "undeclared variable", source: `user.name == "alice"`, want: "undeclared reference"},
+		{name: "unknown object field", source: `request.body == ""`, want: "body"},
+		{name: "string plus int", source: `request.method + 1 == "GET1"`, want: "no matching overload"},
+		{name: "compare string and int", source: `request.method == 1`, want: "no matching overload"},
+		{name: "order string and int", source: `request.path > 1`, want: "no matching overload"},
+		{name: "negate string", source: `!request.method`, want: "no matching overload"},
+		{name: "minus string", source: `-request.method == ""`, want: "no matching overload"},
+		{name: "method argument type", source: `request.path.startsWith(1)`, want: "startsWith"},
+		{name: "method on int", source: `count.startsWith("a")`, want: "startsWith"},
+		{name: "unknown method", source: `request.path.reverse() == ""`, want: "reverse"},
+		{name: "unknown function", source: `lower(request.path) == ""`, want: "lower"},
+		{name: "wrong arity", source: `size(request.path, 1) == 1`, want: "size"},
+		{name: "size of int", source: `size(count) == 1`, want: "size"},
+		{name: "in string", source: `"a" in request.method`, want: "no matching overload"},
+		{name: "int key on map", source: `request.headers[1] == ""`, want: "string"},
+		{name: "string index on list", source: `tags["a"] == "a"`, want: "int"},
+		{name: "non bool condition", source: `request.method ? true : false`, want: "condition must be bool"},
+		{name: "non bool and", source: `request.method && true`, want: "no matching overload"},
+		{name: "int map keys", source: `{1: "a"} == {}`, want: "map keys must be strings"},
+		{name: "macro predicate", source: `tags.exists(t, t)`, want: "bool"},
+		{name: "invalid regex literal", source: `request.path.matches("(")`, want: "invalid regular expression"},
+		{name: "unbalanced parenthesis", source: `(request.method == "GET"`, want: ")"},
+		{name: "missing operand", source: `request.method ==`, want: "column"},
+		{name: "unterminated string", source: `request.method == "GET`, want: "column"},
+		{name: "unknown character", source: `request.method # "GET"`, want: "column"},
+		{name: "trailing tokens", source: `true false`, want: "column"},
+		{name: "empty", source: ``, want: "column"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testEnv.CompileBool(tt.source)
+			if err == nil {
+				t.Fatalf("CompileBool(%q) succeeded, want an error", tt.source)
+			}
+			var exprErr *Error
+			if !errors.As(err, &exprErr) {
+				t.Errorf("CompileBool(%q) error = %T, want *Error", tt.source, err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("CompileBool(%q) error = %q, want it to mention %q", tt.source, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileBoolRequiresBool(t *testing.T) {
+	for _, source := range []string{`request.method`, `count + 1`, `tags`, `{"a": true}`} {
+		if _, err := testEnv.CompileBool(source); err == nil || !strings.Contains(err.Error(), "must evaluate to bool") {
+			t.Errorf("CompileBool(%q) error = %v, want a bool result error", source, err)
+		}
+	}
+	// dyn values are only known at evaluation time
+	if _, err := testEnv.CompileBool(`claims.active`); err != nil {
+		t.Errorf("CompileBool(claims.active) error = %v", err)
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		source string
+		want   any
+	}{
+		{source: `request.method == "GET"`, want: true},
+		{source: `request.method != "GET"`, want: false},
+		{source: `request.path.startsWith("/api/") && request.path.endsWith("/42")`, want: true},
+		{source: `request.path.matches("^/api/orders/[0-9]+$")`, want: true},
+		{source: `request.headers["x-tenant"] == claims.tenant.id`, want: true},
+		{source: `claims.email.endsWith("@corp.com")`, want: true},
+		{source: `"billing" in claims.groups`, want: true},
+		{source: `"sales" in claims.groups`, want: false},
+		{source: `"email" in claims`, want: true},
+		{source: `has(claims.email)`, want: true},
+		{source: `has(claims.phone)`, want: false},
+		{source: `has(claims.tenant.id)`, want: true},
+		{source: `claims.groups.exists(g, g == "ops")`, want: true},
+		{source: `claims.groups.all(g, g.size() > 2)`, want: true},
+		{source: `claims.groups.exists_one(g, g.startsWith("b"))`, want: true},
+		{source: `claims.groups.filter(g, g != "ops")`, want: []any{"billing"}},
+		{source: `claims.groups.map(g, g.upperAscii())`, want: []any{"BILLING", "OPS"}},
+		{source: `tags.all(t, t in ["a", "b"])`, want: true},
+		{source: `claims.age >= 18`, want: true},
+		{source: `claims.age == 42`, want: true},
+		{source: `claims.level + count`, want: int64(6)},
+		{source: `count * 2 - 1`, want: int64(5)},
+		{source: `count / 2`, want: int64(1)},
+		{source: `count % 2`, want: int64(1)},
+		{source: `ratio * 2.0`, want: 1.0},
+		{source: `count > ratio`, want: true},
+		{source: `int("12") + 1`, want: int64(13)},
+		{source: `double(count) / 2.0`, want: 1.5},
+		{source: `string(count) + "x"`, want: "3x"},
+		{source: `size(claims.groups) == 2 && size(request.path) == 14`, want: true},
+		{source: `claims.name.size()`, want: int64(5)},
+		{source: `claims.email.split("@")[1]`, want: "corp.com"},
+		{source: `claims.name.lowerAscii() == "alice"`, want: true},
+		{source: `"  x ".trim()`, want: "x"},
+		{source: `claims.active ? "on" : "off"`, want: "on"},
+		{source: `[1, 2] + [3]`, want: []any{int64(1), int64(2), int64(3)}},
+		{source: `{"a": 1}.a`, want: int64(1)},
+		{source: `!(request.method == "POST") || false`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			program, err := testEnv.Compile(tt.source)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			got, err := program.Eval(testVars())
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Eval() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		vars   func(vars map[string]any)
+		want   string
+	}{
+		{name: "missing claim", source: `claims.email.endsWith("@corp.com")`, vars: func(vars map[string]any) {
+			delete(vars["claims"].(map[string]any), "email")
+		}, want: "no such key: email"},
+		{name: "missing nested claim", source: `claims.tenant.id == "acme"`, vars: func(vars map[string]any) {
+			vars["claims"].(map[string]any)["tenant"] = map[string]any{}
+		}, want: "no such key: id"},
+		{name: "missing map key", source: `request.headers["x-user"] == "alice"`, want: "no such key: x-user"},
+		{name: "claim of unexpected type", source: `claims.email.endsWith("@corp.com")`, vars: func(vars map[string]any) {
+			vars["claims"].(map[string]any)["email"] = []any{"alice@corp.com"}
+		}, want: "does not accept list"},
+		{name: "in over a string claim", source: `"ops" in claims.name`, want: "no matching overload"},
+		{name: "order over mixed claims", source: `claims.age > claims.name`, want: "no matching overload"},
+		{name: "field of a string claim", source: `claims.name.first == "a"`, want: "does not support field selection"},
+		{name: "index out of range", source: `claims.groups[5] == "x"`, want: "out of range"},
+		{name: "division by zero", source: `count / (count - 3) == 1`, want: "division by zero"},
+		{name: "int conversion", source: `int(claims.name) == 1`, want: "cannot convert"},
+		{name: "invalid regex claim", source: `claims.name.matches(claims.regex)`, want: "invalid regular expression"},
+		{name: "non bool dyn result", source: `claims.name`, want: "not bool"},
+		{name: "missing variable", source: `request.method == "GET"`, vars: func(vars map[string]any) {
+			delete(vars, "request")
+		}, want: "no value for variable"},
+		{name: "error not hidden by and", source: `claims.missing && true`, want: "no such key"},
+		{name: "error not hidden by or", source: `claims.missing || false`, want: "no such key"},
+		{name: "macro predicate error", source: `claims.groups.all(g, g.matches(claims.regex))`, want: "invalid regular expression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := testEnv.Compile(tt.source)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			vars := testVars()
+			if tt.vars != nil {
+				tt.vars(vars)
+			}
+			allowed, err := program.EvalBool(vars)
+			if err == nil {
+				t.Fatalf("EvalBool() = %v, want an error", allowed)
+			}
+			if allowed {
+				t.Error("EvalBool() returned true along with an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("EvalBool() error = %q, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogicalOperatorsAbsorbErrors(t *testing.T) {
+	// As in CEL, an error on one side is ignored when the other side decides
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{source: `false && claims.missing`, want: false},
+		{source: `claims.missing && false`, want: false},
+		{source: `true || claims.missing`, want: true},
+		{source: `claims.missing || true`, want: true},
+		{source: `has(claims.missing) && claims.missing == "x"`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			program, err := testEnv.CompileBool(tt.source)
+			if err != nil {
+				t.Fatalf("CompileBool() error = %v", err)
+			}
+			got, err := program.EvalBool(testVars())
+			if err != nil {
+				t.Fatalf("EvalBool() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("EvalBool() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgramString(t *testing.T) {
+	const source = `request.method == "GET"`
+	program, err := testEnv.CompileBool(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if program.String() != source {
+		t.Errorf("String() = %q, want %q", program.String(), source)
+	}
+}
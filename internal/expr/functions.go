@@ -0,0 +1,192 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// function describes a global function or a method. Methods receive their
+// target as first argument.
+type function struct {
+	// params lists the accepted kinds of each argument, target included for methods
+	params [][]Kind
+	result *Type
+	eval   func(args []any) (any, error)
+}
+
+// methods lists the functions callable as target.name(args)
+var methods = map[string]function{
+	"startsWith": stringPredicate(strings.HasPrefix),
+	"endsWith":   stringPredicate(strings.HasSuffix),
+	"contains":   stringPredicate(strings.Contains),
+	"matches": {
+		params: [][]Kind{{KindString}, {KindString}},
+		result: Bool,
+		eval: func(args []any) (any, error) {
+			re, err := compileRegexp(args[1].(string))
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(args[0].(string)), nil
+		},
+	},
+	"lowerAscii": stringTransform(strings.ToLower),
+	"upperAscii": stringTransform(strings.ToUpper),
+	"trim":       stringTransform(strings.TrimSpace),
+	"split": {
+		params: [][]Kind{{KindString}, {KindString}},
+		result: ListOf(String),
+		eval: func(args []any) (any, error) {
+			parts := strings.Split(args[0].(string), args[1].(string))
+			list := make([]any, len(parts))
+			for i, part := range parts {
+				list[i] = part
+			}
+			return list, nil
+		},
+	},
+	"size": sizeFunction,
+}
+
+// functions lists the global functions
+var functions = map[string]function{
+	"size": sizeFunction,
+	"int": {
+		params: [][]Kind{{KindInt, KindDouble, KindString}},
+		result: Int,
+		eval: func(args []any) (any, error) {
+			switch value := args[0].(type) {
+			case int64:
+				return value, nil
+			case float64:
+				if math.IsNaN(value) || value >= math.MaxInt64 || value < math.MinInt64 {
+					return nil, fmt.Errorf("int() overflow for %v", value)
+				}
+				return int64(value), nil
+			default:
+				parsed, err := strconv.ParseInt(value.(string), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("int() cannot convert %q", value)
+				}
+				return parsed, nil
+			}
+		},
+	},
+	"double": {
+		params: [][]Kind{{KindInt, KindDouble, KindString}},
+		result: Double,
+		eval: func(args []any) (any, error) {
+			switch value := args[0].(type) {
+			case int64:
+				return float64(value), nil
+			case float64:
+				return value, nil
+			default:
+				parsed, err := strconv.ParseFloat(value.(string), 64)
+				if err != nil {
+					return nil, fmt.Errorf("double() cannot convert %q", value)
+				}
+				return parsed, nil
+			}
+		},
+	},
+	"string": {
+		params: [][]Kind{{KindBool, KindInt, KindDouble, KindString}},
+		result: String,
+		eval: func(args []any) (any, error) {
+			switch value := args[0].(type) {
+			case int64:
+				return strconv.FormatInt(value, 10), nil
+			case float64:
+				return strconv.FormatFloat(value, 'g', -1, 64), nil
+			case bool:
+				return strconv.FormatBool(value), nil
+			default:
+				return value, nil
+			}
+		},
+	},
+}
+
+var sizeFunction = function{
+	params: [][]Kind{{KindString, KindList, KindMap}},
+	result: Int,
+	eval: func(args []any) (any, error) {
+		switch value := args[0].(type) {
+		case string:
+			return int64(len([]rune(value))), nil
+		case []any:
+			return int64(len(value)), nil
+		default:
+			return int64(len(value.(map[string]any))), nil
+		}
+	},
+}
+
+// stringPredicate builds a string method returning a bool
+func stringPredicate(predicate func(s, arg string) bool) function {
+	return function{
+		params: [][]Kind{{KindString}, {KindString}},
+		result: Bool,
+		eval: func(args []any) (any, error) {
+			return predicate(args[0].(string), args[1].(string)), nil
+		},
+	}
+}
+
+// stringTransform builds a string method without arguments returning a string
+func stringTransform(transform func(s string) string) function {
+	return function{
+		params: [][]Kind{{KindString}},
+		result: String,
+		eval: func(args []any) (any, error) {
+			return transform(args[0].(string)), nil
+		},
+	}
+}
+
+// regexps caches the regular expressions used by matches()
+var regexps sync.Map
+
+// compileRegexp compiles (once) a regular expression
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+	}
+	regexps.Store(pattern, re)
+	return re, nil
+}
+
+// kindOf returns the kind of a runtime value
+func kindOf(value any) Kind {
+	switch value.(type) {
+	case nil:
+		return KindNull
+	case bool:
+		return KindBool
+	case int64:
+		return KindInt
+	case float64:
+		return KindDouble
+	case string:
+		return KindString
+	case []any:
+		return KindList
+	case map[string]any:
+		return KindMap
+	}
+	return KindDyn
+}
+
+// kindName returns the name of a kind for error messages
+func kindName(kind Kind) string {
+	return (&Type{Kind: kind, Elem: Dyn}).String()
+}
@@ -0,0 +1,143 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind identifies a lexical token
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokDouble
+	tokString
+	tokOperator
+)
+
+// token is a lexical token and its offset in the source
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// operators lists the operators, longest first
+var operators = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"<", ">", "+", "-", "*", "/", "%", "!", "?", ":",
+	"(", ")", "[", "]", "{", "}", ".", ",",
+}
+
+// lex splits the source into tokens
+func lex(source string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(source); {
+		r, size := utf8.DecodeRuneInString(source[pos:])
+		switch {
+		case unicode.IsSpace(r):
+			pos += size
+		case r == '_' || unicode.IsLetter(r):
+			start := pos
+			for pos < len(source) {
+				r, size := utf8.DecodeRuneInString(source[pos:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				pos += size
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: source[start:pos], pos: start})
+		case unicode.IsDigit(r):
+			start := pos
+			kind := tokInt
+			for pos < len(source) && (isDigit(source[pos]) || source[pos] == '.' || source[pos] == 'e' || source[pos] == 'E') {
+				if source[pos] == '.' || source[pos] == 'e' || source[pos] == 'E' {
+					// a dot only continues the number when followed by a digit
+					if source[pos] == '.' && (pos+1 >= len(source) || !isDigit(source[pos+1])) {
+						break
+					}
+					kind = tokDouble
+					if (source[pos] == 'e' || source[pos] == 'E') && pos+1 < len(source) && (source[pos+1] == '+' || source[pos+1] == '-') {
+						pos++
+					}
+				}
+				pos++
+			}
+			tokens = append(tokens, token{kind: kind, text: source[start:pos], pos: start})
+		case r == '"' || r == '\'':
+			value, end, err := lexString(source, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokString, text: value, pos: pos})
+			pos = end
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[pos:], op) {
+					tokens = append(tokens, token{kind: tokOperator, text: op, pos: pos})
+					pos += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &Error{Pos: pos, Msg: fmt.Sprintf("unexpected character %q", r)}
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(source)}), nil
+}
+
+// lexString reads a quoted string literal starting at pos
+func lexString(source string, pos int) (string, int, error) {
+	quote := source[pos]
+	var b strings.Builder
+	for i := pos + 1; i < len(source); i++ {
+		switch c := source[i]; c {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(source) {
+				return "", 0, &Error{Pos: i, Msg: "unterminated escape sequence"}
+			}
+			i++
+			switch e := source[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '"', '\'':
+				b.WriteByte(e)
+			case 'u':
+				if i+4 >= len(source) {
+					return "", 0, &Error{Pos: i, Msg: "invalid unicode escape"}
+				}
+				code, err := strconv.ParseUint(source[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, &Error{Pos: i, Msg: "invalid unicode escape"}
+				}
+				b.WriteRune(rune(code))
+				i += 4
+			default:
+				// unknown escapes are kept as is so that regular expressions such as "\d+" work
+				b.WriteByte('\\')
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, &Error{Pos: pos, Msg: "unterminated string literal"}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,454 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Error reports a syntax, type or evaluation error and its position in the source
+type Error struct {
+	Pos int
+	Msg string
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos+1, e.Msg)
+}
+
+// node is an expression of the abstract syntax tree
+type node interface {
+	position() int
+}
+
+type (
+	literalNode struct {
+		pos   int
+		value any
+	}
+	identNode struct {
+		pos  int
+		name string
+	}
+	selectNode struct {
+		pos     int
+		operand node
+		field   string
+	}
+	indexNode struct {
+		pos     int
+		operand node
+		index   node
+	}
+	// callNode is a global function call when target is nil, a method call otherwise
+	callNode struct {
+		pos      int
+		target   node
+		function string
+		args     []node
+	}
+	unaryNode struct {
+		pos     int
+		op      string
+		operand node
+	}
+	binaryNode struct {
+		pos         int
+		op          string
+		left, right node
+	}
+	conditionalNode struct {
+		pos                   int
+		cond, ifTrue, ifFalse node
+	}
+	listNode struct {
+		pos      int
+		elements []node
+	}
+	mapNode struct {
+		pos          int
+		keys, values []node
+	}
+	// hasNode is the has(x.field) macro
+	hasNode struct {
+		pos    int
+		target *selectNode
+	}
+	// comprehensionNode is one of the exists, all, exists_one, filter and map macros
+	comprehensionNode struct {
+		pos      int
+		macro    string
+		rangeOf  node
+		variable string
+		body     node
+	}
+)
+
+func (n *literalNode) position() int       { return n.pos }
+func (n *identNode) position() int         { return n.pos }
+func (n *selectNode) position() int        { return n.pos }
+func (n *indexNode) position() int         { return n.pos }
+func (n *callNode) position() int          { return n.pos }
+func (n *unaryNode) position() int         { return n.pos }
+func (n *binaryNode) position() int        { return n.pos }
+func (n *conditionalNode) position() int   { return n.pos }
+func (n *listNode) position() int          { return n.pos }
+func (n *mapNode) position() int           { return n.pos }
+func (n *hasNode) position() int           { return n.pos }
+func (n *comprehensionNode) position() int { return n.pos }
+
+// macros lists the methods taking a variable name and a predicate
+var macros = map[string]bool{
+	"exists":     true,
+	"all":        true,
+	"exists_one": true,
+	"filter":     true,
+	"map":        true,
+}
+
+// parser is a recursive descent parser following the CEL grammar precedence
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse builds the syntax tree of an expression
+func parse(source string) (node, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, &Error{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %q", tok.text)}
+	}
+	return root, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the given operator or keyword
+func (p *parser) accept(text string) bool {
+	tok := p.peek()
+	if (tok.kind == tokOperator || tok.kind == tokIdent) && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if p.accept(text) {
+		return nil
+	}
+	tok := p.peek()
+	if tok.kind == tokEOF {
+		return &Error{Pos: tok.pos, Msg: fmt.Sprintf("expected %q, got end of expression", text)}
+	}
+	return &Error{Pos: tok.pos, Msg: fmt.Sprintf("expected %q, got %q", text, tok.text)}
+}
+
+// expression = or ["?" or ":" expression]
+func (p *parser) expression() (node, error) {
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if !p.accept("?") {
+		return cond, nil
+	}
+	ifTrue, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	ifFalse, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return &conditionalNode{pos: tok.pos, cond: cond, ifTrue: ifTrue, ifFalse: ifFalse}, nil
+}
+
+// binary parses a left-associative sequence of operands separated by ops
+func (p *parser) binary(operand func() (node, error), ops ...string) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		matched := ""
+		for _, op := range ops {
+			if (tok.kind == tokOperator || (tok.kind == tokIdent && op == "in")) && tok.text == op {
+				matched = op
+				break
+			}
+		}
+		if matched == "" {
+			return left, nil
+		}
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{pos: tok.pos, op: matched, left: left, right: right}
+	}
+}
+
+func (p *parser) or() (node, error) {
+	return p.binary(p.and, "||")
+}
+
+func (p *parser) and() (node, error) {
+	return p.binary(p.relation, "&&")
+}
+
+func (p *parser) relation() (node, error) {
+	return p.binary(p.addition, "==", "!=", "<", "<=", ">", ">=", "in")
+}
+
+func (p *parser) addition() (node, error) {
+	return p.binary(p.multiplication, "+", "-")
+}
+
+func (p *parser) multiplication() (node, error) {
+	return p.binary(p.unary, "*", "/", "%")
+}
+
+// unary = ("!" | "-") unary | member
+func (p *parser) unary() (node, error) {
+	tok := p.peek()
+	if tok.kind == tokOperator && (tok.text == "!" || tok.text == "-") {
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		// -1 is a literal rather than the negation of 1
+		if literal, ok := operand.(*literalNode); ok && tok.text == "-" {
+			switch value := literal.value.(type) {
+			case int64:
+				return &literalNode{pos: tok.pos, value: -value}, nil
+			case float64:
+				return &literalNode{pos: tok.pos, value: -value}, nil
+			}
+		}
+		return &unaryNode{pos: tok.pos, op: tok.text, operand: operand}, nil
+	}
+	return p.member()
+}
+
+// member = primary {"." ident ["(" args ")"] | "[" expression "]"}
+func (p *parser) member() (node, error) {
+	operand, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, &Error{Pos: name.pos, Msg: "expected field or method name after '.'"}
+			}
+			if !p.accept("(") {
+				operand = &selectNode{pos: name.pos, operand: operand, field: name.text}
+				continue
+			}
+			if macros[name.text] {
+				operand, err = p.comprehension(name, operand)
+			} else {
+				var args []node
+				args, err = p.arguments()
+				operand = &callNode{pos: name.pos, target: operand, function: name.text, args: args}
+			}
+			if err != nil {
+				return nil, err
+			}
+		case p.accept("["):
+			index, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			operand = &indexNode{pos: tok.pos, operand: operand, index: index}
+		default:
+			return operand, nil
+		}
+	}
+}
+
+// comprehension parses the arguments of a macro: a variable name and an expression
+func (p *parser) comprehension(name token, rangeOf node) (node, error) {
+	variable := p.next()
+	if variable.kind != tokIdent {
+		return nil, &Error{Pos: variable.pos, Msg: fmt.Sprintf("%s() expects a variable name as first argument", name.text)}
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	body, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return &comprehensionNode{pos: name.pos, macro: name.text, rangeOf: rangeOf, variable: variable.text, body: body}, nil
+}
+
+// arguments parses a comma separated list of expressions up to the closing parenthesis
+func (p *parser) arguments() ([]node, error) {
+	return p.list(")")
+}
+
+// list parses comma separated expressions up to the closing delimiter
+func (p *parser) list(closing string) ([]node, error) {
+	var elements []node
+	if p.accept(closing) {
+		return elements, nil
+	}
+	for {
+		element, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		if p.accept(closing) {
+			return elements, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		// a trailing comma is allowed
+		if p.accept(closing) {
+			return elements, nil
+		}
+	}
+}
+
+// primary = literal | ident ["(" args ")"] | "(" expression ")" | list | map
+func (p *parser) primary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokInt:
+		value, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, &Error{Pos: tok.pos, Msg: fmt.Sprintf("invalid integer %q", tok.text)}
+		}
+		return &literalNode{pos: tok.pos, value: value}, nil
+	case tokDouble:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, &Error{Pos: tok.pos, Msg: fmt.Sprintf("invalid number %q", tok.text)}
+		}
+		return &literalNode{pos: tok.pos, value: value}, nil
+	case tokString:
+		return &literalNode{pos: tok.pos, value: tok.text}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{pos: tok.pos, value: true}, nil
+		case "false":
+			return &literalNode{pos: tok.pos, value: false}, nil
+		case "null":
+			return &literalNode{pos: tok.pos, value: nil}, nil
+		case "in":
+			return nil, &Error{Pos: tok.pos, Msg: "unexpected 'in'"}
+		}
+		if !p.accept("(") {
+			return &identNode{pos: tok.pos, name: tok.text}, nil
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "has" {
+			if len(args) != 1 {
+				return nil, &Error{Pos: tok.pos, Msg: "has() expects a single field selection"}
+			}
+			target, ok := args[0].(*selectNode)
+			if !ok {
+				return nil, &Error{Pos: tok.pos, Msg: "has() argument must be a field selection such as has(claims.email)"}
+			}
+			return &hasNode{pos: tok.pos, target: target}, nil
+		}
+		return &callNode{pos: tok.pos, function: tok.text, args: args}, nil
+	case tokOperator:
+		switch tok.text {
+		case "(":
+			inner, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		case "[":
+			elements, err := p.list("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{pos: tok.pos, elements: elements}, nil
+		case "{":
+			return p.mapLiteral(tok)
+		}
+	case tokEOF:
+		return nil, &Error{Pos: tok.pos, Msg: "unexpected end of expression"}
+	}
+	return nil, &Error{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %q", tok.text)}
+}
+
+// mapLiteral parses {key: value, ...} once the opening brace is consumed
+func (p *parser) mapLiteral(open token) (node, error) {
+	result := &mapNode{pos: open.pos}
+	if p.accept("}") {
+		return result, nil
+	}
+	for {
+		key, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		result.keys = append(result.keys, key)
+		result.values = append(result.values, value)
+		if p.accept("}") {
+			return result, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		if p.accept("}") {
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package expr implements a small, CEL-like expression language used to
+// write authorization policies. Expressions are parsed and type-checked
+// against declared variables when compiled, then evaluated against plain Go
+// values (bool, int64, float64, string, []any, map[string]any and nil).
+package expr
+
+import (
+	"sort"
+	"strings"
+)
+
+// Kind identifies the family of a Type
+type Kind int
+
+// Supported kinds
+const (
+	KindDyn Kind = iota
+	KindNull
+	KindBool
+	KindInt
+	KindDouble
+	KindString
+	KindList
+	KindMap
+	KindObject
+)
+
+// Type describes the static type of an expression
+type Type struct {
+	Kind Kind
+	// Elem is the element type of lists and the value type of maps
+	Elem *Type
+	// Fields lists the known fields of objects
+	Fields map[string]*Type
+}
+
+// Predefined scalar types
+var (
+	Dyn    = &Type{Kind: KindDyn}
+	Null   = &Type{Kind: KindNull}
+	Bool   = &Type{Kind: KindBool}
+	Int    = &Type{Kind: KindInt}
+	Double = &Type{Kind: KindDouble}
+	String = &Type{Kind: KindString}
+)
+
+// ListOf returns the type of lists of elem
+func ListOf(elem *Type) *Type {
+	return &Type{Kind: KindList, Elem: elem}
+}
+
+// MapOf returns the type of string-keyed maps of elem
+func MapOf(elem *Type) *Type {
+	return &Type{Kind: KindMap, Elem: elem}
+}
+
+// ObjectOf returns the type of objects with the given fields
+func ObjectOf(fields map[string]*Type) *Type {
+	return &Type{Kind: KindObject, Fields: fields}
+}
+
+// String returns a readable representation of the type
+func (t *Type) String() string {
+	switch t.Kind {
+	case KindNull:
+		return "null"
+	case KindBool:
+		return "bool"
+	case KindInt:
+		return "int"
+	case KindDouble:
+		return "double"
+	case KindString:
+		return "string"
+	case KindList:
+		return "list(" + t.elem().String() + ")"
+	case KindMap:
+		return "map(string, " + t.elem().String() + ")"
+	case KindObject:
+		names := make([]string, 0, len(t.Fields))
+		for name := range t.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "object{" + strings.Join(names, ", ") + "}"
+	default:
+		return "dyn"
+	}
+}
+
+// is reports whether a value of type t may be of the given kind
+func (t *Type) is(kinds ...Kind) bool {
+	if t.Kind == KindDyn {
+		return true
+	}
+	for _, kind := range kinds {
+		if t.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// numeric reports whether a value of type t may be a number
+func (t *Type) numeric() bool {
+	return t.is(KindInt, KindDouble)
+}
+
+// elem returns the element type of a list or map, dyn otherwise
+func (t *Type) elem() *Type {
+	if t.Elem != nil {
+		return t.Elem
+	}
+	return Dyn
+}
+
+// same reports whether two types are identical
+func same(a, b *Type) bool {
+	if a.Kind != b.Kind {
+		return false
+	}
+	switch a.Kind {
+	case KindList, KindMap:
+		return same(a.elem(), b.elem())
+	case KindObject:
+		return a == b
+	}
+	return true
+}
+
+// comparable reports whether values of types a and b may be compared for equality
+func comparable(a, b *Type) bool {
+	if a.Kind == KindDyn || b.Kind == KindDyn || a.Kind == KindNull || b.Kind == KindNull {
+		return true
+	}
+	if a.numeric() && b.numeric() {
+		return true
+	}
+	return a.Kind == b.Kind
+}
@@ -0,0 +1,136 @@
+package rego
+
+// term is a value expression of a policy
+type term interface {
+	position() position
+}
+
+type (
+	// scalarTerm is null, a boolean, a number or a string
+	scalarTerm struct {
+		pos   position
+		value any
+	}
+	varTerm struct {
+		pos  position
+		name string
+	}
+	// refTerm reads head.path[0].path[1]...; unbound variables in the path
+	// iterate over the collection
+	refTerm struct {
+		pos  position
+		head term
+		path []term
+	}
+	arrayTerm struct {
+		pos      position
+		elements []term
+	}
+	setTerm struct {
+		pos      position
+		elements []term
+	}
+	objectTerm struct {
+		pos          position
+		keys, values []term
+	}
+	callTerm struct {
+		pos  position
+		name string
+		args []term
+	}
+	unaryTerm struct {
+		pos     position
+		op      string
+		operand term
+	}
+	binaryTerm struct {
+		pos         position
+		op          string
+		left, right term
+	}
+	// comprehensionTerm is [value | body], {value | body} or {key: value | body}
+	comprehensionTerm struct {
+		pos   position
+		kind  string
+		key   term
+		value term
+		body  []*expr
+	}
+)
+
+func (t *scalarTerm) position() position        { return t.pos }
+func (t *varTerm) position() position           { return t.pos }
+func (t *refTerm) position() position           { return t.pos }
+func (t *arrayTerm) position() position         { return t.pos }
+func (t *setTerm) position() position           { return t.pos }
+func (t *objectTerm) position() position        { return t.pos }
+func (t *callTerm) position() position          { return t.pos }
+func (t *unaryTerm) position() position         { return t.pos }
+func (t *binaryTerm) position() position        { return t.pos }
+func (t *comprehensionTerm) position() position { return t.pos }
+
+// exprKind identifies the form of a body expression
+type exprKind int
+
+const (
+	// exprTerm succeeds when its term is defined and not false
+	exprTerm exprKind = iota
+	// exprAssign is x := value
+	exprAssign
+	// exprUnify is a = b
+	exprUnify
+	// exprSome declares local variables
+	exprSome
+	// exprSomeIn is some [key,] value in collection
+	exprSomeIn
+	// exprEvery is every [key,] value in collection { body }
+	exprEvery
+)
+
+// expr is an expression of a rule body
+type expr struct {
+	pos     position
+	kind    exprKind
+	negated bool
+
+	term        term
+	left, right term
+
+	// some and every
+	vars       []string
+	key, value term
+	collection term
+	body       []*expr
+}
+
+// ruleKind identifies the kind of document a rule produces
+type ruleKind int
+
+const (
+	ruleComplete ruleKind = iota
+	ruleSet
+	ruleObject
+	ruleFunction
+)
+
+// rule is one definition of a rule; several definitions may share a name
+type rule struct {
+	pos       position
+	module    *module
+	name      string
+	kind      ruleKind
+	isDefault bool
+	args      []term
+	key       term
+	value     term
+	body      []*expr
+}
+
+// module is a parsed policy file
+type module struct {
+	file    string
+	pkg     string
+	imports map[string]term
+	rules   []*rule
+}
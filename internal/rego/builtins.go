@@ -0,0 +1,439 @@
+package rego
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// builtin evaluates a built-in function; ok is false when the result is
+// undefined, for instance when an argument has an unexpected type
+type builtin struct {
+	arity int
+	fn    func(args []any) (result any, ok bool)
+}
+
+var builtins = map[string]builtin{
+	"count": {1, func(args []any) (any, bool) {
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), true
+		case []any:
+			return float64(len(v)), true
+		case map[string]any:
+			return float64(len(v)), true
+		case *Set:
+			return float64(len(v.elements)), true
+		}
+		return nil, false
+	}},
+	"sum":     {1, numbersReduce(0, func(acc, n float64) float64 { return acc + n })},
+	"product": {1, numbersReduce(1, func(acc, n float64) float64 { return acc * n })},
+	"max":     {1, extremum(1)},
+	"min":     {1, extremum(-1)},
+	"sort": {1, func(args []any) (any, bool) {
+		elements, ok := elementsOf(args[0])
+		if !ok {
+			return nil, false
+		}
+		sorted := append([]any(nil), elements...)
+		sort.SliceStable(sorted, func(i, j int) bool { return compareValues(sorted[i], sorted[j]) < 0 })
+		return sorted, true
+	}},
+	"abs":   {1, number(math.Abs)},
+	"round": {1, number(math.Round)},
+	"ceil":  {1, number(math.Ceil)},
+	"floor": {1, number(math.Floor)},
+
+	"concat": {2, func(args []any) (any, bool) {
+		delimiter, ok := args[0].(string)
+		if !ok {
+			return nil, false
+		}
+		elements, ok := elementsOf(args[1])
+		if !ok {
+			return nil, false
+		}
+		parts := make([]string, len(elements))
+		for i, element := range elements {
+			if parts[i], ok = element.(string); !ok {
+				return nil, false
+			}
+		}
+		return strings.Join(parts, delimiter), true
+	}},
+	"contains":    {2, strings2(func(s, sub string) any { return strings.Contains(s, sub) })},
+	"startswith":  {2, strings2(func(s, prefix string) any { return strings.HasPrefix(s, prefix) })},
+	"endswith":    {2, strings2(func(s, suffix string) any { return strings.HasSuffix(s, suffix) })},
+	"indexof":     {2, strings2(func(s, sub string) any { return float64(strings.Index(s, sub)) })},
+	"trim":        {2, strings2(func(s, cutset string) any { return strings.Trim(s, cutset) })},
+	"trim_left":   {2, strings2(func(s, cutset string) any { return strings.TrimLeft(s, cutset) })},
+	"trim_right":  {2, strings2(func(s, cutset string) any { return strings.TrimRight(s, cutset) })},
+	"trim_prefix": {2, strings2(func(s, prefix string) any { return strings.TrimPrefix(s, prefix) })},
+	"trim_suffix": {2, strings2(func(s, suffix string) any { return strings.TrimSuffix(s, suffix) })},
+	"split": {2, strings2(func(s, sep string) any {
+		parts := strings.Split(s, sep)
+		list := make([]any, len(parts))
+		for i, part := range parts {
+			list[i] = part
+		}
+		return list
+	})},
+	"lower":      {1, string1(strings.ToLower)},
+	"upper":      {1, string1(strings.ToUpper)},
+	"trim_space": {1, string1(strings.TrimSpace)},
+	"replace": {3, func(args []any) (any, bool) {
+		s, ok1 := args[0].(string)
+		old, ok2 := args[1].(string)
+		replacement, ok3 := args[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, false
+		}
+		return strings.ReplaceAll(s, old, replacement), true
+	}},
+	"substring": {3, func(args []any) (any, bool) {
+		s, ok := args[0].(string)
+		start, ok2 := toInt(args[1])
+		length, ok3 := toInt(args[2])
+		if !ok || !ok2 || !ok3 || start < 0 {
+			return nil, false
+		}
+		runes := []rune(s)
+		if start >= len(runes) {
+			return "", true
+		}
+		end := len(runes)
+		if length >= 0 && start+length < end {
+			end = start + length
+		}
+		return string(runes[start:end]), true
+	}},
+	"sprintf": {2, func(args []any) (any, bool) {
+		format, ok := args[0].(string)
+		if !ok {
+			return nil, false
+		}
+		values, ok := args[1].([]any)
+		if !ok {
+			return nil, false
+		}
+		formatted := make([]any, len(values))
+		for i, value := range values {
+			// integral numbers are formatted as integers, as OPA does
+			if n, ok := toInt(value); ok {
+				formatted[i] = n
+			} else {
+				formatted[i] = export(value)
+			}
+		}
+		return fmt.Sprintf(format, formatted...), true
+	}},
+	"to_number": {1, func(args []any) (any, bool) {
+		switch v := args[0].(type) {
+		case float64:
+			return v, true
+		case bool:
+			if v {
+				return float64(1), true
+			}
+			return float64(0), true
+		case string:
+			n, err := strconv.ParseFloat(v, 64)
+			return n, err == nil
+		case nil:
+			return float64(0), true
+		}
+		return nil, false
+	}},
+	"regex.match": {2, func(args []any) (any, bool) {
+		pattern, ok1 := args[0].(string)
+		value, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return nil, false
+		}
+		return re.MatchString(value), true
+	}},
+
+	"is_null":    {1, isType(0)},
+	"is_boolean": {1, isType(1)},
+	"is_number":  {1, isType(2)},
+	"is_string":  {1, isType(3)},
+	"is_array":   {1, isType(4)},
+	"is_object":  {1, isType(5)},
+	"is_set":     {1, isType(6)},
+	"type_name": {1, func(args []any) (any, bool) {
+		names := []string{"null", "boolean", "number", "string", "array", "object", "set"}
+		if rank := typeRank(args[0]); rank < len(names) {
+			return names[rank], true
+		}
+		return nil, false
+	}},
+
+	"array.concat": {2, func(args []any) (any, bool) {
+		a, ok1 := args[0].([]any)
+		b, ok2 := args[1].([]any)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		return append(append([]any{}, a...), b...), true
+	}},
+	"array.slice": {3, func(args []any) (any, bool) {
+		a, ok := args[0].([]any)
+		start, ok2 := toInt(args[1])
+		end, ok3 := toInt(args[2])
+		if !ok || !ok2 || !ok3 {
+			return nil, false
+		}
+		start = max(0, min(start, len(a)))
+		end = max(start, min(end, len(a)))
+		return append([]any{}, a[start:end]...), true
+	}},
+	"object.get": {3, func(args []any) (any, bool) {
+		object, ok := args[0].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		path, isPath := args[1].([]any)
+		if !isPath {
+			path = []any{args[1]}
+		}
+		var value any = object
+		for _, key := range path {
+			next, found := lookup(value, key)
+			if !found {
+				return args[2], true
+			}
+			value = next
+		}
+		return value, true
+	}},
+	"object.keys": {1, func(args []any) (any, bool) {
+		object, ok := args[0].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		keys := newSet()
+		for key := range object {
+			keys.add(key)
+		}
+		return keys, true
+	}},
+	"set": {0, func(args []any) (any, bool) {
+		return newSet(), true
+	}},
+	"union":        {1, unionOfSets},
+	"intersection": {1, intersectionOfSets},
+
+	"net.cidr_contains": {2, func(args []any) (any, bool) {
+		cidr, ok1 := args[0].(string)
+		address, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, false
+		}
+		if ip := net.ParseIP(address); ip != nil {
+			return network.Contains(ip), true
+		}
+		ip, inner, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, false
+		}
+		innerOnes, _ := inner.Mask.Size()
+		ones, _ := network.Mask.Size()
+		return network.Contains(ip) && innerOnes >= ones, true
+	}},
+	"time.now_ns": {0, func(args []any) (any, bool) {
+		return float64(time.Now().UnixNano()), true
+	}},
+	"time.parse_rfc3339_ns": {1, func(args []any) (any, bool) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, false
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, false
+		}
+		return float64(t.UnixNano()), true
+	}},
+	"json.marshal": {1, func(args []any) (any, bool) {
+		data, err := json.Marshal(export(args[0]))
+		if err != nil {
+			return nil, false
+		}
+		return string(data), true
+	}},
+	"json.unmarshal": {1, func(args []any) (any, bool) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, false
+		}
+		var value any
+		if err := json.Unmarshal([]byte(s), &value); err != nil {
+			return nil, false
+		}
+		return normalize(value), true
+	}},
+}
+
+func number(fn func(float64) float64) func(args []any) (any, bool) {
+	return func(args []any) (any, bool) {
+		n, ok := args[0].(float64)
+		if !ok {
+			return nil, false
+		}
+		return fn(n), true
+	}
+}
+
+func string1(fn func(string) string) func(args []any) (any, bool) {
+	return func(args []any) (any, bool) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, false
+		}
+		return fn(s), true
+	}
+}
+
+func strings2(fn func(a, b string) any) func(args []any) (any, bool) {
+	return func(args []any) (any, bool) {
+		a, ok1 := args[0].(string)
+		b, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		return fn(a, b), true
+	}
+}
+
+func isType(rank int) func(args []any) (any, bool) {
+	return func(args []any) (any, bool) {
+		return typeRank(args[0]) == rank, true
+	}
+}
+
+// elementsOf returns the elements of an array or a set
+func elementsOf(value any) ([]any, bool) {
+	switch v := value.(type) {
+	case []any:
+		return v, true
+	case *Set:
+		return v.Elements(), true
+	}
+	return nil, false
+}
+
+func numbersReduce(initial float64, fn func(acc, n float64) float64) func(args []any) (any, bool) {
+	return func(args []any) (any, bool) {
+		elements, ok := elementsOf(args[0])
+		if !ok {
+			return nil, false
+		}
+		acc := initial
+		for _, element := range elements {
+			n, ok := element.(float64)
+			if !ok {
+				return nil, false
+			}
+			acc = fn(acc, n)
+		}
+		return acc, true
+	}
+}
+
+// extremum returns the largest (sign 1) or smallest (sign -1) element
+func extremum(sign int) func(args []any) (any, bool) {
+	return func(args []any) (any, bool) {
+		elements, ok := elementsOf(args[0])
+		if !ok || len(elements) == 0 {
+			return nil, false
+		}
+		result := elements[0]
+		for _, element := range elements[1:] {
+			if compareValues(element, result)*sign > 0 {
+				result = element
+			}
+		}
+		return result, true
+	}
+}
+
+// setsOf returns the elements of a set of sets
+func setsOf(value any) ([]*Set, bool) {
+	outer, ok := value.(*Set)
+	if !ok {
+		return nil, false
+	}
+	sets := make([]*Set, len(outer.elements))
+	for i, element := range outer.elements {
+		if sets[i], ok = element.(*Set); !ok {
+			return nil, false
+		}
+	}
+	return sets, true
+}
+
+func unionOfSets(args []any) (any, bool) {
+	sets, ok := setsOf(args[0])
+	if !ok {
+		return nil, false
+	}
+	result := newSet()
+	for _, s := range sets {
+		for _, element := range s.elements {
+			result.add(element)
+		}
+	}
+	return result, true
+}
+
+func intersectionOfSets(args []any) (any, bool) {
+	sets, ok := setsOf(args[0])
+	if !ok {
+		return nil, false
+	}
+	result := newSet()
+	if len(sets) == 0 {
+		return result, true
+	}
+	for _, element := range sets[0].elements {
+		inAll := true
+		for _, s := range sets[1:] {
+			inAll = inAll && s.has(element)
+		}
+		if inAll {
+			result.add(element)
+		}
+	}
+	return result, true
+}
+
+// regexps caches the regular expressions used by regex.match
+var regexps sync.Map
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexps.Store(pattern, re)
+	return re, nil
+}
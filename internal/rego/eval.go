@@ -0,0 +1,823 @@
+package rego
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// errStop ends an enumeration once the awaited solution has been found
+var errStop = errors.New("stop")
+
+// unbound shadows an outer variable declared again with some
+type unbound struct{}
+
+// bindings is an immutable list of variable values
+type bindings struct {
+	name   string
+	value  any
+	parent *bindings
+}
+
+func (b *bindings) bind(name string, value any) *bindings {
+	return &bindings{name: name, value: value, parent: b}
+}
+
+func (b *bindings) lookup(name string) (any, bool) {
+	for ; b != nil; b = b.parent {
+		if b.name == name {
+			if _, ok := b.value.(unbound); ok {
+				return nil, false
+			}
+			return b.value, true
+		}
+	}
+	return nil, false
+}
+
+// declared reports whether some declared the variable in the current scope
+func (b *bindings) declared(name string) bool {
+	for ; b != nil; b = b.parent {
+		if b.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleResult is the memoized value of a rule during an evaluation
+type ruleResult struct {
+	value   any
+	defined bool
+}
+
+// evaluation holds the state of one query evaluation
+type evaluation struct {
+	ctx    context.Context
+	engine *Engine
+	input  any
+	cache  map[string]ruleResult
+	active map[string]bool
+}
+
+// frame evaluates terms and expressions in the context of a module
+type frame struct {
+	eval   *evaluation
+	module *module
+}
+
+type (
+	yieldBindings func(b *bindings) error
+	yieldValue    func(value any, b *bindings) error
+)
+
+// body enumerates the solutions of a rule body
+func (f frame) body(body []*expr, b *bindings, yield yieldBindings) error {
+	if len(body) == 0 {
+		return yield(b)
+	}
+	if err := f.eval.ctx.Err(); err != nil {
+		return err
+	}
+	return f.expr(body[0], b, func(b *bindings) error {
+		return f.body(body[1:], b, yield)
+	})
+}
+
+// expr enumerates the solutions of a body expression
+func (f frame) expr(e *expr, b *bindings, yield yieldBindings) error {
+	if !e.negated {
+		return f.positive(e, b, yield)
+	}
+	found := false
+	err := f.positive(e, b, func(*bindings) error {
+		found = true
+		return errStop
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return err
+	}
+	if found {
+		return nil
+	}
+	return yield(b)
+}
+
+func (f frame) positive(e *expr, b *bindings, yield yieldBindings) error {
+	switch e.kind {
+	case exprTerm:
+		return f.term(e.term, b, func(value any, b *bindings) error {
+			if value == false {
+				return nil
+			}
+			return yield(b)
+		})
+	case exprAssign:
+		return f.term(e.right, b, func(value any, b *bindings) error {
+			return f.match(e.left, value, b, true, yield)
+		})
+	case exprUnify:
+		return f.unify(e.left, e.right, b, yield)
+	case exprSome:
+		for _, name := range e.vars {
+			b = b.bind(name, unbound{})
+		}
+		return yield(b)
+	case exprSomeIn:
+		return f.term(e.collection, b, func(collection any, b *bindings) error {
+			return iterate(collection, func(key, value any) error {
+				return f.match(e.value, value, b, true, func(b *bindings) error {
+					if e.key == nil {
+						return yield(b)
+					}
+					return f.match(e.key, key, b, true, yield)
+				})
+			})
+		})
+	case exprEvery:
+		return f.every(e, b, yield)
+	}
+	return &Error{Pos: e.pos, Msg: "unsupported expression"}
+}
+
+// every succeeds when the body holds for each element of the collection; it
+// fails when the collection is undefined or is not a collection
+func (f frame) every(e *expr, b *bindings, yield yieldBindings) error {
+	holds, defined := true, false
+	err := f.term(e.collection, b, func(collection any, inner *bindings) error {
+		defined = true
+		switch collection.(type) {
+		case []any, map[string]any, *Set:
+		default:
+			holds = false
+			return errStop
+		}
+		return iterate(collection, func(key, value any) error {
+			found := false
+			err := f.match(e.value, value, inner, true, func(b *bindings) error {
+				if e.key != nil {
+					return f.match(e.key, key, b, true, func(b *bindings) error {
+						return f.body(e.body, b, func(*bindings) error { found = true; return errStop })
+					})
+				}
+				return f.body(e.body, b, func(*bindings) error { found = true; return errStop })
+			})
+			if err != nil && !errors.Is(err, errStop) {
+				return err
+			}
+			if !found {
+				holds = false
+				return errStop
+			}
+			return nil
+		})
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return err
+	}
+	if !defined || !holds {
+		return nil
+	}
+	return yield(b)
+}
+
+// isUnbound reports whether a variable has no value yet and can be bound
+func (f frame) isUnbound(t term, b *bindings) bool {
+	v, ok := t.(*varTerm)
+	if !ok {
+		return false
+	}
+	if _, bound := b.lookup(v.name); bound {
+		return false
+	}
+	if b.declared(v.name) {
+		return true
+	}
+	if v.name == "input" || v.name == "data" {
+		return false
+	}
+	if _, ok := f.module.imports[v.name]; ok {
+		return false
+	}
+	return len(f.eval.engine.rules(f.module.pkg, v.name)) == 0
+}
+
+// match binds the variables of a pattern to a value. With declare, the
+// variables are new local variables (:=, some); otherwise only unbound
+// variables are bound and the others are compared.
+func (f frame) match(pattern term, value any, b *bindings, declare bool, yield yieldBindings) error {
+	switch p := pattern.(type) {
+	case *varTerm:
+		if declare || f.isUnbound(p, b) {
+			return yield(b.bind(p.name, value))
+		}
+	case *arrayTerm:
+		elements, ok := value.([]any)
+		if !ok || len(elements) != len(p.elements) {
+			return nil
+		}
+		return f.matchAll(p.elements, elements, b, declare, yield)
+	case *objectTerm:
+		object, ok := value.(map[string]any)
+		if !ok || len(object) != len(p.keys) {
+			return nil
+		}
+		values := make([]any, len(p.keys))
+		for i, key := range p.keys {
+			scalar, ok := key.(*scalarTerm)
+			if !ok {
+				return &Error{Pos: key.position(), Msg: "object pattern keys must be constants"}
+			}
+			name, ok := scalar.value.(string)
+			if !ok {
+				return nil
+			}
+			if values[i], ok = object[name]; !ok {
+				return nil
+			}
+		}
+		return f.matchAll(p.values, values, b, declare, yield)
+	}
+	return f.term(pattern, b, func(expected any, b *bindings) error {
+		if !equalValues(expected, value) {
+			return nil
+		}
+		return yield(b)
+	})
+}
+
+func (f frame) matchAll(patterns []term, values []any, b *bindings, declare bool, yield yieldBindings) error {
+	if len(patterns) == 0 {
+		return yield(b)
+	}
+	return f.match(patterns[0], values[0], b, declare, func(b *bindings) error {
+		return f.matchAll(patterns[1:], values[1:], b, declare, yield)
+	})
+}
+
+// unify implements a = b: the ground side is evaluated and the other side
+// matched against its value; two arrays are unified element by element, so
+// that each of them may bind variables of the other
+func (f frame) unify(left, right term, b *bindings, yield yieldBindings) error {
+	leftArray, leftIsArray := left.(*arrayTerm)
+	rightArray, rightIsArray := right.(*arrayTerm)
+	if leftIsArray && rightIsArray && !(f.isGround(left, b) && f.isGround(right, b)) {
+		if len(leftArray.elements) != len(rightArray.elements) {
+			return nil
+		}
+		return f.unifyAll(leftArray.elements, rightArray.elements, b, yield)
+	}
+	if !f.isGround(left, b) && f.isGround(right, b) {
+		left, right = right, left
+	}
+	return f.term(left, b, func(value any, b *bindings) error {
+		return f.match(right, value, b, false, yield)
+	})
+}
+
+func (f frame) unifyAll(left, right []term, b *bindings, yield yieldBindings) error {
+	if len(left) == 0 {
+		return yield(b)
+	}
+	return f.unify(left[0], right[0], b, func(b *bindings) error {
+		return f.unifyAll(left[1:], right[1:], b, yield)
+	})
+}
+
+// isGround reports whether a pattern has no unbound variable at its top level
+func (f frame) isGround(t term, b *bindings) bool {
+	switch p := t.(type) {
+	case *varTerm:
+		return !f.isUnbound(p, b)
+	case *arrayTerm:
+		for _, element := range p.elements {
+			if !f.isGround(element, b) {
+				return false
+			}
+		}
+	case *objectTerm:
+		for _, value := range p.values {
+			if !f.isGround(value, b) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// term enumerates the values of a term
+func (f frame) term(t term, b *bindings, yield yieldValue) error {
+	switch t := t.(type) {
+	case *scalarTerm:
+		return yield(t.value, b)
+	case *varTerm:
+		return f.variable(t, b, yield)
+	case *refTerm:
+		return f.ref(t, b, yield)
+	case *arrayTerm:
+		return f.terms(t.elements, b, func(values []any, b *bindings) error {
+			return yield(values, b)
+		})
+	case *setTerm:
+		return f.terms(t.elements, b, func(values []any, b *bindings) error {
+			s := newSet()
+			for _, value := range values {
+				s.add(value)
+			}
+			return yield(s, b)
+		})
+	case *objectTerm:
+		return f.terms(append(append([]term{}, t.keys...), t.values...), b, func(values []any, b *bindings) error {
+			object := make(map[string]any, len(t.keys))
+			for i := range t.keys {
+				key, ok := values[i].(string)
+				if !ok {
+					return &Error{Pos: t.keys[i].position(), Msg: "object keys must be strings"}
+				}
+				object[key] = values[len(t.keys)+i]
+			}
+			return yield(object, b)
+		})
+	case *callTerm:
+		return f.call(t, b, yield)
+	case *unaryTerm:
+		return f.term(t.operand, b, func(value any, b *bindings) error {
+			n, ok := value.(float64)
+			if !ok {
+				return nil
+			}
+			return yield(-n, b)
+		})
+	case *binaryTerm:
+		return f.term(t.left, b, func(left any, b *bindings) error {
+			return f.term(t.right, b, func(right any, b *bindings) error {
+				value, ok := binary(t.op, left, right)
+				if !ok {
+					return nil
+				}
+				return yield(value, b)
+			})
+		})
+	case *comprehensionTerm:
+		return f.comprehension(t, b, yield)
+	}
+	return &Error{Pos: t.position(), Msg: "unsupported term"}
+}
+
+// terms enumerates the combinations of values of several terms
+func (f frame) terms(terms []term, b *bindings, yield func(values []any, b *bindings) error) error {
+	values := make([]any, len(terms))
+	var walk func(i int, b *bindings) error
+	walk = func(i int, b *bindings) error {
+		if i == len(terms) {
+			return yield(append([]any(nil), values...), b)
+		}
+		return f.term(terms[i], b, func(value any, b *bindings) error {
+			values[i] = value
+			return walk(i+1, b)
+		})
+	}
+	return walk(0, b)
+}
+
+// variable resolves a variable: local value, import, input, data or rule of the package
+func (f frame) variable(v *varTerm, b *bindings, yield yieldValue) error {
+	if value, ok := b.lookup(v.name); ok {
+		return yield(value, b)
+	}
+	if !b.declared(v.name) {
+		switch v.name {
+		case "input":
+			if f.eval.input == nil {
+				return nil
+			}
+			return yield(f.eval.input, b)
+		case "data":
+			return f.ref(&refTerm{pos: v.pos, head: v}, b, yield)
+		}
+		if imported, ok := f.module.imports[v.name]; ok {
+			return f.term(imported, b, yield)
+		}
+		if len(f.eval.engine.rules(f.module.pkg, v.name)) > 0 {
+			value, defined, err := f.eval.rule(f.module.pkg, v.name)
+			if err != nil || !defined {
+				return err
+			}
+			return yield(value, b)
+		}
+	}
+	return &Error{Pos: v.pos, Msg: fmt.Sprintf("var %s is unsafe", displayName(v.name))}
+}
+
+// ref enumerates the values of a reference
+func (f frame) ref(r *refTerm, b *bindings, yield yieldValue) error {
+	if head, ok := r.head.(*varTerm); ok && !b.declared(head.name) {
+		if _, bound := b.lookup(head.name); !bound {
+			if imported, ok := f.module.imports[head.name].(*refTerm); ok {
+				expanded := &refTerm{pos: r.pos, head: imported.head, path: append(append([]term{}, imported.path...), r.path...)}
+				return f.ref(expanded, b, yield)
+			}
+			if head.name == "data" {
+				return f.data(r, b, yield)
+			}
+		}
+	}
+	return f.term(r.head, b, func(value any, b *bindings) error {
+		return f.walk(value, r.path, b, yield)
+	})
+}
+
+// walk follows the path of a reference from a value
+func (f frame) walk(value any, path []term, b *bindings, yield yieldValue) error {
+	if len(path) == 0 {
+		return yield(value, b)
+	}
+	if f.isUnbound(path[0], b) {
+		name := path[0].(*varTerm).name
+		return iterate(value, func(key, element any) error {
+			return f.walk(element, path[1:], b.bind(name, key), yield)
+		})
+	}
+	return f.term(path[0], b, func(key any, b *bindings) error {
+		element, ok := lookup(value, key)
+		if !ok {
+			return nil
+		}
+		return f.walk(element, path[1:], b, yield)
+	})
+}
+
+// data resolves data.<package>.<rule>...; the longest matching package wins
+func (f frame) data(r *refTerm, b *bindings, yield yieldValue) error {
+	var names []string
+	for _, element := range r.path {
+		scalar, ok := element.(*scalarTerm)
+		if !ok {
+			break
+		}
+		name, ok := scalar.value.(string)
+		if !ok {
+			break
+		}
+		names = append(names, name)
+	}
+	for i := len(names); i > 0; i-- {
+		pkg := strings.Join(names[:i], ".")
+		if !f.eval.engine.hasPackage(pkg) {
+			continue
+		}
+		if i == len(r.path) {
+			document, err := f.eval.packageDocument(pkg)
+			if err != nil {
+				return err
+			}
+			return yield(document, b)
+		}
+		name, ok := r.path[i].(*scalarTerm).value.(string)
+		if !ok {
+			return nil
+		}
+		value, defined, err := f.eval.rule(pkg, name)
+		if err != nil || !defined {
+			return err
+		}
+		return f.walk(value, r.path[i+1:], b, yield)
+	}
+	return nil
+}
+
+// call evaluates a built-in or user-defined function
+func (f frame) call(c *callTerm, b *bindings, yield yieldValue) error {
+	return f.terms(c.args, b, func(args []any, b *bindings) error {
+		if fn, ok := builtins[c.name]; ok {
+			result, ok := fn.fn(args)
+			if !ok {
+				return nil
+			}
+			return yield(result, b)
+		}
+		pkg, name := f.functionName(c.name)
+		result, defined, err := f.eval.function(pkg, name, args)
+		if err != nil || !defined {
+			return err
+		}
+		return yield(result, b)
+	})
+}
+
+// functionName resolves a function name to its package and rule name
+func (f frame) functionName(name string) (string, string) {
+	parts := strings.Split(name, ".")
+	if len(parts) == 1 {
+		return f.module.pkg, name
+	}
+	if imported, ok := f.module.imports[parts[0]].(*refTerm); ok && imported.head.(*varTerm).name == "data" {
+		var prefix []string
+		for _, element := range imported.path {
+			prefix = append(prefix, element.(*scalarTerm).value.(string))
+		}
+		parts = append(prefix, parts[1:]...)
+	} else if parts[0] == "data" {
+		parts = parts[1:]
+	}
+	return strings.Join(parts[:len(parts)-1], "."), parts[len(parts)-1]
+}
+
+// comprehension collects the values of an array, set or object comprehension
+func (f frame) comprehension(c *comprehensionTerm, b *bindings, yield yieldValue) error {
+	var (
+		array  = []any{}
+		set    = newSet()
+		object = map[string]any{}
+	)
+	err := f.body(c.body, b, func(inner *bindings) error {
+		return f.term(c.value, inner, func(value any, inner *bindings) error {
+			switch c.kind {
+			case "array":
+				array = append(array, value)
+			case "set":
+				set.add(value)
+			default:
+				return f.term(c.key, inner, func(key any, _ *bindings) error {
+					name, ok := key.(string)
+					if !ok {
+						return &Error{Pos: c.pos, Msg: "object keys must be strings"}
+					}
+					if existing, ok := object[name]; ok && !equalValues(existing, value) {
+						return &Error{Pos: c.pos, Msg: fmt.Sprintf("object comprehension produced conflicting values for key %q", name)}
+					}
+					object[name] = value
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	switch c.kind {
+	case "array":
+		return yield(array, b)
+	case "set":
+		return yield(set, b)
+	}
+	return yield(object, b)
+}
+
+// rule computes (once per evaluation) the value of a rule
+func (e *evaluation) rule(pkg, name string) (any, bool, error) {
+	key := pkg + "." + name
+	if result, ok := e.cache[key]; ok {
+		return result.value, result.defined, nil
+	}
+	definitions := e.engine.rules(pkg, name)
+	if len(definitions) == 0 {
+		return nil, false, nil
+	}
+	if definitions[0].kind == ruleFunction {
+		return nil, false, &Error{Pos: definitions[0].pos, Msg: fmt.Sprintf("function %s must be called with arguments", key)}
+	}
+	if e.active[key] {
+		return nil, false, &Error{Pos: definitions[0].pos, Msg: fmt.Sprintf("rule %s is recursive", key)}
+	}
+	e.active[key] = true
+	defer delete(e.active, key)
+
+	value, defined, err := e.evalRule(definitions)
+	if err != nil {
+		return nil, false, err
+	}
+	e.cache[key] = ruleResult{value: value, defined: defined}
+	return value, defined, nil
+}
+
+func (e *evaluation) evalRule(definitions []*rule) (any, bool, error) {
+	var (
+		result    any
+		defined   bool
+		set       = newSet()
+		object    = map[string]any{}
+		defaultOf *rule
+	)
+	for _, r := range definitions {
+		if r.isDefault {
+			defaultOf = r
+			continue
+		}
+		f := frame{eval: e, module: r.module}
+		err := f.body(r.body, nil, func(b *bindings) error {
+			switch r.kind {
+			case ruleSet:
+				return f.term(r.key, b, func(value any, _ *bindings) error {
+					set.add(value)
+					return nil
+				})
+			case ruleObject:
+				return f.term(r.key, b, func(key any, b *bindings) error {
+					name, ok := key.(string)
+					if !ok {
+						return &Error{Pos: r.pos, Msg: "object rule keys must be strings"}
+					}
+					return f.term(r.value, b, func(value any, _ *bindings) error {
+						if existing, ok := object[name]; ok && !equalValues(existing, value) {
+							return &Error{Pos: r.pos, Msg: fmt.Sprintf("rule %s produced conflicting values for key %q", r.name, name)}
+						}
+						object[name] = value
+						return nil
+					})
+				})
+			}
+			return f.ruleValue(r, b, func(value any, _ *bindings) error {
+				if defined && !equalValues(result, value) {
+					return &Error{Pos: r.pos, Msg: fmt.Sprintf("rule %s produced conflicting values", r.name)}
+				}
+				result, defined = value, true
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	switch definitions[0].kind {
+	case ruleSet:
+		return set, true, nil
+	case ruleObject:
+		return object, true, nil
+	}
+	if !defined && defaultOf != nil {
+		f := frame{eval: e, module: defaultOf.module}
+		err := f.term(defaultOf.value, nil, func(value any, _ *bindings) error {
+			result, defined = value, true
+			return errStop
+		})
+		if err != nil && !errors.Is(err, errStop) {
+			return nil, false, err
+		}
+	}
+	return result, defined, nil
+}
+
+// ruleValue enumerates the value of a rule head, true when it has none
+func (f frame) ruleValue(r *rule, b *bindings, yield yieldValue) error {
+	if r.value == nil {
+		return yield(true, b)
+	}
+	return f.term(r.value, b, yield)
+}
+
+// function calls a user-defined function
+func (e *evaluation) function(pkg, name string, args []any) (any, bool, error) {
+	definitions := e.engine.rules(pkg, name)
+	key := pkg + "." + name
+	if e.active[key] {
+		return nil, false, &Error{Pos: definitions[0].pos, Msg: fmt.Sprintf("function %s is recursive", key)}
+	}
+	e.active[key] = true
+	defer delete(e.active, key)
+
+	var (
+		result  any
+		defined bool
+	)
+	for _, r := range definitions {
+		if len(r.args) != len(args) {
+			continue
+		}
+		f := frame{eval: e, module: r.module}
+		err := f.matchAll(r.args, args, nil, true, func(b *bindings) error {
+			return f.body(r.body, b, func(b *bindings) error {
+				return f.ruleValue(r, b, func(value any, _ *bindings) error {
+					if defined && !equalValues(result, value) {
+						return &Error{Pos: r.pos, Msg: fmt.Sprintf("function %s produced conflicting values", key)}
+					}
+					result, defined = value, true
+					return nil
+				})
+			})
+		})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return result, defined, nil
+}
+
+// packageDocument builds the object holding every rule of a package
+func (e *evaluation) packageDocument(pkg string) (map[string]any, error) {
+	document := make(map[string]any)
+	for _, name := range e.engine.ruleNames(pkg) {
+		value, defined, err := e.rule(pkg, name)
+		if err != nil {
+			return nil, err
+		}
+		if defined {
+			document[name] = value
+		}
+	}
+	return document, nil
+}
+
+// binary applies an operator; ok is false when the result is undefined
+func binary(op string, left, right any) (any, bool) {
+	switch op {
+	case "==":
+		return equalValues(left, right), true
+	case "!=":
+		return !equalValues(left, right), true
+	case "<":
+		return compareValues(left, right) < 0, true
+	case "<=":
+		return compareValues(left, right) <= 0, true
+	case ">":
+		return compareValues(left, right) > 0, true
+	case ">=":
+		return compareValues(left, right) >= 0, true
+	case "in":
+		switch collection := right.(type) {
+		case []any:
+			for _, element := range collection {
+				if equalValues(left, element) {
+					return true, true
+				}
+			}
+		case map[string]any:
+			for _, element := range collection {
+				if equalValues(left, element) {
+					return true, true
+				}
+			}
+		case *Set:
+			return collection.has(left), true
+		default:
+			return nil, false
+		}
+		return false, true
+	}
+
+	if l, ok := left.(*Set); ok {
+		r, ok := right.(*Set)
+		if !ok {
+			return nil, false
+		}
+		result := newSet()
+		switch op {
+		case "|":
+			for _, element := range append(append([]any{}, l.elements...), r.elements...) {
+				result.add(element)
+			}
+		case "&":
+			for _, element := range l.elements {
+				if r.has(element) {
+					result.add(element)
+				}
+			}
+		case "-":
+			for _, element := range l.elements {
+				if !r.has(element) {
+					result.add(element)
+				}
+			}
+		default:
+			return nil, false
+		}
+		return result, true
+	}
+
+	l, ok1 := left.(float64)
+	r, ok2 := right.(float64)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	switch op {
+	case "+":
+		return l + r, true
+	case "-":
+		return l - r, true
+	case "*":
+		return l * r, true
+	case "/":
+		if r == 0 {
+			return nil, false
+		}
+		return l / r, true
+	case "%":
+		if r == 0 {
+			return nil, false
+		}
+		return math.Mod(l, r), true
+	}
+	return nil, false
+}
+
+// displayName hides the internal name of the _ wildcard
+func displayName(name string) string {
+	if strings.HasPrefix(name, "$_") {
+		return "_"
+	}
+	return name
+}
@@ -0,0 +1,188 @@
+package rego
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind identifies a lexical token
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNewline
+	tokIdent
+	tokNumber
+	tokString
+	tokOperator
+)
+
+// token is a lexical token and its position in the file
+type token struct {
+	kind tokenKind
+	text string
+	pos  position
+}
+
+// position locates a token in a policy file
+type position struct {
+	file      string
+	line, col int
+}
+
+func (p position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.file, p.line, p.col)
+}
+
+// Error reports a syntax or compilation error in a policy file
+type Error struct {
+	Pos position
+	Msg string
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// operators lists the operators, longest first
+var operators = []string{
+	":=", "==", "!=", "<=", ">=",
+	"<", ">", "=", "+", "-", "*", "/", "%", "|", "&",
+	"(", ")", "[", "]", "{", "}", ".", ",", ";", ":",
+}
+
+// lexer splits a policy file into tokens
+type lexer struct {
+	file   string
+	source string
+	offset int
+	line   int
+	col    int
+}
+
+func lex(file, source string) ([]token, error) {
+	l := &lexer{file: file, source: source, line: 1, col: 1}
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.kind == tokEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) pos() position {
+	return position{file: l.file, line: l.line, col: l.col}
+}
+
+// advance consumes n bytes of the current line
+func (l *lexer) advance(n int) {
+	l.offset += n
+	l.col += n
+}
+
+func (l *lexer) next() (token, error) {
+	for l.offset < len(l.source) {
+		c := l.source[l.offset]
+		switch {
+		case c == '\n':
+			pos := l.pos()
+			l.offset++
+			l.line++
+			l.col = 1
+			return token{kind: tokNewline, text: "\n", pos: pos}, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			l.advance(1)
+		case c == '#':
+			for l.offset < len(l.source) && l.source[l.offset] != '\n' {
+				l.advance(1)
+			}
+		default:
+			return l.token()
+		}
+	}
+	return token{kind: tokEOF, pos: l.pos()}, nil
+}
+
+func (l *lexer) token() (token, error) {
+	pos := l.pos()
+	rest := l.source[l.offset:]
+	r, _ := utf8.DecodeRuneInString(rest)
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		end := 0
+		for end < len(rest) {
+			r, size := utf8.DecodeRuneInString(rest[end:])
+			if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			end += size
+		}
+		l.advance(end)
+		return token{kind: tokIdent, text: rest[:end], pos: pos}, nil
+	case r >= '0' && r <= '9':
+		end := 0
+		for end < len(rest) && (isDigit(rest[end]) || rest[end] == '.' || rest[end] == 'e' || rest[end] == 'E') {
+			if rest[end] == '.' && (end+1 >= len(rest) || !isDigit(rest[end+1])) {
+				break
+			}
+			if (rest[end] == 'e' || rest[end] == 'E') && end+1 < len(rest) && (rest[end+1] == '+' || rest[end+1] == '-') {
+				end++
+			}
+			end++
+		}
+		l.advance(end)
+		return token{kind: tokNumber, text: rest[:end], pos: pos}, nil
+	case r == '"':
+		end := 1
+		for end < len(rest) && rest[end] != '"' && rest[end] != '\n' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) || rest[end] != '"' {
+			return token{}, &Error{Pos: pos, Msg: "unterminated string"}
+		}
+		value, err := strconv.Unquote(rest[:end+1])
+		if err != nil {
+			return token{}, &Error{Pos: pos, Msg: fmt.Sprintf("invalid string %s", rest[:end+1])}
+		}
+		l.advance(end + 1)
+		return token{kind: tokString, text: value, pos: pos}, nil
+	case r == '`':
+		end := strings.IndexByte(rest[1:], '`')
+		if end < 0 {
+			return token{}, &Error{Pos: pos, Msg: "unterminated raw string"}
+		}
+		value := rest[1 : end+1]
+		// raw strings may span several lines
+		l.offset += end + 2
+		if lines := strings.Count(value, "\n"); lines > 0 {
+			l.line += lines
+			l.col = len(value) - strings.LastIndexByte(value, '\n') + 1
+		} else {
+			l.col += end + 2
+		}
+		return token{kind: tokString, text: value, pos: pos}, nil
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			l.advance(len(op))
+			return token{kind: tokOperator, text: op, pos: pos}, nil
+		}
+	}
+	return token{}, &Error{Pos: pos, Msg: fmt.Sprintf("unexpected character %q", r)}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
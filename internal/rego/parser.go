@@ -0,0 +1,819 @@
+package rego
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parser is a recursive descent parser for policy files
+type parser struct {
+	tokens []token
+	pos    int
+	// noPipe stops "|" from being read as a set union inside the head of a comprehension
+	noPipe bool
+	// wildcards numbers the _ variables, each of which is distinct
+	wildcards int
+}
+
+// parseModule parses a policy file
+func parseModule(file, source string) (*module, error) {
+	tokens, err := lex(file, source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	m := &module{file: file, imports: make(map[string]term)}
+
+	p.skipSeparators()
+	if !p.acceptKeyword("package") {
+		return nil, p.errorf("expected package declaration")
+	}
+	path, err := p.dottedName()
+	if err != nil {
+		return nil, err
+	}
+	m.pkg = strings.Join(path, ".")
+
+	for {
+		p.skipSeparators()
+		tok := p.peek()
+		if tok.kind == tokEOF {
+			return m, nil
+		}
+		if p.acceptKeyword("import") {
+			if err := p.parseImport(m); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		r, err := p.parseRule()
+		if err != nil {
+			return nil, err
+		}
+		r.module = m
+		m.rules = append(m.rules, r)
+	}
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	tok := p.peek()
+	msg := fmt.Sprintf(format, args...)
+	switch tok.kind {
+	case tokEOF:
+		msg += ", got end of file"
+	case tokNewline:
+		msg += ", got end of line"
+	default:
+		msg += fmt.Sprintf(", got %q", tok.text)
+	}
+	return &Error{Pos: tok.pos, Msg: msg}
+}
+
+// accept consumes the next token if it is the given operator
+func (p *parser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOperator && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// acceptKeyword consumes the next token if it is the given keyword
+func (p *parser) acceptKeyword(keyword string) bool {
+	if tok := p.peek(); tok.kind == tokIdent && tok.text == keyword {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && tok.text == keyword
+}
+
+func (p *parser) expect(op string) error {
+	if p.accept(op) {
+		return nil
+	}
+	return p.errorf("expected %q", op)
+}
+
+func (p *parser) skipNewlines() {
+	for p.peek().kind == tokNewline {
+		p.pos++
+	}
+}
+
+func (p *parser) skipSeparators() {
+	for p.peek().kind == tokNewline || p.accept(";") {
+		if p.peek().kind == tokNewline {
+			p.pos++
+		}
+	}
+}
+
+func (p *parser) ident() (token, error) {
+	tok := p.peek()
+	if tok.kind != tokIdent {
+		return tok, p.errorf("expected identifier")
+	}
+	p.pos++
+	return tok, nil
+}
+
+// dottedName parses a.b.c
+func (p *parser) dottedName() ([]string, error) {
+	first, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	path := []string{first.text}
+	for p.accept(".") {
+		tok, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, tok.text)
+	}
+	return path, nil
+}
+
+// parseImport parses import data.x.y [as alias]; the rego.v1 and
+// future.keywords imports only enable syntax that is always accepted here
+func (p *parser) parseImport(m *module) error {
+	pos := p.peek().pos
+	path, err := p.dottedName()
+	if err != nil {
+		return err
+	}
+	alias := path[len(path)-1]
+	if p.acceptKeyword("as") {
+		tok, err := p.ident()
+		if err != nil {
+			return err
+		}
+		alias = tok.text
+	}
+	switch path[0] {
+	case "rego", "future":
+		return nil
+	case "input", "data":
+	default:
+		return &Error{Pos: pos, Msg: fmt.Sprintf("import must start with input or data, got %q", path[0])}
+	}
+	ref := &refTerm{pos: pos, head: &varTerm{pos: pos, name: path[0]}}
+	for _, name := range path[1:] {
+		ref.path = append(ref.path, &scalarTerm{pos: pos, value: name})
+	}
+	m.imports[alias] = ref
+	return nil
+}
+
+// parseRule parses a rule definition in its Rego v0 or v1 syntax
+func (p *parser) parseRule() (*rule, error) {
+	r := &rule{pos: p.peek().pos, kind: ruleComplete}
+	if p.acceptKeyword("default") {
+		r.isDefault = true
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	switch name.text {
+	case "else", "with":
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("%s is not supported", name.text)}
+	case "if", "contains", "in", "not", "some", "every", "default", "as", "package":
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("keyword %s cannot name a rule", name.text)}
+	}
+	r.name = name.text
+	if r.isDefault {
+		if !p.accept(":=") && !p.accept("=") {
+			return nil, p.errorf("expected := after default rule name")
+		}
+		if r.value, err = p.parseTerm(); err != nil {
+			return nil, err
+		}
+		return r, p.endOfStatement()
+	}
+
+	switch {
+	case p.accept("("):
+		r.kind = ruleFunction
+		if r.args, err = p.termList(")"); err != nil {
+			return nil, err
+		}
+	case p.accept("["):
+		r.kind = ruleSet
+		if r.key, err = p.parseTerm(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		if p.isAssignment() {
+			r.kind = ruleObject
+		}
+	case p.acceptKeyword("contains"):
+		r.kind = ruleSet
+		if r.key, err = p.parseTerm(); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.kind != ruleSet && (p.accept(":=") || p.accept("=")) {
+		if r.value, err = p.parseTerm(); err != nil {
+			return nil, err
+		}
+	}
+
+	hasIf := p.acceptKeyword("if")
+	switch {
+	case p.accept("{"):
+		if r.body, err = p.parseBody(); err != nil {
+			return nil, err
+		}
+	case hasIf:
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		r.body = []*expr{e}
+	case r.value == nil && r.kind != ruleSet:
+		return nil, p.errorf("expected rule body or value")
+	}
+	if p.isKeyword("else") {
+		return nil, p.errorf("else is not supported")
+	}
+	return r, p.endOfStatement()
+}
+
+// isAssignment reports whether the next token is := or =
+func (p *parser) isAssignment() bool {
+	tok := p.peek()
+	return tok.kind == tokOperator && (tok.text == ":=" || tok.text == "=")
+}
+
+func (p *parser) endOfStatement() error {
+	switch tok := p.peek(); {
+	case tok.kind == tokNewline || tok.kind == tokEOF:
+		return nil
+	case tok.kind == tokOperator && tok.text == ";":
+		return nil
+	}
+	return p.errorf("expected end of line")
+}
+
+// parseBody parses expressions up to the closing brace
+func (p *parser) parseBody() ([]*expr, error) {
+	var body []*expr
+	for {
+		p.skipSeparators()
+		if p.accept("}") {
+			if len(body) == 0 {
+				return nil, p.errorf("empty rule body")
+			}
+			return body, nil
+		}
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, e)
+		if tok := p.peek(); tok.kind == tokOperator && tok.text == "}" {
+			continue
+		}
+		if tok := p.peek(); tok.kind != tokNewline && !(tok.kind == tokOperator && tok.text == ";") {
+			return nil, p.errorf("expected end of expression")
+		}
+	}
+}
+
+// parseExpr parses a body expression
+func (p *parser) parseExpr() (*expr, error) {
+	e := &expr{pos: p.peek().pos}
+	if p.acceptKeyword("not") {
+		e.negated = true
+	}
+
+	switch {
+	case p.acceptKeyword("some"):
+		return p.parseSome(e)
+	case p.acceptKeyword("every"):
+		if e.negated {
+			return nil, &Error{Pos: e.pos, Msg: "not every is not supported"}
+		}
+		return p.parseEvery(e)
+	}
+
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.accept(":="):
+		e.kind = exprAssign
+	case p.accept("="):
+		e.kind = exprUnify
+	default:
+		e.kind = exprTerm
+		e.term = left
+		return e, p.rejectWith()
+	}
+	if e.negated {
+		return nil, &Error{Pos: e.pos, Msg: "cannot negate an assignment"}
+	}
+	p.skipNewlines()
+	right, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	e.left, e.right = left, right
+	return e, p.rejectWith()
+}
+
+// rejectWith reports the with modifier, which would otherwise be read as
+// the end of the expression
+func (p *parser) rejectWith() error {
+	if p.isKeyword("with") {
+		return p.errorf("with is not supported")
+	}
+	return nil
+}
+
+// parseSome parses some x, y or some [k,] v in collection
+func (p *parser) parseSome(e *expr) (*expr, error) {
+	var terms []term
+	for {
+		t, err := p.parseRelation()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if !p.acceptKeyword("in") {
+		if e.negated {
+			return nil, &Error{Pos: e.pos, Msg: "cannot negate a variable declaration"}
+		}
+		e.kind = exprSome
+		for _, t := range terms {
+			v, ok := t.(*varTerm)
+			if !ok {
+				return nil, &Error{Pos: t.position(), Msg: "some expects variable names"}
+			}
+			e.vars = append(e.vars, v.name)
+		}
+		return e, nil
+	}
+	e.kind = exprSomeIn
+	return e, p.parseIteration(e, terms)
+}
+
+// parseEvery parses every [k,] v in collection { body }
+func (p *parser) parseEvery(e *expr) (*expr, error) {
+	var terms []term
+	for {
+		t, err := p.parseRelation()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if !p.acceptKeyword("in") {
+		return nil, p.errorf("expected in")
+	}
+	e.kind = exprEvery
+	if err := p.parseIteration(e, terms); err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	body, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	e.body = body
+	return e, nil
+}
+
+// parseIteration reads the collection of some ... in and every ... in
+func (p *parser) parseIteration(e *expr, terms []term) error {
+	switch len(terms) {
+	case 1:
+		e.value = terms[0]
+	case 2:
+		e.key, e.value = terms[0], terms[1]
+	default:
+		return &Error{Pos: e.pos, Msg: "expected at most a key and a value before in"}
+	}
+	collection, err := p.parseRelation()
+	if err != nil {
+		return err
+	}
+	e.collection = collection
+	return nil
+}
+
+// termList parses comma separated terms up to the closing delimiter
+func (p *parser) termList(closing string) ([]term, error) {
+	var terms []term
+	for {
+		p.skipNewlines()
+		if p.accept(closing) {
+			return terms, nil
+		}
+		t, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+		p.skipNewlines()
+		if p.accept(closing) {
+			return terms, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseTerm parses a term; precedence from lowest to highest is
+// in, comparisons, |, &, + -, * / %
+func (p *parser) parseTerm() (term, error) {
+	left, err := p.parseRelation()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("in") {
+		tok := p.next()
+		p.skipNewlines()
+		right, err := p.parseRelation()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryTerm{pos: tok.pos, op: "in", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) binary(operand func() (term, error), ops ...string) (term, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOperator || !contains(ops, tok.text) || (tok.text == "|" && p.noPipe) {
+			return left, nil
+		}
+		p.next()
+		p.skipNewlines()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryTerm{pos: tok.pos, op: tok.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseRelation() (term, error) {
+	return p.binary(p.parseUnion, "==", "!=", "<", "<=", ">", ">=")
+}
+
+func (p *parser) parseUnion() (term, error) {
+	return p.binary(p.parseIntersection, "|")
+}
+
+func (p *parser) parseIntersection() (term, error) {
+	return p.binary(p.parseArith, "&")
+}
+
+func (p *parser) parseArith() (term, error) {
+	return p.binary(p.parseFactor, "+", "-")
+}
+
+func (p *parser) parseFactor() (term, error) {
+	return p.binary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *parser) parseUnary() (term, error) {
+	tok := p.peek()
+	if tok.kind == tokOperator && tok.text == "-" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if scalar, ok := operand.(*scalarTerm); ok {
+			if n, ok := scalar.value.(float64); ok {
+				return &scalarTerm{pos: tok.pos, value: -n}, nil
+			}
+		}
+		return &unaryTerm{pos: tok.pos, op: "-", operand: operand}, nil
+	}
+	return p.parseRef()
+}
+
+// parseRef parses a primary term followed by .field, [key] or (args)
+func (p *parser) parseRef() (term, error) {
+	head, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	var path []term
+	for {
+		tok := p.peek()
+		switch {
+		case p.accept("."):
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, &scalarTerm{pos: name.pos, value: name.text})
+		case p.accept("["):
+			saved := p.noPipe
+			p.noPipe = false
+			p.skipNewlines()
+			key, err := p.parseTerm()
+			p.noPipe = saved
+			if err != nil {
+				return nil, err
+			}
+			p.skipNewlines()
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			path = append(path, key)
+		case p.accept("("):
+			// a.b.c(args) calls the function named "a.b.c"
+			v, ok := head.(*varTerm)
+			if !ok {
+				return nil, &Error{Pos: tok.pos, Msg: "unexpected ("}
+			}
+			name := []string{v.name}
+			for _, element := range path {
+				s, ok := element.(*scalarTerm)
+				if !ok {
+					return nil, &Error{Pos: tok.pos, Msg: "invalid function name"}
+				}
+				str, ok := s.value.(string)
+				if !ok {
+					return nil, &Error{Pos: tok.pos, Msg: "invalid function name"}
+				}
+				name = append(name, str)
+			}
+			saved := p.noPipe
+			p.noPipe = false
+			args, err := p.termList(")")
+			p.noPipe = saved
+			if err != nil {
+				return nil, err
+			}
+			head = &callTerm{pos: v.pos, name: strings.Join(name, "."), args: args}
+			path = nil
+		default:
+			if len(path) == 0 {
+				return head, nil
+			}
+			return &refTerm{pos: head.position(), head: head, path: path}, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (term, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokNumber:
+		p.next()
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, &Error{Pos: tok.pos, Msg: fmt.Sprintf("invalid number %q", tok.text)}
+		}
+		// Numbers are float64: an integer beyond 2^53 could silently compare
+		// equal to its neighbours
+		if !strings.ContainsAny(tok.text, ".eE") {
+			if n, err := strconv.ParseUint(tok.text, 10, 64); err != nil || uint64(value) != n {
+				return nil, &Error{Pos: tok.pos, Msg: fmt.Sprintf("integer %s cannot be represented exactly, numbers are float64", tok.text)}
+			}
+		}
+		return &scalarTerm{pos: tok.pos, value: value}, nil
+	case tokString:
+		p.next()
+		return &scalarTerm{pos: tok.pos, value: tok.text}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			p.next()
+			return &scalarTerm{pos: tok.pos, value: tok.text == "true"}, nil
+		case "null":
+			p.next()
+			return &scalarTerm{pos: tok.pos, value: nil}, nil
+		case "contains":
+			// contains(s, sub) calls the built-in function of the same name
+			if next := p.tokens[p.pos+1]; next.kind != tokOperator || next.text != "(" {
+				return nil, p.errorf("unexpected keyword")
+			}
+		case "in", "if", "not", "some", "every", "default", "else", "with", "as", "package", "import":
+			return nil, p.errorf("unexpected keyword")
+		case "_":
+			p.next()
+			p.wildcards++
+			return &varTerm{pos: tok.pos, name: "$_" + strconv.Itoa(p.wildcards)}, nil
+		}
+		p.next()
+		return &varTerm{pos: tok.pos, name: tok.text}, nil
+	case tokOperator:
+		switch tok.text {
+		case "(":
+			p.next()
+			saved := p.noPipe
+			p.noPipe = false
+			p.skipNewlines()
+			inner, err := p.parseTerm()
+			p.noPipe = saved
+			if err != nil {
+				return nil, err
+			}
+			p.skipNewlines()
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		case "[":
+			p.next()
+			return p.parseArray(tok)
+		case "{":
+			p.next()
+			return p.parseBraces(tok)
+		}
+	}
+	return nil, p.errorf("expected term")
+}
+
+// parseArray parses an array literal or an array comprehension
+func (p *parser) parseArray(open token) (term, error) {
+	saved := p.noPipe
+	defer func() { p.noPipe = saved }()
+
+	p.noPipe = true
+	p.skipNewlines()
+	if p.accept("]") {
+		return &arrayTerm{pos: open.pos}, nil
+	}
+	first, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	p.skipNewlines()
+	if p.accept("|") {
+		body, err := p.comprehensionBody("]")
+		if err != nil {
+			return nil, err
+		}
+		return &comprehensionTerm{pos: open.pos, kind: "array", value: first, body: body}, nil
+	}
+	elements := []term{first}
+	if !p.accept("]") {
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		rest, err := p.termList("]")
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, rest...)
+	}
+	return &arrayTerm{pos: open.pos, elements: elements}, nil
+}
+
+// parseBraces parses an object or set literal, or their comprehension
+func (p *parser) parseBraces(open token) (term, error) {
+	saved := p.noPipe
+	defer func() { p.noPipe = saved }()
+
+	p.noPipe = true
+	p.skipNewlines()
+	if p.accept("}") {
+		return &objectTerm{pos: open.pos}, nil
+	}
+	first, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	p.skipNewlines()
+
+	if !p.accept(":") {
+		if p.accept("|") {
+			body, err := p.comprehensionBody("}")
+			if err != nil {
+				return nil, err
+			}
+			return &comprehensionTerm{pos: open.pos, kind: "set", value: first, body: body}, nil
+		}
+		elements := []term{first}
+		if !p.accept("}") {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			rest, err := p.termList("}")
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, rest...)
+		}
+		return &setTerm{pos: open.pos, elements: elements}, nil
+	}
+
+	p.skipNewlines()
+	value, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	p.skipNewlines()
+	if p.accept("|") {
+		body, err := p.comprehensionBody("}")
+		if err != nil {
+			return nil, err
+		}
+		return &comprehensionTerm{pos: open.pos, kind: "object", key: first, value: value, body: body}, nil
+	}
+
+	object := &objectTerm{pos: open.pos, keys: []term{first}, values: []term{value}}
+	for {
+		p.skipNewlines()
+		if p.accept("}") {
+			return object, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		p.skipNewlines()
+		if p.accept("}") {
+			return object, nil
+		}
+		key, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		p.skipNewlines()
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		p.skipNewlines()
+		value, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		object.keys = append(object.keys, key)
+		object.values = append(object.values, value)
+	}
+}
+
+// comprehensionBody parses the body of a comprehension up to the closing delimiter
+func (p *parser) comprehensionBody(closing string) ([]*expr, error) {
+	p.noPipe = false
+	var body []*expr
+	for {
+		p.skipSeparators()
+		if p.accept(closing) {
+			if len(body) == 0 {
+				return nil, p.errorf("empty comprehension body")
+			}
+			return body, nil
+		}
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, e)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
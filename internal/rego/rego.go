@@ -0,0 +1,249 @@
+// Package rego evaluates authorization policies written in a subset of the
+// Rego language of Open Policy Agent, in-process and without dependencies.
+// It is not OPA: policies written for OPA may need to be adapted.
+//
+// Supported: package and import declarations (including rego.v1 and
+// future.keywords), default values, complete rules, partial set rules
+// (contains or v0 brackets), partial object rules, functions, the if and
+// v0 body syntaxes, not, some, some ... in, every, :=, =, references with
+// iteration over _ or unbound variables, array, set and object
+// comprehensions, arithmetic, comparison, set operators and the built-in
+// functions listed in builtins.go.
+//
+// Not supported, and rejected when the policies are loaded: else chains,
+// with, rule heads with dotted references (a.b := x) and every other
+// built-in function, such as glob.match, the io.jwt.* and crypto.*
+// functions or http.send. Unification (=) binds the variables of one side
+// to the value of the other, and unifies two arrays element by element;
+// it does not unify objects whose both sides have unbound variables.
+// Numbers, including those of the input, are float64: integers beyond 2^53
+// lose precision, and integer literals that cannot be represented exactly
+// are rejected.
+package rego
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Engine holds a compiled set of policy modules
+type Engine struct {
+	modules  []*module
+	packages map[string]map[string][]*rule
+}
+
+// LoadDir loads and compiles every .rego file found under dir; _test.rego
+// files are ignored
+func LoadDir(dir string) (*Engine, error) {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, ".rego") || strings.HasSuffix(path, "_test.rego") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[path] = string(data)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policies: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .rego file found in %s", dir)
+	}
+	return New(files)
+}
+
+// New compiles policy modules given by file name
+func New(files map[string]string) (*Engine, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	engine := &Engine{packages: make(map[string]map[string][]*rule)}
+	for _, name := range names {
+		m, err := parseModule(name, files[name])
+		if err != nil {
+			return nil, err
+		}
+		engine.modules = append(engine.modules, m)
+		rules := engine.packages[m.pkg]
+		if rules == nil {
+			rules = make(map[string][]*rule)
+			engine.packages[m.pkg] = rules
+		}
+		for _, r := range m.rules {
+			rules[r.name] = append(rules[r.name], r)
+		}
+	}
+	if err := engine.check(); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+// check rejects inconsistent rule definitions and calls to unknown functions
+func (e *Engine) check() error {
+	for pkg, rules := range e.packages {
+		for name, definitions := range rules {
+			defaults := 0
+			for _, r := range definitions {
+				if r.isDefault {
+					defaults++
+				}
+				if r.kind != definitions[0].kind && !r.isDefault && !definitions[0].isDefault {
+					return &Error{Pos: r.pos, Msg: fmt.Sprintf("rule %s.%s is defined with different kinds", pkg, name)}
+				}
+			}
+			if defaults > 1 {
+				return &Error{Pos: definitions[0].pos, Msg: fmt.Sprintf("rule %s.%s has several default values", pkg, name)}
+			}
+		}
+	}
+	for _, m := range e.modules {
+		f := frame{eval: &evaluation{engine: e}, module: m}
+		for _, r := range m.rules {
+			terms := append([]term{r.key, r.value}, r.args...)
+			if err := f.checkCalls(terms, r.body); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkCalls verifies that every function called by the terms and body exists
+func (f frame) checkCalls(terms []term, body []*expr) error {
+	for _, e := range body {
+		if err := f.checkCalls([]term{e.term, e.left, e.right, e.key, e.value, e.collection}, e.body); err != nil {
+			return err
+		}
+	}
+	for _, t := range terms {
+		var err error
+		switch t := t.(type) {
+		case *refTerm:
+			err = f.checkCalls(append([]term{t.head}, t.path...), nil)
+		case *arrayTerm:
+			err = f.checkCalls(t.elements, nil)
+		case *setTerm:
+			err = f.checkCalls(t.elements, nil)
+		case *objectTerm:
+			err = f.checkCalls(append(append([]term{}, t.keys...), t.values...), nil)
+		case *unaryTerm:
+			err = f.checkCalls([]term{t.operand}, nil)
+		case *binaryTerm:
+			err = f.checkCalls([]term{t.left, t.right}, nil)
+		case *comprehensionTerm:
+			err = f.checkCalls([]term{t.key, t.value}, t.body)
+		case *callTerm:
+			if err = f.checkCalls(t.args, nil); err != nil {
+				return err
+			}
+			err = f.checkFunction(t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f frame) checkFunction(c *callTerm) error {
+	if fn, ok := builtins[c.name]; ok {
+		if len(c.args) != fn.arity {
+			return &Error{Pos: c.pos, Msg: fmt.Sprintf("%s expects %d argument(s), got %d", c.name, fn.arity, len(c.args))}
+		}
+		return nil
+	}
+	pkg, name := f.functionName(c.name)
+	definitions := f.eval.engine.rules(pkg, name)
+	if len(definitions) == 0 {
+		return &Error{Pos: c.pos, Msg: fmt.Sprintf("undefined function %s", c.name)}
+	}
+	if definitions[0].kind != ruleFunction {
+		return &Error{Pos: c.pos, Msg: fmt.Sprintf("%s is not a function", c.name)}
+	}
+	return nil
+}
+
+func (e *Engine) rules(pkg, name string) []*rule {
+	return e.packages[pkg][name]
+}
+
+func (e *Engine) hasPackage(pkg string) bool {
+	_, ok := e.packages[pkg]
+	return ok
+}
+
+// ruleNames returns the names of the rules of a package, functions excluded
+func (e *Engine) ruleNames(pkg string) []string {
+	var names []string
+	for name, definitions := range e.packages[pkg] {
+		if definitions[0].kind != ruleFunction {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Query is a prepared reference to a rule, such as data.httpapi.authz.allow
+type Query struct {
+	engine *Engine
+	path   string
+	pkg    string
+	name   string
+}
+
+// Query prepares the evaluation of a rule; the data. prefix is optional
+func (e *Engine) Query(path string) (*Query, error) {
+	path = strings.TrimPrefix(path, "data.")
+	index := strings.LastIndex(path, ".")
+	if index <= 0 {
+		return nil, fmt.Errorf("query %q must reference a rule as <package>.<rule>", path)
+	}
+	pkg, name := path[:index], path[index+1:]
+	definitions := e.rules(pkg, name)
+	if len(definitions) == 0 {
+		return nil, fmt.Errorf("query %q references an undefined rule", "data."+path)
+	}
+	if definitions[0].kind == ruleFunction {
+		return nil, fmt.Errorf("query %q references a function", "data."+path)
+	}
+	return &Query{engine: e, path: "data." + path, pkg: pkg, name: name}, nil
+}
+
+// String returns the path of the query
+func (q *Query) String() string {
+	return q.path
+}
+
+// Eval evaluates the rule against an input document; defined is false when
+// no definition of the rule applies and it has no default value
+func (q *Query) Eval(ctx context.Context, input any) (result any, defined bool, err error) {
+	evaluation := &evaluation{
+		ctx:    ctx,
+		engine: q.engine,
+		input:  normalize(input),
+		cache:  make(map[string]ruleResult),
+		active: make(map[string]bool),
+	}
+	value, defined, err := evaluation.rule(q.pkg, q.name)
+	if err != nil {
+		return nil, false, err
+	}
+	return export(value), defined, nil
+}
@@ -0,0 +1,521 @@
+package rego
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// undefined marks a query expected to have no value
+type undefinedResult struct{}
+
+var undefined = undefinedResult{}
+
+// evalQuery compiles a single module and evaluates one of its rules
+func evalQuery(t *testing.T, source, query string, input any) (any, bool, error) {
+	t.Helper()
+	engine, err := New(map[string]string{"policy.rego": source})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	q, err := engine.Query(query)
+	if err != nil {
+		t.Fatalf("Query(%q) error = %v", query, err)
+	}
+	return q.Eval(context.Background(), input)
+}
+
+// expectResult checks the value of a query, or that it is undefined
+func expectResult(t *testing.T, source, query string, input, want any) {
+	t.Helper()
+	got, defined, err := evalQuery(t, source, query, input)
+	if err != nil {
+		t.Fatalf("Eval(%s) error = %v", query, err)
+	}
+	if want == undefined {
+		if defined {
+			t.Errorf("Eval(%s) = %#v, want undefined", query, got)
+		}
+		return
+	}
+	if !defined {
+		t.Fatalf("Eval(%s) is undefined, want %#v", query, want)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Eval(%s) = %#v, want %#v", query, got, want)
+	}
+}
+
+func TestUndefinedVersusFalse(t *testing.T) {
+	const policy = `package authz
+
+import rego.v1
+
+allow if input.user == "alice"
+
+default allow_default := false
+
+allow_default if input.user == "alice"
+
+explicit_false := false if input.user == "alice"
+
+# a false expression fails the body, it does not make the rule false
+flag if input.flag
+
+missing if input.absent == 1
+
+deny if not allow
+`
+	tests := []struct {
+		query string
+		input any
+		want  any
+	}{
+		{query: "data.authz.allow", input: map[string]any{"user": "alice"}, want: true},
+		{query: "data.authz.allow", input: map[string]any{"user": "bob"}, want: undefined},
+		{query: "data.authz.allow", input: nil, want: undefined},
+		{query: "data.authz.allow_default", input: map[string]any{"user": "alice"}, want: true},
+		{query: "data.authz.allow_default", input: map[string]any{"user": "bob"}, want: false},
+		{query: "data.authz.explicit_false", input: map[string]any{"user": "alice"}, want: false},
+		{query: "data.authz.explicit_false", input: map[string]any{"user": "bob"}, want: undefined},
+		{query: "data.authz.flag", input: map[string]any{"flag": true}, want: true},
+		{query: "data.authz.flag", input: map[string]any{"flag": false}, want: undefined},
+		{query: "data.authz.flag", input: map[string]any{"flag": "yes"}, want: true},
+		{query: "data.authz.missing", input: map[string]any{}, want: undefined},
+		{query: "data.authz.deny", input: map[string]any{"user": "bob"}, want: true},
+		{query: "data.authz.deny", input: map[string]any{"user": "alice"}, want: undefined},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expectResult(t, policy, tt.query, tt.input, tt.want)
+		})
+	}
+}
+
+func TestNot(t *testing.T) {
+	const policy = `package authz
+
+import rego.v1
+
+not_admin if not input.admin
+
+not_admin_path if not startswith(input.path, "/admin")
+
+not_member if not "ops" in input.groups
+
+# not over an undefined reference succeeds
+not_undefined if not input.a.b.c
+
+# not over a builtin called with a value of the wrong type succeeds
+not_wrong_type if not startswith(input.count, "x")
+
+# negation of a comparison
+not_equal if not input.user == "alice"
+`
+	tests := []struct {
+		name  string
+		query string
+		input map[string]any
+		want  any
+	}{
+		{name: "absent", query: "data.authz.not_admin", input: map[string]any{}, want: true},
+		{name: "false", query: "data.authz.not_admin", input: map[string]any{"admin": false}, want: true},
+		{name: "true", query: "data.authz.not_admin", input: map[string]any{"admin": true}, want: undefined},
+		{name: "path outside", query: "data.authz.not_admin_path", input: map[string]any{"path": "/api"}, want: true},
+		{name: "path inside", query: "data.authz.not_admin_path", input: map[string]any{"path": "/admin/users"}, want: undefined},
+		{name: "not member", query: "data.authz.not_member", input: map[string]any{"groups": []any{"dev"}}, want: true},
+		{name: "member", query: "data.authz.not_member", input: map[string]any{"groups": []any{"dev", "ops"}}, want: undefined},
+		{name: "undefined reference", query: "data.authz.not_undefined", input: map[string]any{"a": map[string]any{}}, want: true},
+		{name: "wrong type", query: "data.authz.not_wrong_type", input: map[string]any{"count": 3}, want: true},
+		{name: "different", query: "data.authz.not_equal", input: map[string]any{"user": "bob"}, want: true},
+		{name: "equal", query: "data.authz.not_equal", input: map[string]any{"user": "alice"}, want: undefined},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectResult(t, policy, tt.query, tt.input, tt.want)
+		})
+	}
+}
+
+func TestUnification(t *testing.T) {
+	const policy = `package authz
+
+import rego.v1
+
+bound_to_unbound if {
+	x := input.user
+	x = v
+	v == "alice"
+}
+
+ref_to_unbound if {
+	input.user = v
+	v == "alice"
+}
+
+unbound_to_ref if {
+	v = input.user
+	v == "alice"
+}
+
+array_to_ref if {
+	[user, level] = [input.user, 2]
+	user == "alice"
+	level == 2
+}
+
+arrays_both_ways if {
+	[user, 2] = [input.user, level]
+	user == "alice"
+	level == 2
+}
+
+different_lengths if [a, b] = [input.user]
+
+mismatch if input.user = "bob"
+`
+	tests := []struct {
+		name  string
+		query string
+		input map[string]any
+		want  any
+	}{
+		{name: "bound variable", query: "data.authz.bound_to_unbound", input: map[string]any{"user": "alice"}, want: true},
+		{name: "bound variable mismatch", query: "data.authz.bound_to_unbound", input: map[string]any{"user": "bob"}, want: undefined},
+		{name: "reference", query: "data.authz.ref_to_unbound", input: map[string]any{"user": "alice"}, want: true},
+		{name: "unbound on the left", query: "data.authz.unbound_to_ref", input: map[string]any{"user": "alice"}, want: true},
+		{name: "array pattern", query: "data.authz.array_to_ref", input: map[string]any{"user": "alice"}, want: true},
+		{name: "arrays binding each other", query: "data.authz.arrays_both_ways", input: map[string]any{"user": "alice"}, want: true},
+		{name: "different lengths", query: "data.authz.different_lengths", input: map[string]any{"user": "alice"}, want: undefined},
+		{name: "mismatch", query: "data.authz.mismatch", input: map[string]any{"user": "alice"}, want: undefined},
+		{name: "match", query: "data.authz.mismatch", input: map[string]any{"user": "bob"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectResult(t, policy, tt.query, tt.input, tt.want)
+		})
+	}
+}
+
+func TestConflictingCompleteRules(t *testing.T) {
+	const policy = `package authz
+
+import rego.v1
+
+level := 1 if input.a
+level := 2 if input.b
+
+# several definitions producing the same value do not conflict
+same := "x" if input.a
+same := "x" if input.b
+
+double(n) := 1 if n > 0
+double(n) := 2 if n > 1
+
+conflict_call := double(input.n)
+
+labels[key] := value if {
+	some key, value in input.labels
+}
+labels["extra"] := "other" if input.extra
+`
+	t.Run("single definition", func(t *testing.T) {
+		expectResult(t, policy, "data.authz.level", map[string]any{"a": true}, float64(1))
+	})
+	t.Run("no definition", func(t *testing.T) {
+		expectResult(t, policy, "data.authz.level", map[string]any{}, undefined)
+	})
+	t.Run("same value", func(t *testing.T) {
+		expectResult(t, policy, "data.authz.same", map[string]any{"a": true, "b": true}, "x")
+	})
+	t.Run("function with one definition", func(t *testing.T) {
+		expectResult(t, policy, "data.authz.conflict_call", map[string]any{"n": 1}, float64(1))
+	})
+
+	conflicts := []struct {
+		name  string
+		query string
+		input map[string]any
+	}{
+		{name: "complete rule", query: "data.authz.level", input: map[string]any{"a": true, "b": true}},
+		{name: "function", query: "data.authz.conflict_call", input: map[string]any{"n": 2}},
+		{name: "object rule key", query: "data.authz.labels", input: map[string]any{"labels": map[string]any{"extra": "value"}, "extra": true}},
+	}
+	for _, tt := range conflicts {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := evalQuery(t, policy, tt.query, tt.input)
+			var regoErr *Error
+			if !errors.As(err, &regoErr) || !strings.Contains(err.Error(), "conflicting") {
+				t.Errorf("Eval(%s) error = %v, want a conflict error", tt.query, err)
+			}
+		})
+	}
+}
+
+func TestSomeAndEvery(t *testing.T) {
+	const policy = `package authz
+
+import rego.v1
+
+admin if {
+	some role in input.roles
+	role == "admin"
+}
+
+admin_index if {
+	some i
+	input.roles[i] == "admin"
+}
+
+admin_wildcard if input.roles[_] == "admin"
+
+admin_v0 {
+	input.roles[_] == "admin"
+}
+
+owned_project if {
+	some name, project in input.projects
+	project.owner == input.user
+	name != "archived"
+}
+
+all_app_roles if {
+	every role in input.roles {
+		startswith(role, "app-")
+	}
+}
+
+all_small if {
+	every key, value in input.quotas {
+		value < 10
+		key != "forbidden"
+	}
+}
+
+roles contains role if {
+	some role in input.roles
+	startswith(role, "app-")
+}
+
+role_count := count({r | some r in input.roles})
+
+names := [name | some name, _ in input.projects]
+`
+	roles := func(values ...any) map[string]any { return map[string]any{"roles": values} }
+	tests := []struct {
+		name  string
+		query string
+		input map[string]any
+		want  any
+	}{
+		{name: "some in", query: "data.authz.admin", input: roles("dev", "admin"), want: true},
+		{name: "some in without match", query: "data.authz.admin", input: roles("dev"), want: undefined},
+		{name: "some in empty", query: "data.authz.admin", input: roles(), want: undefined},
+		{name: "some index", query: "data.authz.admin_index", input: roles("dev", "admin"), want: true},
+		{name: "some index without match", query: "data.authz.admin_index", input: roles("dev"), want: undefined},
+		{name: "wildcard", query: "data.authz.admin_wildcard", input: roles("admin"), want: true},
+		{name: "v0 body", query: "data.authz.admin_v0", input: roles("admin"), want: true},
+		{
+			name:  "some key value",
+			query: "data.authz.owned_project",
+			input: map[string]any{"user": "alice", "projects": map[string]any{
+				"archived": map[string]any{"owner": "alice"},
+				"billing":  map[string]any{"owner": "bob"},
+			}},
+			want: undefined,
+		},
+		{
+			name:  "some key value with match",
+			query: "data.authz.owned_project",
+			input: map[string]any{"user": "alice", "projects": map[string]any{
+				"archived": map[string]any{"owner": "alice"},
+				"portal":   map[string]any{"owner": "alice"},
+			}},
+			want: true,
+		},
+		{name: "every", query: "data.authz.all_app_roles", input: roles("app-read", "app-write"), want: true},
+		{name: "every with one failure", query: "data.authz.all_app_roles", input: roles("app-read", "admin"), want: undefined},
+		{name: "every over empty", query: "data.authz.all_app_roles", input: roles(), want: true},
+		{name: "every over undefined", query: "data.authz.all_app_roles", input: map[string]any{}, want: undefined},
+		{name: "every key value", query: "data.authz.all_small", input: map[string]any{"quotas": map[string]any{"cpu": 4, "memory": 8}}, want: true},
+		{name: "every key value failure", query: "data.authz.all_small", input: map[string]any{"quotas": map[string]any{"cpu": 4, "forbidden": 1}}, want: undefined},
+		{name: "partial set", query: "data.authz.roles", input: roles("app-read", "admin", "app-read"), want: []any{"app-read"}},
+		{name: "partial set empty", query: "data.authz.roles", input: roles("admin"), want: []any{}},
+		{name: "set comprehension", query: "data.authz.role_count", input: roles("a", "b", "a"), want: float64(2)},
+		{
+			name:  "array comprehension",
+			query: "data.authz.names",
+			input: map[string]any{"projects": map[string]any{"b": 1, "a": 2}},
+			want:  []any{"a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectResult(t, policy, tt.query, tt.input, tt.want)
+		})
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{expr: `count([1, 2, 3])`, want: float64(3)},
+		{expr: `count("héllo")`, want: float64(5)},
+		{expr: `count({"a": 1})`, want: float64(1)},
+		{expr: `sum([1, 2, 3.5])`, want: 6.5},
+		{expr: `product([2, 3])`, want: float64(6)},
+		{expr: `max([3, 9, 1])`, want: float64(9)},
+		{expr: `min({3, 9, 1})`, want: float64(1)},
+		{expr: `sort([3, 1, 2])`, want: []any{float64(1), float64(2), float64(3)}},
+		{expr: `abs(-2)`, want: float64(2)},
+		{expr: `round(2.5)`, want: float64(3)},
+		{expr: `floor(2.7)`, want: float64(2)},
+		{expr: `ceil(2.1)`, want: float64(3)},
+		{expr: `concat("/", ["a", "b"])`, want: "a/b"},
+		{expr: `contains("gateway", "tew")`, want: true},
+		{expr: `startswith("/api/users", "/api")`, want: true},
+		{expr: `endswith("alice@corp.com", "@corp.com")`, want: true},
+		{expr: `indexof("abc", "c")`, want: float64(2)},
+		{expr: `indexof("abc", "z")`, want: float64(-1)},
+		{expr: `split("a,b", ",")`, want: []any{"a", "b"}},
+		{expr: `lower("ABC")`, want: "abc"},
+		{expr: `upper("abc")`, want: "ABC"},
+		{expr: `trim_space("  x ")`, want: "x"},
+		{expr: `trim_prefix("/api/x", "/api")`, want: "/x"},
+		{expr: `replace("a-b-c", "-", "+")`, want: "a+b+c"},
+		{expr: `substring("gateway", 3, 3)`, want: "ewa"},
+		{expr: `substring("gateway", 3, -1)`, want: "eway"},
+		{expr: `sprintf("%s has %d roles", ["alice", 2])`, want: "alice has 2 roles"},
+		{expr: `to_number("42")`, want: float64(42)},
+		{expr: `regex.match("^[a-z]+$", "abc")`, want: true},
+		{expr: `regex.match("^[a-z]+$", "ABC")`, want: false},
+		{expr: `is_string("x")`, want: true},
+		{expr: `is_number("x")`, want: false},
+		{expr: `type_name({1})`, want: "set"},
+		{expr: `array.concat([1], [2])`, want: []any{float64(1), float64(2)}},
+		{expr: `array.slice([1, 2, 3], 1, 2)`, want: []any{float64(2)}},
+		{expr: `object.get({"a": 1}, "b", "default")`, want: "default"},
+		{expr: `object.get({"a": 1}, "a", "default")`, want: float64(1)},
+		{expr: `object.keys({"b": 1, "a": 2})`, want: []any{"a", "b"}},
+		{expr: `union({{1}, {2}})`, want: []any{float64(1), float64(2)}},
+		{expr: `intersection({{1, 2}, {2, 3}})`, want: []any{float64(2)}},
+		{expr: `{1, 2} | {3}`, want: []any{float64(1), float64(2), float64(3)}},
+		{expr: `{1, 2} & {2}`, want: []any{float64(2)}},
+		{expr: `{1, 2} - {2}`, want: []any{float64(1)}},
+		{expr: `net.cidr_contains("10.0.0.0/8", "10.1.2.3")`, want: true},
+		{expr: `net.cidr_contains("10.0.0.0/8", "192.168.0.1")`, want: false},
+		{expr: `json.unmarshal("{\"a\": [1]}")`, want: map[string]any{"a": []any{float64(1)}}},
+		{expr: `json.marshal({"a": 1})`, want: `{"a":1}`},
+		{expr: `time.parse_rfc3339_ns("1970-01-01T00:00:01Z")`, want: float64(1e9)},
+		{expr: `7 % 3`, want: float64(1)},
+		{expr: `1 + 2 * 3`, want: float64(7)},
+		{expr: `"b" > "a"`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			policy := "package builtins\n\nimport rego.v1\n\nresult := " + tt.expr + "\n"
+			expectResult(t, policy, "data.builtins.result", nil, tt.want)
+		})
+	}
+}
+
+func TestBuiltinsUndefinedOnInvalidArguments(t *testing.T) {
+	exprs := []string{
+		`count(1)`,
+		`sum(["a"])`,
+		`startswith(1, "a")`,
+		`concat("/", [1])`,
+		`to_number("forty-two")`,
+		`regex.match("(", "a")`,
+		`net.cidr_contains("not a cidr", "10.0.0.1")`,
+		`json.unmarshal("{")`,
+		`substring("abc", -1, 1)`,
+		`1 / 0`,
+		`"a" + 1`,
+		`input.missing`,
+	}
+	for _, expr := range exprs {
+		t.Run(expr, func(t *testing.T) {
+			policy := "package builtins\n\nimport rego.v1\n\nresult := " + expr + "\n"
+			expectResult(t, policy, "data.builtins.result", nil, undefined)
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{name: "unknown function", policy: "package p\n\nallow if unknown(1)\n", want: "undefined function"},
+		{name: "builtin arity", policy: "package p\n\nallow if startswith(\"a\")\n", want: "expects 2 argument(s)"},
+		{name: "several defaults", policy: "package p\n\ndefault allow := false\ndefault allow := true\n", want: "several default values"},
+		{name: "different kinds", policy: "package p\n\nimport rego.v1\n\nx := 1\nx contains 2\n", want: "different kinds"},
+		{name: "else", policy: "package p\n\nimport rego.v1\n\nx := 1 if input.a else := 2\n", want: "else is not supported"},
+		{name: "else on the next line", policy: "package p\n\nimport rego.v1\n\nx := 1 if input.a\nelse := 2\n", want: "else is not supported"},
+		{name: "dotted rule head", policy: "package p\n\nimport rego.v1\n\nlabels.extra := 1 if input.a\n", want: "expected rule body or value"},
+		{name: "keyword as rule name", policy: "package p\n\nimport rego.v1\n\nsome := 1\n", want: "cannot name a rule"},
+		{name: "with", policy: "package p\n\nimport rego.v1\n\nx if y with input as {}\n\ny if input.a\n", want: "with"},
+		{name: "glob.match", policy: "package p\n\nallow if glob.match(\"*.corp.com\", [\".\"], input.host)\n", want: "undefined function glob.match"},
+		{name: "io.jwt", policy: "package p\n\nallow if io.jwt.decode(input.token)\n", want: "undefined function io.jwt.decode"},
+		{name: "integer beyond 2^53", policy: "package p\n\nallow if input.id == 9007199254740993\n", want: "cannot be represented exactly"},
+		{name: "missing package", policy: "allow := true\n", want: "package"},
+		{name: "unterminated body", policy: "package p\n\nallow {\n", want: ""},
+		{name: "empty body", policy: "package p\n\nallow {}\n", want: "empty rule body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(map[string]string{"policy.rego": tt.policy})
+			if err == nil {
+				t.Fatal("New() succeeded, want an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("New() error = %q, want it to mention %q", err, tt.want)
+			}
+			if !strings.Contains(err.Error(), "policy.rego") {
+				t.Errorf("New() error = %q, want the file name", err)
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	engine, err := New(map[string]string{
+		"a.rego": "package httpapi.authz\n\nimport rego.v1\n\nallow if data.httpapi.roles.admin\n\nf(x) := x\n",
+		"b.rego": "package httpapi.roles\n\nimport rego.v1\n\nadmin if \"admin\" in input.roles\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"data.httpapi.authz.missing", "allow", "data.httpapi.authz.f"} {
+		if _, err := engine.Query(path); err == nil {
+			t.Errorf("Query(%q) succeeded, want an error", path)
+		}
+	}
+
+	q, err := engine.Query("httpapi.authz.allow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.String() != "data.httpapi.authz.allow" {
+		t.Errorf("String() = %q", q.String())
+	}
+	// Rules of other packages are reached through data
+	result, defined, err := q.Eval(context.Background(), map[string]any{"roles": []string{"admin"}})
+	if err != nil || !defined || result != true {
+		t.Errorf("Eval() = %v, %v, %v, want true", result, defined, err)
+	}
+}
+
+func TestRecursiveRules(t *testing.T) {
+	const policy = "package p\n\nimport rego.v1\n\na if b\n\nb if a\n"
+	_, _, err := evalQuery(t, policy, "data.p.a", nil)
+	if err == nil || !strings.Contains(err.Error(), "recursive") {
+		t.Errorf("Eval() error = %v, want a recursion error", err)
+	}
+}
@@ -0,0 +1,296 @@
+package rego
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Values manipulated by policies are nil, bool, float64, string, []any,
+// map[string]any and *Set.
+
+// Set is an unordered collection of distinct values
+type Set struct {
+	index    map[string]struct{}
+	elements []any
+}
+
+func newSet() *Set {
+	return &Set{index: make(map[string]struct{})}
+}
+
+func (s *Set) add(value any) {
+	key := valueKey(value)
+	if _, ok := s.index[key]; ok {
+		return
+	}
+	s.index[key] = struct{}{}
+	s.elements = append(s.elements, value)
+}
+
+func (s *Set) has(value any) bool {
+	_, ok := s.index[valueKey(value)]
+	return ok
+}
+
+// Elements returns the elements of the set in a stable order
+func (s *Set) Elements() []any {
+	elements := append([]any(nil), s.elements...)
+	sort.Slice(elements, func(i, j int) bool { return compareValues(elements[i], elements[j]) < 0 })
+	return elements
+}
+
+// MarshalJSON encodes the set as a sorted array
+func (s *Set) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Elements())
+}
+
+// valueKey returns a canonical representation of a value
+func valueKey(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return "n" + strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return strconv.Quote(v)
+	case []any:
+		parts := make([]string, len(v))
+		for i, element := range v {
+			parts[i] = valueKey(element)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			parts[i] = strconv.Quote(key) + ":" + valueKey(v[key])
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	case *Set:
+		parts := make([]string, 0, len(v.index))
+		for key := range v.index {
+			parts = append(parts, key)
+		}
+		sort.Strings(parts)
+		return "set(" + strings.Join(parts, ",") + ")"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// typeRank orders the types as Rego does: null < boolean < number < string < array < object < set
+func typeRank(value any) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	case []any:
+		return 4
+	case map[string]any:
+		return 5
+	case *Set:
+		return 6
+	}
+	return 7
+}
+
+// compareValues defines a total order over values
+func compareValues(a, b any) int {
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		return ra - rb
+	}
+	switch x := a.(type) {
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case float64:
+		y := b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	case []any:
+		y := b.([]any)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return len(x) - len(y)
+	}
+	return strings.Compare(valueKey(a), valueKey(b))
+}
+
+func equalValues(a, b any) bool {
+	return typeRank(a) == typeRank(b) && valueKey(a) == valueKey(b)
+}
+
+// normalize converts the usual Go types of an input document to policy values
+func normalize(value any) any {
+	switch v := value.(type) {
+	case nil, bool, float64, string, *Set:
+		return v
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case []any:
+		list := make([]any, len(v))
+		for i, element := range v {
+			list[i] = normalize(element)
+		}
+		return list
+	case []string:
+		list := make([]any, len(v))
+		for i, element := range v {
+			list[i] = element
+		}
+		return list
+	case map[string]any:
+		object := make(map[string]any, len(v))
+		for key, element := range v {
+			object[key] = normalize(element)
+		}
+		return object
+	case map[string]string:
+		object := make(map[string]any, len(v))
+		for key, element := range v {
+			object[key] = element
+		}
+		return object
+	case map[string][]string:
+		object := make(map[string]any, len(v))
+		for key, element := range v {
+			object[key] = normalize(element)
+		}
+		return object
+	}
+	// other types go through their JSON representation
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil
+	}
+	return normalize(decoded)
+}
+
+// export converts a policy value to plain Go values; sets become sorted arrays
+func export(value any) any {
+	switch v := value.(type) {
+	case []any:
+		list := make([]any, len(v))
+		for i, element := range v {
+			list[i] = export(element)
+		}
+		return list
+	case map[string]any:
+		object := make(map[string]any, len(v))
+		for key, element := range v {
+			object[key] = export(element)
+		}
+		return object
+	case *Set:
+		return export(v.Elements())
+	}
+	return value
+}
+
+// toInt converts an integral number to an int
+func toInt(value any) (int, bool) {
+	n, ok := value.(float64)
+	if !ok || n != math.Trunc(n) || math.IsInf(n, 0) {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// iterate calls fn with each key and element of a collection
+func iterate(collection any, fn func(key, value any) error) error {
+	switch c := collection.(type) {
+	case []any:
+		for i, element := range c {
+			if err := fn(float64(i), element); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(c))
+		for key := range c {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := fn(key, c[key]); err != nil {
+				return err
+			}
+		}
+	case *Set:
+		for _, element := range c.Elements() {
+			if err := fn(element, element); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lookup reads collection[key]
+func lookup(collection, key any) (any, bool) {
+	switch c := collection.(type) {
+	case []any:
+		i, ok := toInt(key)
+		if !ok || i < 0 || i >= len(c) {
+			return nil, false
+		}
+		return c[i], true
+	case map[string]any:
+		name, ok := key.(string)
+		if !ok {
+			return nil, false
+		}
+		value, found := c[name]
+		return value, found
+	case *Set:
+		if c.has(key) {
+			return key, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// audience représente le claim aud, qui peut être une chaîne ou une liste (RFC 7519 §4.1.3)
+type audience []string
+
+// UnmarshalJSON accepte une chaîne unique ou un tableau de chaînes
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("invalid aud claim: %w", err)
+	}
+	*a = multiple
+	return nil
+}
+
+// tokenTrust contient les issuers et audiences acceptés pour une route
+type tokenTrust struct {
+	issuers   []string
+	audiences []string
+}
+
+// newTokenTrust calcule les issuers et audiences acceptés par une route. Les
+// listes de la route remplacent celles du serveur ; à défaut d'issuers
+// configurés, seuls les issuers des fournisseurs acceptés par la route le sont.
+func (s *proxyServer) newTokenTrust(route config.Route) tokenTrust {
+	oauth2Cfg := s.cfg.Server.OAuth2
+	trust := tokenTrust{
+		issuers:   route.Issuers,
+		audiences: route.Audiences,
+	}
+	if len(trust.issuers) == 0 {
+		trust.issuers = oauth2Cfg.Issuers
+	}
+	if len(trust.issuers) == 0 {
+		// routeProviders a déjà été validé au démarrage
+		providers, _ := s.routeProviders(route)
+		for _, provider := range providers {
+			if provider.cfg.Issuer == "" {
+				// Un fournisseur sans issuer déclaré ne permet pas de restreindre iss
+				trust.issuers = nil
+				break
+			}
+			trust.issuers = append(trust.issuers, provider.cfg.Issuer)
+		}
+	}
+	if len(trust.audiences) == 0 {
+		trust.audiences = oauth2Cfg.Audiences
+	}
+	return trust
+}
+
+// check vérifie que le token a été émis par un issuer accepté et pour une
+// audience acceptée (aud, azp ou client_id)
+func (t tokenTrust) check(tokenInfo *TokenInfo) error {
+	if len(t.issuers) > 0 {
+		issuer := strings.TrimSuffix(tokenInfo.Issuer, "/")
+		accepted := slices.ContainsFunc(t.issuers, func(expected string) bool {
+			return strings.TrimSuffix(expected, "/") == issuer
+		})
+		if !accepted {
+			return fmt.Errorf("issuer %q non accepté pour cette route", tokenInfo.Issuer)
+		}
+	}
+
+	if len(t.audiences) > 0 {
+		candidates := append(slices.Clone([]string(tokenInfo.Audience)), tokenInfo.AuthorizedParty, tokenInfo.ClientID)
+		accepted := slices.ContainsFunc(candidates, func(candidate string) bool {
+			return candidate != "" && slices.Contains(t.audiences, candidate)
+		})
+		if !accepted {
+			return fmt.Errorf("audience %v non acceptée pour cette route (attendu: %v)", []string(tokenInfo.Audience), t.audiences)
+		}
+	}
+	return nil
+}
+
+// checkUserInfoTrust refuse au démarrage les restrictions qu'un token validé
+// par userinfo ne pourrait jamais satisfaire : la réponse userinfo ne contient
+// ni aud, ni azp, ni client_id, et son issuer est celui déclaré pour le
+// fournisseur. Sans ce contrôle, tous ces tokens seraient rejetés.
+func (s *proxyServer) checkUserInfoTrust(route config.Route) error {
+	if routeAuthMode(route) == authNone {
+		return nil
+	}
+	trust := s.newTokenTrust(route)
+	// routeProviders a déjà été validé par buildProviderValidator
+	providers, _ := s.routeProviders(route)
+	for _, provider := range providers {
+		if !slices.Contains(provider.validatorNames(route.Validators), validatorUserInfo) {
+			continue
+		}
+		if len(trust.audiences) > 0 {
+			return fmt.Errorf("provider %s: userinfo validation cannot check audiences %v, userinfo responses carry no aud, azp or client_id", provider.name, trust.audiences)
+		}
+		if len(trust.issuers) > 0 && provider.cfg.Issuer == "" {
+			return fmt.Errorf("provider %s: userinfo validation cannot check issuers %v without the provider issuer", provider.name, trust.issuers)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestTokenTrustCheck(t *testing.T) {
+	trust := tokenTrust{issuers: []string{"https://idp/realms/demo/"}, audiences: []string{"backend"}}
+	tests := []struct {
+		name    string
+		token   TokenInfo
+		wantErr bool
+	}{
+		{name: "aud", token: TokenInfo{Issuer: "https://idp/realms/demo", Audience: audience{"account", "backend"}}},
+		{name: "azp", token: TokenInfo{Issuer: "https://idp/realms/demo", AuthorizedParty: "backend"}},
+		{name: "client_id", token: TokenInfo{Issuer: "https://idp/realms/demo/", ClientID: "backend"}},
+		{name: "other audience", token: TokenInfo{Issuer: "https://idp/realms/demo", Audience: audience{"account"}}, wantErr: true},
+		{name: "no audience", token: TokenInfo{Issuer: "https://idp/realms/demo"}, wantErr: true},
+		{name: "other issuer", token: TokenInfo{Issuer: "https://idp/realms/other", Audience: audience{"backend"}}, wantErr: true},
+		{name: "no issuer", token: TokenInfo{Audience: audience{"backend"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := trust.check(&tt.token); (err != nil) != tt.wantErr {
+				t.Errorf("check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Une réponse userinfo ne contient ni aud, ni azp, ni client_id : les
+// restrictions qu'elle ne peut pas satisfaire sont refusées au démarrage
+func TestUserInfoTrust(t *testing.T) {
+	issuer := newTestIssuer(t)
+	tests := []struct {
+		name       string
+		validators []string
+		audiences  []string
+		issuers    []string
+		route      config.Route
+		wantErr    bool
+	}{
+		{name: "userinfo", validators: []string{validatorUserInfo}},
+		{name: "userinfo by default"},
+		{name: "server audiences", validators: []string{validatorUserInfo}, audiences: []string{"backend"}, wantErr: true},
+		{name: "default chain with audiences", audiences: []string{"backend"}, wantErr: true},
+		{name: "userinfo fallback with audiences", validators: []string{validatorJWKS, validatorUserInfo}, audiences: []string{"backend"}, wantErr: true},
+		{name: "route audiences", validators: []string{validatorUserInfo},
+			route: config.Route{Audiences: []string{"backend"}}, wantErr: true},
+		{name: "route validators with audiences", validators: []string{validatorJWKS}, audiences: []string{"backend"},
+			route: config.Route{Validators: []string{validatorUserInfo}}, wantErr: true},
+		{name: "jwks route with audiences", validators: []string{validatorUserInfo}, audiences: []string{"backend"},
+			route: config.Route{Validators: []string{validatorJWKS}, Audiences: []string{"backend"}}},
+		{name: "public route with audiences", validators: []string{validatorUserInfo}, audiences: []string{"backend"},
+			route: config.Route{Auth: authNone}},
+		{name: "issuers without provider issuer", validators: []string{validatorUserInfo}, issuers: []string{issuer.server.URL}, wantErr: true},
+		{name: "jwks with audiences and issuers", validators: []string{validatorJWKS}, audiences: []string{"backend"}, issuers: []string{issuer.server.URL}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := tt.route
+			route.Path = "/api"
+			route.Target = "http://backend"
+			cfg := testConfig(issuer, route)
+			cfg.Server.OAuth2.Provider.Endpoints.UserInfoURL = issuer.server.URL + "/userinfo"
+			cfg.Server.OAuth2.Provider.Validators = tt.validators
+			cfg.Server.OAuth2.Audiences = tt.audiences
+			cfg.Server.OAuth2.Issuers = tt.issuers
+			s := &proxyServer{cfg: cfg}
+			if err := s.initTokenValidation(t.Context()); (err != nil) != tt.wantErr {
+				t.Errorf("initTokenValidation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// missingRequirements liste ce qui manque au token pour accéder à une route
+type missingRequirements struct {
+	Teams       []string            `json:"teams,omitempty"`
+	DeniedTeams []string            `json:"denied_teams,omitempty"`
+	Roles       []string            `json:"roles,omitempty"`
+	ClientRoles map[string][]string `json:"client_roles,omitempty"`
+	Scopes      []string            `json:"scopes,omitempty"`
+	Policies    []string            `json:"policies,omitempty"`
+	Params      []string            `json:"params,omitempty"`
+}
+
+// empty indique que toutes les exigences sont satisfaites
+func (m missingRequirements) empty() bool {
+	return len(m.Teams) == 0 && len(m.DeniedTeams) == 0 && len(m.Roles) == 0 &&
+		len(m.ClientRoles) == 0 && len(m.Scopes) == 0 && len(m.Policies) == 0 && len(m.Params) == 0
+}
+
+// merge ajoute les exigences manquantes d'une autre règle
+func (m *missingRequirements) merge(other missingRequirements) {
+	m.Teams = append(m.Teams, other.Teams...)
+	m.DeniedTeams = append(m.DeniedTeams, other.DeniedTeams...)
+	m.Roles = append(m.Roles, other.Roles...)
+	m.Scopes = append(m.Scopes, other.Scopes...)
+	m.Policies = append(m.Policies, other.Policies...)
+	m.Params = append(m.Params, other.Params...)
+	for client, roles := range other.ClientRoles {
+		if m.ClientRoles == nil {
+			m.ClientRoles = make(map[string][]string)
+		}
+		m.ClientRoles[client] = append(m.ClientRoles[client], roles...)
+	}
+}
+
+// hasRequirements indique si la règle déclare des exigences d'autorisation
+func hasRequirements(rule config.AccessRule) bool {
+	return len(rule.Teams) > 0 || len(rule.DenyTeams) > 0 || len(rule.Roles) > 0 ||
+		len(rule.ClientRoles) > 0 || len(rule.Scopes) > 0 || rule.Policy != "" || rule.Rego != ""
+}
+
+// hasAccessRules indique si la route déclare des exigences d'autorisation,
+// pour toutes les méthodes ou pour certaines d'entre elles, ou lie ses
+// paramètres de chemin ou ses tenants aux claims du token
+func hasAccessRules(route config.Route) bool {
+	if hasRequirements(route.AccessRule) || len(route.Params) > 0 || route.Tenancy.Claim != "" {
+		return true
+	}
+	return slices.ContainsFunc(route.Rules, func(rule config.MethodRule) bool {
+		return hasRequirements(rule.AccessRule)
+	})
+}
+
+// methodRule retourne la règle de la route applicable à une méthode HTTP.
+// HEAD, s'il n'est pas listé, suit la règle de GET dont il ne diffère que
+// par l'absence de corps.
+func methodRule(route config.Route, method string) (*config.MethodRule, bool) {
+	for i, rule := range route.Rules {
+		for _, ruleMethod := range rule.Methods {
+			if strings.EqualFold(ruleMethod, method) {
+				return &route.Rules[i], true
+			}
+		}
+	}
+	if strings.EqualFold(method, http.MethodHead) {
+		return methodRule(route, http.MethodGet)
+	}
+	return nil, false
+}
+
+// accessRulesFor retourne les exigences applicables à une requête : celles
+// de la route puis celles de la règle propre à la méthode
+func accessRulesFor(route config.Route, method string) []config.AccessRule {
+	rules := []config.AccessRule{route.AccessRule}
+	if rule, ok := methodRule(route, method); ok {
+		rules = append(rules, rule.AccessRule)
+	}
+	return rules
+}
+
+// requiresToken indique si une requête sur la route doit porter un token valide
+func requiresToken(route config.Route, method string) bool {
+	if route.Auth == authRequired || len(route.Params) > 0 || route.Tenancy.Claim != "" {
+		return true
+	}
+	return slices.ContainsFunc(accessRulesFor(route, method), hasRequirements)
+}
+
+// allowedMethods retourne les méthodes acceptées par les règles de la route
+func allowedMethods(route config.Route) []string {
+	var methods []string
+	for _, rule := range route.Rules {
+		for _, method := range rule.Methods {
+			method = strings.ToUpper(method)
+			if !slices.Contains(methods, method) {
+				methods = append(methods, method)
+			}
+		}
+	}
+	if slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+	return methods
+}
+
+// validateAccessRules vérifie au démarrage les règles d'autorisation d'une route
+func validateAccessRules(route config.Route) error {
+	// Sans validation du token, aucune exigence ne pourrait être vérifiée
+	if route.Auth == authNone && hasAccessRules(route) {
+		return fmt.Errorf("auth %q cannot be combined with access rules, path params or tenancy", authNone)
+	}
+	if err := validatePathParams(route); err != nil {
+		return err
+	}
+	if err := validateTenancy(route); err != nil {
+		return err
+	}
+	rules := []config.AccessRule{route.AccessRule}
+	for _, rule := range route.Rules {
+		if len(rule.Methods) == 0 {
+			return fmt.Errorf("method rule without methods")
+		}
+		rules = append(rules, rule.AccessRule)
+	}
+	for _, rule := range rules {
+		switch teamMatch(rule) {
+		case matchAny, matchAll:
+		default:
+			return fmt.Errorf("unknown team match mode %q", rule.Match)
+		}
+	}
+	return nil
+}
+
+// checkAccessRules évalue toutes les exigences applicables à la requête et
+// retourne celles qui manquent au token
+func (s *proxyServer) checkAccessRules(c *gin.Context, tokenInfo *TokenInfo, rules []config.AccessRule) missingRequirements {
+	var missing missingRequirements
+	for _, rule := range rules {
+		missing.merge(s.checkRequirements(tokenInfo, rule))
+		if rule.Policy != "" && !s.checkPolicy(c, tokenInfo, rule.Policy) {
+			missing.Policies = append(missing.Policies, rule.Policy)
+		}
+		if rule.Rego != "" && !s.checkRego(c, tokenInfo, rule.Rego) {
+			missing.Policies = append(missing.Policies, rule.Rego)
+		}
+	}
+	return missing
+}
+
+// checkRequirements évalue les teams, rôles realm, rôles client et scopes
+// exigés par une règle et retourne ceux qui manquent au token
+func (s *proxyServer) checkRequirements(tokenInfo *TokenInfo, rule config.AccessRule) missingRequirements {
+	var missing missingRequirements
+
+	// Les teams refusées priment sur les teams autorisées
+	for _, deniedTeam := range rule.DenyTeams {
+		if slices.Contains(tokenInfo.Groups, deniedTeam.Name) {
+			missing.DeniedTeams = append(missing.DeniedTeams, deniedTeam.Name)
+		}
+	}
+
+	if len(rule.Teams) > 0 && !s.hasRequiredTeams(tokenInfo, rule.Teams, teamMatch(rule)) {
+		for _, team := range rule.Teams {
+			if !slices.Contains(tokenInfo.Groups, team.Name) {
+				missing.Teams = append(missing.Teams, team.Name)
+			}
+		}
+		// Mode de correspondance inconnu : aucune team ne suffit
+		if len(missing.Teams) == 0 {
+			for _, team := range rule.Teams {
+				missing.Teams = append(missing.Teams, team.Name)
+			}
+		}
+	}
+
+	// Rôles realm : tous sont exigés
+	for _, role := range rule.Roles {
+		if !slices.Contains(tokenInfo.RealmAccess.Roles, role) {
+			missing.Roles = append(missing.Roles, role)
+		}
+	}
+
+	// Rôles client (resource_access) : tous sont exigés pour chaque client
+	for _, clientRoles := range rule.ClientRoles {
+		granted := tokenInfo.ResourceAccess[clientRoles.Client].Roles
+		for _, role := range clientRoles.Roles {
+			if !slices.Contains(granted, role) {
+				if missing.ClientRoles == nil {
+					missing.ClientRoles = make(map[string][]string)
+				}
+				missing.ClientRoles[clientRoles.Client] = append(missing.ClientRoles[clientRoles.Client], role)
+			}
+		}
+	}
+
+	// Scopes : tous sont exigés
+	grantedScopes := strings.Fields(tokenInfo.Scope)
+	for _, scope := range rule.Scopes {
+		if !slices.Contains(grantedScopes, scope) {
+			missing.Scopes = append(missing.Scopes, scope)
+		}
+	}
+
+	return missing
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestValidateAccessRules(t *testing.T) {
+	admins := []config.Team{{Name: "admins"}}
+	tests := []struct {
+		name    string
+		route   config.Route
+		wantErr bool
+	}{
+		{name: "no rules", route: config.Route{Path: "/api"}},
+		{name: "none without rules", route: config.Route{Path: "/api", Auth: authNone}},
+		{name: "none with empty teams", route: config.Route{Path: "/api", Auth: authNone, AccessRule: config.AccessRule{Teams: []config.Team{}}}},
+		{name: "none with method list only", route: config.Route{Path: "/api", Auth: authNone, Rules: []config.MethodRule{{Methods: []string{"GET"}}}}},
+		{name: "required with teams", route: config.Route{Path: "/api", Auth: authRequired, AccessRule: config.AccessRule{Teams: admins}}},
+		{name: "none with teams", route: config.Route{Path: "/api", Auth: authNone, AccessRule: config.AccessRule{Teams: admins}}, wantErr: true},
+		{name: "none with deny teams", route: config.Route{Path: "/api", Auth: authNone, AccessRule: config.AccessRule{DenyTeams: admins}}, wantErr: true},
+		{name: "none with roles", route: config.Route{Path: "/api", Auth: authNone, AccessRule: config.AccessRule{Roles: []string{"admin"}}}, wantErr: true},
+		{name: "none with scopes", route: config.Route{Path: "/api", Auth: authNone, AccessRule: config.AccessRule{Scopes: []string{"read"}}}, wantErr: true},
+		{name: "none with policy", route: config.Route{Path: "/api", Auth: authNone, AccessRule: config.AccessRule{Policy: "true"}}, wantErr: true},
+		{name: "none with rego", route: config.Route{Path: "/api", Auth: authNone, AccessRule: config.AccessRule{Rego: "data.authz.allow"}}, wantErr: true},
+		{
+			name: "none with method rule",
+			route: config.Route{Path: "/api", Auth: authNone, Rules: []config.MethodRule{
+				{Methods: []string{"DELETE"}, AccessRule: config.AccessRule{Roles: []string{"admin"}}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "none with path params",
+			route:   config.Route{Path: "/api/users/:id", Auth: authNone, Params: []config.PathParam{{Name: "id", Claim: "sub"}}},
+			wantErr: true,
+		},
+		{
+			name:    "none with tenancy",
+			route:   config.Route{Path: "/api/:tenant", Auth: authNone, Tenancy: config.Tenancy{Claim: "tenant_id", Param: "tenant"}},
+			wantErr: true,
+		},
+		{name: "unknown team match", route: config.Route{Path: "/api", AccessRule: config.AccessRule{Teams: admins, Match: "most"}}, wantErr: true},
+		{name: "method rule without methods", route: config.Route{Path: "/api", Rules: []config.MethodRule{{}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAccessRules(tt.route)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAccessRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthNoneWithAccessRulesFailsAtStartup(t *testing.T) {
+	issuer := newTestIssuer(t)
+	cfg := testConfig(issuer, config.Route{
+		Path:       "/api/admin",
+		Target:     "http://backend.invalid",
+		Auth:       authNone,
+		AccessRule: config.AccessRule{Teams: []config.Team{{Name: "admins"}}},
+	})
+	s := &proxyServer{cfg: cfg}
+	if err := s.initTokenValidation(t.Context()); err == nil {
+		t.Fatal("initTokenValidation accepted auth none with teams")
+	}
+}
+
+func TestAuthNoneForwardsWithoutToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	backend, received := newTestBackend(t)
+	_, gateway := newTestGateway(t, testConfig(issuer,
+		config.Route{Path: "/api/public", Target: backend.URL, Auth: authNone},
+	))
+	resp := doRequest(t, gateway, http.MethodGet, "/api/public", bearer("not-a-token"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if _, ok := lastRequest(received); !ok {
+		t.Fatal("request did not reach the backend")
+	}
+}
+
+func TestHasRequiredTeams(t *testing.T) {
+	teams := func(names ...string) []config.Team {
+		result := make([]config.Team, len(names))
+		for i, name := range names {
+			result[i] = config.Team{Name: name}
+		}
+		return result
+	}
+	tests := []struct {
+		name     string
+		groups   []string
+		required []config.Team
+		match    string
+		want     bool
+	}{
+		{name: "any with one team", groups: []string{"dev"}, required: teams("ops", "dev"), match: matchAny, want: true},
+		{name: "any with no team", groups: []string{"sales"}, required: teams("ops", "dev"), match: matchAny, want: false},
+		{name: "all with every team", groups: []string{"ops", "dev", "sales"}, required: teams("ops", "dev"), match: matchAll, want: true},
+		{name: "all with one team", groups: []string{"dev"}, required: teams("ops", "dev"), match: matchAll, want: false},
+		{name: "any without groups", required: teams("ops"), match: matchAny, want: false},
+		{name: "all without groups", required: teams("ops"), match: matchAll, want: false},
+		{name: "any with empty list", groups: []string{"dev"}, match: matchAny, want: true},
+		{name: "all with empty list", groups: []string{"dev"}, match: matchAll, want: true},
+		{name: "empty list without groups", match: matchAny, want: true},
+		{name: "case sensitive", groups: []string{"Dev"}, required: teams("dev"), match: matchAny, want: false},
+		{name: "unknown match with every team", groups: []string{"ops", "dev"}, required: teams("ops", "dev"), match: "most", want: false},
+	}
+	s := &proxyServer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenInfo := &TokenInfo{Groups: tt.groups}
+			if got := s.hasRequiredTeams(tokenInfo, tt.required, tt.match); got != tt.want {
+				t.Errorf("hasRequiredTeams(%v, %v, %q) = %v, want %v", tt.groups, tt.required, tt.match, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRequirementsTeams(t *testing.T) {
+	tests := []struct {
+		name       string
+		groups     []string
+		rule       config.AccessRule
+		wantTeams  []string
+		wantDenied []string
+	}{
+		{
+			name:   "any granted",
+			groups: []string{"dev"},
+			rule:   config.AccessRule{Teams: []config.Team{{Name: "ops"}, {Name: "dev"}}},
+		},
+		{
+			name:      "any missing lists every team",
+			groups:    []string{"sales"},
+			rule:      config.AccessRule{Teams: []config.Team{{Name: "ops"}, {Name: "dev"}}},
+			wantTeams: []string{"ops", "dev"},
+		},
+		{
+			name:   "all granted",
+			groups: []string{"ops", "dev"},
+			rule:   config.AccessRule{Teams: []config.Team{{Name: "ops"}, {Name: "dev"}}, Match: matchAll},
+		},
+		{
+			name:      "all lists the missing team",
+			groups:    []string{"dev"},
+			rule:      config.AccessRule{Teams: []config.Team{{Name: "ops"}, {Name: "dev"}}, Match: matchAll},
+			wantTeams: []string{"ops"},
+		},
+		{
+			name:   "empty team lists",
+			groups: []string{"dev"},
+			rule:   config.AccessRule{Teams: []config.Team{}, DenyTeams: []config.Team{}},
+		},
+		{
+			name: "empty groups without teams",
+			rule: config.AccessRule{},
+		},
+		{
+			name:       "deny team overrides an allowed team",
+			groups:     []string{"dev", "contractors"},
+			rule:       config.AccessRule{Teams: []config.Team{{Name: "dev"}}, DenyTeams: []config.Team{{Name: "contractors"}}},
+			wantDenied: []string{"contractors"},
+		},
+		{
+			name:       "deny team without allowed teams",
+			groups:     []string{"contractors"},
+			rule:       config.AccessRule{DenyTeams: []config.Team{{Name: "contractors"}}},
+			wantDenied: []string{"contractors"},
+		},
+		{
+			name:   "deny team not held",
+			groups: []string{"dev"},
+			rule:   config.AccessRule{Teams: []config.Team{{Name: "dev"}}, DenyTeams: []config.Team{{Name: "contractors"}}},
+		},
+		{
+			name:      "unknown match denies",
+			groups:    []string{"ops", "dev"},
+			rule:      config.AccessRule{Teams: []config.Team{{Name: "ops"}, {Name: "dev"}}, Match: "most"},
+			wantTeams: []string{"ops", "dev"},
+		},
+	}
+	s := &proxyServer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missing := s.checkRequirements(&TokenInfo{Groups: tt.groups}, tt.rule)
+			if !slices.Equal(missing.Teams, tt.wantTeams) {
+				t.Errorf("missing teams = %v, want %v", missing.Teams, tt.wantTeams)
+			}
+			if !slices.Equal(missing.DeniedTeams, tt.wantDenied) {
+				t.Errorf("denied teams = %v, want %v", missing.DeniedTeams, tt.wantDenied)
+			}
+			if granted := len(tt.wantTeams) == 0 && len(tt.wantDenied) == 0; missing.empty() != granted {
+				t.Errorf("access granted = %v, want %v", missing.empty(), granted)
+			}
+		})
+	}
+}
+
+func TestTeamsOnRoute(t *testing.T) {
+	issuer := newTestIssuer(t)
+	backend, _ := newTestBackend(t)
+	_, gateway := newTestGateway(t, testConfig(issuer, config.Route{
+		Path:   "/api/ops",
+		Target: backend.URL,
+		AccessRule: config.AccessRule{
+			Teams:     []config.Team{{Name: "ops"}, {Name: "sre"}},
+			DenyTeams: []config.Team{{Name: "contractors"}},
+		},
+	}))
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{name: "no token", want: http.StatusUnauthorized},
+		{name: "member", header: bearer(issuer.token(t, "alice", map[string]any{"groups": []string{"sre"}})), want: http.StatusOK},
+		{name: "not a member", header: bearer(issuer.token(t, "bob", map[string]any{"groups": []string{"sales"}})), want: http.StatusForbidden},
+		{name: "denied member", header: bearer(issuer.token(t, "carol", map[string]any{"groups": []string{"ops", "contractors"}})), want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := doRequest(t, gateway, http.MethodGet, "/api/ops", tt.header); resp.StatusCode != tt.want {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestMethodRules(t *testing.T) {
+	issuer := newTestIssuer(t)
+	backend, received := newTestBackend(t)
+	_, gateway := newTestGateway(t, testConfig(issuer,
+		config.Route{Path: "/api/items", Target: backend.URL, Rules: []config.MethodRule{
+			{Methods: []string{"get"}},
+			{Methods: []string{"DELETE"}, AccessRule: config.AccessRule{Roles: []string{"admin"}}},
+		}},
+		config.Route{Path: "/api/reports", Target: backend.URL, Rules: []config.MethodRule{
+			{Methods: []string{"GET"}, AccessRule: config.AccessRule{Scopes: []string{"reports:read"}}},
+		}},
+		config.Route{Path: "/api/status", Target: backend.URL, Rules: []config.MethodRule{
+			{Methods: []string{"GET"}},
+			{Methods: []string{"HEAD", "OPTIONS"}},
+		}},
+	))
+	admin := bearer(issuer.token(t, "alice", map[string]any{"realm_access": map[string]any{"roles": []string{"admin"}}}))
+	user := bearer(issuer.token(t, "bob", map[string]any{"scope": "openid reports:read"}))
+	preflight := http.Header{"Origin": {"https://app.example"}, "Access-Control-Request-Method": {"DELETE"}}
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		header    http.Header
+		want      int
+		wantAllow string
+	}{
+		{name: "listed method", method: http.MethodGet, path: "/api/items", want: http.StatusOK},
+		{name: "HEAD follows GET", method: http.MethodHead, path: "/api/items", want: http.StatusOK},
+		{name: "unlisted method", method: http.MethodPost, path: "/api/items", want: http.StatusMethodNotAllowed, wantAllow: "GET, DELETE, HEAD"},
+		{name: "unlisted OPTIONS", method: http.MethodOptions, path: "/api/items", want: http.StatusMethodNotAllowed, wantAllow: "GET, DELETE, HEAD"},
+		{name: "CORS preflight", method: http.MethodOptions, path: "/api/items", header: preflight, want: http.StatusNoContent},
+		{name: "method rule without token", method: http.MethodDelete, path: "/api/items", want: http.StatusUnauthorized},
+		{name: "method rule not met", method: http.MethodDelete, path: "/api/items", header: user, want: http.StatusForbidden},
+		{name: "method rule met", method: http.MethodDelete, path: "/api/items", header: admin, want: http.StatusOK},
+		{name: "HEAD requires the GET rule", method: http.MethodHead, path: "/api/reports", want: http.StatusUnauthorized},
+		{name: "HEAD with the GET rule met", method: http.MethodHead, path: "/api/reports", header: user, want: http.StatusOK},
+		{name: "HEAD without the GET rule met", method: http.MethodHead, path: "/api/reports", header: admin, want: http.StatusForbidden},
+		{name: "HEAD listed", method: http.MethodHead, path: "/api/status", want: http.StatusOK},
+		{name: "OPTIONS listed", method: http.MethodOptions, path: "/api/status", want: http.StatusOK},
+		{name: "unlisted method with HEAD listed", method: http.MethodPut, path: "/api/status", want: http.StatusMethodNotAllowed, wantAllow: "GET, HEAD, OPTIONS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, gateway, tt.method, tt.path, tt.header)
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+			if allow := resp.Header.Get("Allow"); allow != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", allow, tt.wantAllow)
+			}
+			request, ok := lastRequest(received)
+			if ok != (tt.want == http.StatusOK) {
+				t.Fatalf("backend reached = %v", ok)
+			}
+			if ok && request.method != tt.method {
+				t.Errorf("forwarded as %s, want %s", request.method, tt.method)
+			}
+		})
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []config.MethodRule
+		want  []string
+	}{
+		{name: "no rules"},
+		{name: "HEAD added with GET", rules: []config.MethodRule{{Methods: []string{"get", "POST"}}}, want: []string{"GET", "POST", "HEAD"}},
+		{name: "HEAD listed", rules: []config.MethodRule{{Methods: []string{"HEAD"}}, {Methods: []string{"GET"}}}, want: []string{"HEAD", "GET"}},
+		{name: "duplicates", rules: []config.MethodRule{{Methods: []string{"POST"}}, {Methods: []string{"post", "PUT"}}}, want: []string{"POST", "PUT"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allowedMethods(config.Route{Rules: tt.rules}); !slices.Equal(got, tt.want) {
+				t.Errorf("allowedMethods() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
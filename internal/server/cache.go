@@ -0,0 +1,210 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// defaultTokenCacheTTL est utilisé si aucune durée n'est configurée
+	defaultTokenCacheTTL = 5 * time.Minute
+	// defaultTokenCacheMaxEntries est utilisé si aucune taille n'est configurée
+	defaultTokenCacheMaxEntries = 10000
+)
+
+// Métriques du cache de validation, exposées sur /ops/metrics
+var (
+	tokenCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oauth2_token_cache_hits_total",
+		Help: "Number of token validations served from the cache.",
+	})
+	tokenCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oauth2_token_cache_misses_total",
+		Help: "Number of token validations not found in the cache.",
+	})
+	tokenCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oauth2_token_cache_evictions_total",
+		Help: "Number of cache entries evicted because the cache was full.",
+	})
+)
+
+// cacheMetrics sont les compteurs Prometheus d'un cache
+type cacheMetrics struct {
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	evictions prometheus.Counter
+}
+
+// tokenCacheMetrics sont les compteurs du cache de validation
+var tokenCacheMetrics = cacheMetrics{hits: tokenCacheHits, misses: tokenCacheMisses, evictions: tokenCacheEvictions}
+
+// lruEntry est une valeur mise en cache
+type lruEntry[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time
+}
+
+// lruCache est un cache LRU borné dont chaque entrée a sa propre expiration.
+// Les accès et les évictions sont comptés par ses métriques.
+type lruCache[V any] struct {
+	maxEntries int
+	metrics    cacheMetrics
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// newLRUCache crée un cache LRU de maxEntries entrées au plus
+func newLRUCache[V any](maxEntries int, metrics cacheMetrics) *lruCache[V] {
+	return &lruCache[V]{
+		maxEntries: maxEntries,
+		metrics:    metrics,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// get retourne la valeur en cache si elle existe et n'a pas expiré
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry[V])
+		if time.Now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(element)
+			c.metrics.hits.Inc()
+			return entry.value, true
+		}
+		c.removeElement(element)
+	}
+	c.metrics.misses.Inc()
+	var zero V
+	return zero, false
+}
+
+// set enregistre une valeur jusqu'à expiresAt ; quand le cache est plein,
+// les entrées les moins récemment utilisées sont retirées
+func (c *lruCache[V]) set(key string, value V, expiresAt time.Time) {
+	if !expiresAt.After(time.Now()) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry[V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&lruEntry[V]{key: key, value: value, expiresAt: expiresAt})
+	for c.lru.Len() > c.maxEntries {
+		c.removeElement(c.lru.Back())
+		c.metrics.evictions.Inc()
+	}
+}
+
+// invalidate retire les valeurs sélectionnées et retourne leur nombre
+func (c *lruCache[V]) invalidate(match func(value V) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for _, element := range c.entries {
+		if match(element.Value.(*lruEntry[V]).value) {
+			c.removeElement(element)
+			removed++
+		}
+	}
+	return removed
+}
+
+// removeElement retire une entrée du cache (verrou déjà acquis)
+func (c *lruCache[V]) removeElement(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*lruEntry[V]).key)
+}
+
+// tokenCache est un cache LRU borné des validations de tokens. Une entrée
+// expire au plus tôt entre le TTL configuré et l'expiration du token.
+type tokenCache struct {
+	ttl     time.Duration
+	entries *lruCache[*TokenInfo]
+}
+
+// newTokenCache crée un cache de validation
+func newTokenCache(ttl time.Duration, maxEntries int) *tokenCache {
+	if ttl <= 0 {
+		ttl = defaultTokenCacheTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = defaultTokenCacheMaxEntries
+	}
+	return &tokenCache{
+		ttl:     ttl,
+		entries: newLRUCache[*TokenInfo](maxEntries, tokenCacheMetrics),
+	}
+}
+
+// get retourne la validation en cache si elle existe et n'a pas expiré
+func (c *tokenCache) get(key string) (*TokenInfo, bool) {
+	return c.entries.get(key)
+}
+
+// set enregistre une validation réussie
+func (c *tokenCache) set(key string, tokenInfo *TokenInfo) {
+	expiresAt := time.Now().Add(c.ttl)
+	if tokenInfo.Expiration != 0 {
+		if tokenExpiry := time.Unix(tokenInfo.Expiration, 0); tokenExpiry.Before(expiresAt) {
+			expiresAt = tokenExpiry
+		}
+	}
+	c.entries.set(key, tokenInfo, expiresAt)
+}
+
+// invalidate retire les validations sélectionnées et retourne leur nombre
+func (c *tokenCache) invalidate(match func(tokenInfo *TokenInfo) bool) int {
+	return c.entries.invalidate(match)
+}
+
+// tokenCacheKey calcule la clé d'un token sans conserver le token lui-même.
+// La clé inclut le nom de la chaîne : deux chaînes différentes peuvent
+// produire des TokenInfo différents pour un même token.
+func tokenCacheKey(chain, tokenString string) string {
+	sum := sha256.Sum256([]byte(tokenString))
+	return chain + ":" + hex.EncodeToString(sum[:])
+}
+
+// cachedValidator met en cache les validations réussies d'une chaîne de validateurs
+type cachedValidator struct {
+	name  string
+	next  TokenValidator
+	cache *tokenCache
+}
+
+// Validate implémente TokenValidator
+func (v *cachedValidator) Validate(ctx context.Context, tokenString string) (*TokenInfo, error) {
+	key := tokenCacheKey(v.name, tokenString)
+	if tokenInfo, ok := v.cache.get(key); ok {
+		return tokenInfo, nil
+	}
+
+	tokenInfo, err := v.next.Validate(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	v.cache.set(key, tokenInfo)
+	return tokenInfo, nil
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// countingCounter compte les incréments d'une métrique de cache
+type countingCounter struct {
+	prometheus.Counter
+	count int
+}
+
+func (c *countingCounter) Inc() { c.count++ }
+
+// newCountingMetrics retourne des métriques de cache observables par le test
+func newCountingMetrics() (cacheMetrics, *countingCounter, *countingCounter, *countingCounter) {
+	hits, misses, evictions := &countingCounter{}, &countingCounter{}, &countingCounter{}
+	return cacheMetrics{hits: hits, misses: misses, evictions: evictions}, hits, misses, evictions
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	metrics, hits, misses, evictions := newCountingMetrics()
+	cache := newLRUCache[string](2, metrics)
+	expiresAt := time.Now().Add(time.Hour)
+
+	cache.set("a", "1", expiresAt)
+	cache.set("b", "2", expiresAt)
+	if value, ok := cache.get("a"); !ok || value != "1" {
+		t.Fatalf("get(a) = %q, %v", value, ok)
+	}
+	// b est désormais l'entrée la moins récemment utilisée
+	cache.set("c", "3", expiresAt)
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("b was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+	if hits.count != 3 || misses.count != 1 || evictions.count != 1 {
+		t.Errorf("hits %d, misses %d, evictions %d, want 3, 1, 1", hits.count, misses.count, evictions.count)
+	}
+}
+
+func TestLRUCacheExpiration(t *testing.T) {
+	metrics, _, misses, _ := newCountingMetrics()
+	cache := newLRUCache[string](10, metrics)
+
+	cache.set("expired", "1", time.Now().Add(-time.Second))
+	cache.set("short", "2", time.Now().Add(10*time.Millisecond))
+	cache.set("long", "3", time.Now().Add(time.Hour))
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := cache.get("expired"); ok {
+		t.Error("an already expired value was cached")
+	}
+	if _, ok := cache.get("short"); ok {
+		t.Error("an expired value was returned")
+	}
+	if _, ok := cache.get("long"); !ok {
+		t.Error("a valid value was not returned")
+	}
+	if misses.count != 2 || len(cache.entries) != 1 {
+		t.Errorf("misses %d, entries %d, want 2 and 1", misses.count, len(cache.entries))
+	}
+}
+
+func TestLRUCacheInvalidate(t *testing.T) {
+	metrics, _, _, _ := newCountingMetrics()
+	cache := newLRUCache[*TokenInfo](10, metrics)
+	expiresAt := time.Now().Add(time.Hour)
+	cache.set("alice-1", &TokenInfo{Sub: "alice"}, expiresAt)
+	cache.set("alice-2", &TokenInfo{Sub: "alice"}, expiresAt)
+	cache.set("bob", &TokenInfo{Sub: "bob"}, expiresAt)
+
+	if removed := cache.invalidate(func(tokenInfo *TokenInfo) bool { return tokenInfo.Sub == "alice" }); removed != 2 {
+		t.Errorf("invalidate removed %d entries, want 2", removed)
+	}
+	if _, ok := cache.get("bob"); !ok || cache.lru.Len() != 1 {
+		t.Errorf("cache holds %d entries, want only bob", cache.lru.Len())
+	}
+}
+
+func TestTokenCacheExpiresWithToken(t *testing.T) {
+	cache := newTokenCache(time.Hour, 10)
+	cache.set("expired", &TokenInfo{Sub: "alice", Expiration: time.Now().Add(-time.Minute).Unix()})
+	cache.set("valid", &TokenInfo{Sub: "bob", Expiration: time.Now().Add(time.Minute).Unix()})
+	if _, ok := cache.get("expired"); ok {
+		t.Error("an expired token was cached")
+	}
+	if _, ok := cache.get("valid"); !ok {
+		t.Error("a valid token was not cached")
+	}
+}
+
+func TestExtAuthzCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	var calls atomic.Int32
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode(extAuthzDecision{Allow: true})
+	}))
+	t.Cleanup(service.Close)
+
+	authz := newExtAuthzClient(config.ExtAuthz{URL: service.URL, CacheTTL: 60, CacheMaxEntries: 2})
+	for _, key := range []string{"a", "b", "a", "c", "a", "b"} {
+		if _, err := authz.check(t.Context(), key, extAuthzRequest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a, b puis c sont demandés au service ; c évince b, moins récemment
+	// utilisé que a, qui reste en cache, et b est redemandé
+	if n := calls.Load(); n != 4 {
+		t.Errorf("authorization service called %d times, want 4", n)
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// coalescingValidator regroupe les validations concurrentes d'un même token :
+// un seul appel amont est en vol et tous les appelants partagent son résultat
+// ou son erreur
+type coalescingValidator struct {
+	name  string
+	next  TokenValidator
+	group singleflight.Group
+}
+
+// Validate implémente TokenValidator
+func (v *coalescingValidator) Validate(ctx context.Context, tokenString string) (*TokenInfo, error) {
+	// L'appel partagé est détaché de l'annulation de l'appelant qui l'a
+	// déclenché : l'abandon d'une requête ne doit pas faire échouer les autres.
+	// Les clients HTTP des validateurs bornent sa durée.
+	results := v.group.DoChan(tokenCacheKey(v.name, tokenString), func() (any, error) {
+		return v.next.Validate(context.WithoutCancel(ctx), tokenString)
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*TokenInfo), nil
+	}
+}
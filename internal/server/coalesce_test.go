@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowValidator valide chaque token en delay et compte ses appels
+type slowValidator struct {
+	delay    time.Duration
+	err      error
+	calls    atomic.Int32
+	canceled atomic.Bool
+}
+
+// Validate implémente TokenValidator ; le sujet du TokenInfo est le token
+func (v *slowValidator) Validate(ctx context.Context, tokenString string) (*TokenInfo, error) {
+	v.calls.Add(1)
+	select {
+	case <-time.After(v.delay):
+	case <-ctx.Done():
+		v.canceled.Store(true)
+		return nil, ctx.Err()
+	}
+	if v.err != nil {
+		return nil, v.err
+	}
+	return &TokenInfo{Sub: tokenString}, nil
+}
+
+// validateConcurrently valide chaque token depuis plusieurs goroutines et
+// retourne les erreurs obtenues
+func validateConcurrently(t *testing.T, validator TokenValidator, tokens ...string) []error {
+	t.Helper()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for range 10 {
+		for _, token := range tokens {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				info, err := validator.Validate(t.Context(), token)
+				if err == nil && info.Sub != token {
+					err = errors.New("token " + token + " validated as " + info.Sub)
+				}
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestCoalescingValidator(t *testing.T) {
+	errUpstream := errors.New("introspection endpoint returned status: 503")
+	tests := []struct {
+		name      string
+		tokens    []string
+		err       error
+		wantCalls int32
+	}{
+		{name: "same token", tokens: []string{"alice"}, wantCalls: 1},
+		{name: "different tokens", tokens: []string{"alice", "bob"}, wantCalls: 2},
+		{name: "shared error", tokens: []string{"alice"}, err: errUpstream, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &slowValidator{delay: 50 * time.Millisecond, err: tt.err}
+			validator := &coalescingValidator{name: "test", next: next}
+			for _, err := range validateConcurrently(t, validator, tt.tokens...) {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("Validate() error = %v, want %v", err, tt.err)
+				}
+			}
+			if calls := next.calls.Load(); calls != tt.wantCalls {
+				t.Errorf("%d upstream calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// Le regroupement ne vaut que pour les appels en vol : ce n'est pas un cache
+func TestCoalescingValidatorDoesNotCache(t *testing.T) {
+	next := &slowValidator{}
+	validator := &coalescingValidator{name: "test", next: next}
+	for range 3 {
+		if _, err := validator.Validate(t.Context(), "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := next.calls.Load(); calls != 3 {
+		t.Errorf("%d upstream calls, want 3", calls)
+	}
+}
+
+// L'appelant qui abandonne sa requête n'annule pas l'appel partagé
+func TestCoalescingValidatorSurvivesCanceledCaller(t *testing.T) {
+	next := &slowValidator{delay: 50 * time.Millisecond}
+	validator := &coalescingValidator{name: "test", next: next}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := validator.Validate(ctx, "alice")
+		canceled <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller error = %v, want %v", err, context.Canceled)
+	}
+
+	info, err := validator.Validate(t.Context(), "alice")
+	if err != nil || info.Sub != "alice" {
+		t.Fatalf("Validate() = %+v, %v", info, err)
+	}
+	if next.canceled.Load() {
+		t.Error("the shared call was canceled with its caller")
+	}
+	if calls := next.calls.Load(); calls != 1 {
+		t.Errorf("%d upstream calls, want 1", calls)
+	}
+}
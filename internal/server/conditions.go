@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// Correspondance de l'hôte de la requête avec ceux d'une route, de la moins
+// précise à la plus précise
+const (
+	hostMismatch = iota - 1
+	hostAny
+	hostWildcard
+	hostExact
+)
+
+// valueMatcher est une condition compilée sur un header ou un paramètre de query
+type valueMatcher struct {
+	name  string
+	value string
+	regex *regexp.Regexp
+}
+
+// compileValueMatchers vérifie au démarrage les conditions d'une route
+func compileValueMatchers(kind string, matchers []config.ValueMatcher, canonical func(string) string) ([]valueMatcher, error) {
+	compiled := make([]valueMatcher, 0, len(matchers))
+	for _, matcher := range matchers {
+		if matcher.Name == "" {
+			return nil, fmt.Errorf("%s matcher requires a name", kind)
+		}
+		if matcher.Value != "" && matcher.Regex != "" {
+			return nil, fmt.Errorf("%s matcher %q has both a value and a regex", kind, matcher.Name)
+		}
+		m := valueMatcher{name: canonical(matcher.Name), value: matcher.Value}
+		if matcher.Regex != "" {
+			regex, err := regexp.Compile(matcher.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex for %s %q: %w", kind, matcher.Name, err)
+			}
+			m.regex = regex
+		}
+		compiled = append(compiled, m)
+	}
+	return compiled, nil
+}
+
+// matches indique si l'une des valeurs reçues satisfait la condition
+func (m valueMatcher) matches(values []string) bool {
+	for _, value := range values {
+		switch {
+		case m.regex != nil:
+			if m.regex.MatchString(value) {
+				return true
+			}
+		case m.value != "":
+			if value == m.value {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// compileConditions vérifie au démarrage les hôtes et les conditions sur les
+// headers et la query string de la route
+func (r *compiledRoute) compileConditions() error {
+	for _, host := range r.cfg.Hosts {
+		host = strings.ToLower(host)
+		if name := strings.TrimPrefix(host, "*."); name == "" || strings.ContainsAny(name, "*/:") {
+			return fmt.Errorf("invalid host %q, expected a host name or *.domain", host)
+		}
+		r.hosts = append(r.hosts, host)
+	}
+	var err error
+	if r.headers, err = compileValueMatchers("header", r.cfg.Headers, http.CanonicalHeaderKey); err != nil {
+		return err
+	}
+	if r.query, err = compileValueMatchers("query", r.cfg.Query, func(name string) string { return name }); err != nil {
+		return err
+	}
+	return nil
+}
+
+// hostRank indique comment l'hôte de la requête correspond à ceux de la route
+func (r *compiledRoute) hostRank(host string) int {
+	if len(r.hosts) == 0 {
+		return hostAny
+	}
+	rank := hostMismatch
+	for _, pattern := range r.hosts {
+		if pattern == host {
+			return hostExact
+		}
+		if domain, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasSuffix(host, domain) {
+			rank = hostWildcard
+		}
+	}
+	return rank
+}
+
+// acceptsRequest indique si la requête porte les headers et les paramètres
+// de query exigés par la route
+func (r *compiledRoute) acceptsRequest(req *http.Request, query url.Values) bool {
+	for _, matcher := range r.headers {
+		if !matcher.matches(req.Header.Values(matcher.name)) {
+			return false
+		}
+	}
+	for _, matcher := range r.query {
+		if !matcher.matches(query[matcher.name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// conditions retourne le nombre de conditions sur les headers et la query string
+func (r *compiledRoute) conditions() int {
+	return len(r.headers) + len(r.query)
+}
+
+// requestHost retourne l'hôte de la requête, sans port et en minuscules
+func requestHost(req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
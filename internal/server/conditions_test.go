@@ -0,0 +1,200 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestCompileConditions(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   config.Route
+		wantErr bool
+	}{
+		{name: "no conditions", route: config.Route{}},
+		{name: "hosts", route: config.Route{Hosts: []string{"API.example.com", "*.example.com"}}},
+		{name: "header and query", route: config.Route{
+			Headers: []config.ValueMatcher{{Name: "x-version", Value: "2"}},
+			Query:   []config.ValueMatcher{{Name: "beta", Regex: "^(1|true)$"}},
+		}},
+		{name: "empty host", route: config.Route{Hosts: []string{""}}, wantErr: true},
+		{name: "bare wildcard", route: config.Route{Hosts: []string{"*."}}, wantErr: true},
+		{name: "inner wildcard", route: config.Route{Hosts: []string{"api.*.example.com"}}, wantErr: true},
+		{name: "host with port", route: config.Route{Hosts: []string{"example.com:8080"}}, wantErr: true},
+		{name: "host with path", route: config.Route{Hosts: []string{"example.com/api"}}, wantErr: true},
+		{name: "header without name", route: config.Route{Headers: []config.ValueMatcher{{Value: "2"}}}, wantErr: true},
+		{name: "value and regex", route: config.Route{Query: []config.ValueMatcher{{Name: "beta", Value: "1", Regex: "1"}}}, wantErr: true},
+		{name: "invalid regex", route: config.Route{Headers: []config.ValueMatcher{{Name: "X-Version", Regex: "("}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &compiledRoute{cfg: tt.route}
+			if err := r.compileConditions(); (err != nil) != tt.wantErr {
+				t.Errorf("compileConditions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHostRank(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []string
+		host  string
+		want  int
+	}{
+		{name: "any host", host: "api.example.com", want: hostAny},
+		{name: "exact", hosts: []string{"api.example.com"}, host: "api.example.com", want: hostExact},
+		{name: "case insensitive", hosts: []string{"API.Example.com"}, host: "api.example.com", want: hostExact},
+		{name: "other host", hosts: []string{"api.example.com"}, host: "www.example.com", want: hostMismatch},
+		{name: "wildcard", hosts: []string{"*.example.com"}, host: "api.example.com", want: hostWildcard},
+		{name: "nested subdomain", hosts: []string{"*.example.com"}, host: "v2.api.example.com", want: hostWildcard},
+		{name: "wildcard excludes the domain", hosts: []string{"*.example.com"}, host: "example.com", want: hostMismatch},
+		{name: "wildcard suffix only on a label", hosts: []string{"*.example.com"}, host: "api.badexample.com", want: hostMismatch},
+		{name: "exact over wildcard", hosts: []string{"*.example.com", "api.example.com"}, host: "api.example.com", want: hostExact},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &compiledRoute{cfg: config.Route{Hosts: tt.hosts}}
+			if err := r.compileConditions(); err != nil {
+				t.Fatal(err)
+			}
+			if got := r.hostRank(tt.host); got != tt.want {
+				t.Errorf("hostRank(%q) = %d, want %d", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "api.example.com", want: "api.example.com"},
+		{host: "API.Example.com:8443", want: "api.example.com"},
+		{host: "api.example.com.", want: "api.example.com"},
+		{host: "[::1]:8080", want: "::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tt.host
+			if got := requestHost(req); got != tt.want {
+				t.Errorf("requestHost() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcceptsRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []config.ValueMatcher
+		query   []config.ValueMatcher
+		target  string
+		header  http.Header
+		want    bool
+	}{
+		{name: "no conditions", target: "/api", want: true},
+		{name: "header value", headers: []config.ValueMatcher{{Name: "x-version", Value: "2"}}, target: "/api",
+			header: http.Header{"X-Version": {"2"}}, want: true},
+		{name: "other header value", headers: []config.ValueMatcher{{Name: "X-Version", Value: "2"}}, target: "/api",
+			header: http.Header{"X-Version": {"1"}}},
+		{name: "one of several header values", headers: []config.ValueMatcher{{Name: "X-Version", Value: "2"}}, target: "/api",
+			header: http.Header{"X-Version": {"1", "2"}}, want: true},
+		{name: "missing header", headers: []config.ValueMatcher{{Name: "X-Version", Value: "2"}}, target: "/api"},
+		{name: "header presence", headers: []config.ValueMatcher{{Name: "X-Debug"}}, target: "/api",
+			header: http.Header{"X-Debug": {""}}, want: true},
+		{name: "missing header for presence", headers: []config.ValueMatcher{{Name: "X-Debug"}}, target: "/api"},
+		{name: "header regex", headers: []config.ValueMatcher{{Name: "User-Agent", Regex: "^Mobile/"}}, target: "/api",
+			header: http.Header{"User-Agent": {"Mobile/3.1"}}, want: true},
+		{name: "header regex mismatch", headers: []config.ValueMatcher{{Name: "User-Agent", Regex: "^Mobile/"}}, target: "/api",
+			header: http.Header{"User-Agent": {"Desktop Mobile/3.1"}}},
+		{name: "query value", query: []config.ValueMatcher{{Name: "beta", Value: "1"}}, target: "/api?beta=1", want: true},
+		{name: "query name is case sensitive", query: []config.ValueMatcher{{Name: "beta", Value: "1"}}, target: "/api?Beta=1"},
+		{name: "query presence", query: []config.ValueMatcher{{Name: "beta"}}, target: "/api?beta", want: true},
+		{name: "missing query", query: []config.ValueMatcher{{Name: "beta"}}, target: "/api?alpha=1"},
+		{name: "query regex", query: []config.ValueMatcher{{Name: "v", Regex: `^\d+$`}}, target: "/api?v=x&v=42", want: true},
+		{name: "every condition", headers: []config.ValueMatcher{{Name: "X-Version", Value: "2"}},
+			query: []config.ValueMatcher{{Name: "beta"}}, target: "/api?beta=1", header: http.Header{"X-Version": {"2"}}, want: true},
+		{name: "one condition missing", headers: []config.ValueMatcher{{Name: "X-Version", Value: "2"}},
+			query: []config.ValueMatcher{{Name: "beta"}}, target: "/api", header: http.Header{"X-Version": {"2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &compiledRoute{cfg: config.Route{Headers: tt.headers, Query: tt.query}}
+			if err := r.compileConditions(); err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header = tt.header
+			if req.Header == nil {
+				req.Header = http.Header{}
+			}
+			if got := r.acceptsRequest(req, req.URL.Query()); got != tt.want {
+				t.Errorf("acceptsRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Des routes de même chemin ne différant que par l'hôte ou un header
+func TestConditionalRoutes(t *testing.T) {
+	backend, received := newTestBackend(t)
+	issuer := newTestIssuer(t)
+	_, gateway := newTestGateway(t, testConfig(issuer,
+		config.Route{Path: "/api", Target: backend.URL + "/default"},
+		config.Route{Path: "/api", Target: backend.URL + "/wildcard", Hosts: []string{"*.example.com"}},
+		config.Route{Path: "/api", Target: backend.URL + "/exact", Hosts: []string{"api.example.com"}},
+		config.Route{Path: "/api", Target: backend.URL + "/v2", Hosts: []string{"api.example.com"},
+			Headers: []config.ValueMatcher{{Name: "X-Version", Value: "2"}}},
+		config.Route{Path: "/api", Target: backend.URL + "/beta", Query: []config.ValueMatcher{{Name: "beta", Value: "1"}}},
+	))
+
+	tests := []struct {
+		name   string
+		host   string
+		target string
+		header http.Header
+		want   string
+	}{
+		{name: "any host", host: "localhost", target: "/api/items", want: "/default/items"},
+		{name: "wildcard host", host: "www.example.com", target: "/api/items", want: "/wildcard/items"},
+		{name: "exact host over wildcard", host: "API.example.com:443", target: "/api/items", want: "/exact/items"},
+		{name: "header on the exact host", host: "api.example.com", target: "/api/items", header: http.Header{"X-Version": {"2"}}, want: "/v2/items"},
+		{name: "header on another host", host: "www.example.com", target: "/api/items", header: http.Header{"X-Version": {"2"}}, want: "/wildcard/items"},
+		{name: "query", host: "localhost", target: "/api/items?beta=1", want: "/beta/items?beta=1"},
+		{name: "host over query", host: "www.example.com", target: "/api/items?beta=1", want: "/wildcard/items?beta=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, gateway.URL+tt.target, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = tt.host
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			resp, err := gateway.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d", resp.StatusCode)
+			}
+			request, ok := lastRequest(received)
+			if !ok {
+				t.Fatal("backend not reached")
+			}
+			if request.uri != tt.want {
+				t.Errorf("forwarded as %s, want %s", request.uri, tt.want)
+			}
+		})
+	}
+}
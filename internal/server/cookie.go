@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// errInvalidCookie indique un cookie altéré, chiffré avec une autre clé ou illisible
+var errInvalidCookie = errors.New("invalid cookie")
+
+// cookieCodec chiffre et authentifie le contenu des cookies (AES-256-GCM) ;
+// le nom du cookie est authentifié avec son contenu pour qu'une valeur ne
+// puisse pas être rejouée dans un autre cookie
+type cookieCodec struct {
+	aead cipher.AEAD
+}
+
+// newCookieCodec crée un codec à partir d'une clé de 32 octets encodée en
+// base64, ou d'une clé aléatoire si aucune n'est fournie
+func newCookieCodec(secret string) (*cookieCodec, error) {
+	key := make([]byte, 32)
+	if secret == "" {
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate cookie key: %w", err)
+		}
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie secret: %w", err)
+		}
+		if len(decoded) != 32 {
+			return nil, fmt.Errorf("cookie secret must be 32 bytes, got %d", len(decoded))
+		}
+		key = decoded
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cookieCodec{aead: aead}, nil
+}
+
+// encode chiffre la valeur JSON d'un cookie
+func (c *cookieCodec) encode(name string, value any) (string, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cookie %s: %w", name, err)
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate cookie nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decode déchiffre et vérifie la valeur d'un cookie
+func (c *cookieCodec) decode(name, cookie string, value any) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return errInvalidCookie
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return errInvalidCookie
+	}
+	if err := json.Unmarshal(plaintext, value); err != nil {
+		return errInvalidCookie
+	}
+	return nil
+}
+
+// removeCookie retire un cookie de l'en-tête Cookie d'une requête ; l'en-tête
+// n'est pas réécrit si le cookie est absent
+func removeCookie(req *http.Request, name string) {
+	if _, err := req.Cookie(name); err != nil {
+		return
+	}
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	var kept []string
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			kept = append(kept, cookie.Name+"="+cookie.Value)
+		}
+	}
+	if len(kept) > 0 {
+		req.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
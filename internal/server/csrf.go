@@ -0,0 +1,166 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+const (
+	// defaultCSRFCookie porte le token CSRF, lisible par le JavaScript de la page
+	defaultCSRFCookie = "csrf_token"
+	// defaultCSRFHeader doit répéter le token sur les requêtes qui modifient l'état
+	defaultCSRFHeader = "X-CSRF-Token"
+	// anyCookie traite tout cookie comme un identifiant de session
+	anyCookie = "*"
+)
+
+// csrfProtection protège les requêtes authentifiées par cookie : token
+// double-submit (cookie répété dans un header) et vérification de Origin/Referer
+type csrfProtection struct {
+	cookieName     string
+	headerName     string
+	trustedOrigins []string
+	sessionCookies []string
+	// exemptPaths ne sont pas vérifiés : requêtes serveur à serveur qui ne
+	// portent aucun cookie du navigateur
+	exemptPaths []string
+	secure      bool
+}
+
+// newCSRFProtection prépare la protection CSRF configurée ; exemptPaths
+// échappent à toute vérification
+func newCSRFProtection(cfg config.CSRF, secure bool, exemptPaths ...string) (*csrfProtection, error) {
+	p := &csrfProtection{
+		cookieName:     cfg.CookieName,
+		headerName:     cfg.HeaderName,
+		sessionCookies: cfg.SessionCookies,
+		exemptPaths:    exemptPaths,
+		secure:         secure,
+	}
+	if p.cookieName == "" {
+		p.cookieName = defaultCSRFCookie
+	}
+	if p.headerName == "" {
+		p.headerName = defaultCSRFHeader
+	}
+	for _, origin := range cfg.TrustedOrigins {
+		originURL, err := url.Parse(origin)
+		if err != nil || originURL.Scheme == "" || originURL.Host == "" || strings.TrimSuffix(originURL.Path, "/") != "" {
+			return nil, fmt.Errorf("csrf: invalid trusted origin %q, expected scheme://host[:port]", origin)
+		}
+		p.trustedOrigins = append(p.trustedOrigins, strings.ToLower(originURL.Scheme+"://"+originURL.Host))
+	}
+	return p, nil
+}
+
+// middleware dépose le cookie du token puis vérifie les requêtes authentifiées
+// par cookie qui modifient l'état ; les requêtes Bearer ne sont pas concernées
+func (p *csrfProtection) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if slices.Contains(p.exemptPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		if token, err := c.Cookie(p.cookieName); err != nil || token == "" {
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie(p.cookieName, rand.Text(), 0, "/", "", p.secure || c.Request.TLS != nil, false)
+		}
+
+		if safeMethod(c.Request.Method) || !p.cookieAuthenticated(c) {
+			c.Next()
+			return
+		}
+
+		if err := p.checkOrigin(c); err != nil {
+			log.Printf("Requête refusée (CSRF): %v", err)
+			csrfError(c, "Origine de la requête non autorisée")
+			return
+		}
+
+		token, _ := c.Cookie(p.cookieName)
+		header := c.GetHeader(p.headerName)
+		if token == "" || header == "" || subtle.ConstantTimeCompare([]byte(token), []byte(header)) != 1 {
+			log.Printf("Requête refusée (CSRF): token absent ou invalide sur %s %s", c.Request.Method, c.Request.URL.Path)
+			csrfError(c, "Token CSRF invalide ou manquant")
+			return
+		}
+		c.Next()
+	}
+}
+
+// cookieAuthenticated indique si la requête est authentifiée par un cookie :
+// session navigateur du proxy ou cookie de session d'un backend
+func (p *csrfProtection) cookieAuthenticated(c *gin.Context) bool {
+	if c.GetHeader("Authorization") != "" {
+		return false
+	}
+	if _, err := c.Request.Cookie(sessionCookie); err == nil {
+		return true
+	}
+	for _, cookie := range c.Request.Cookies() {
+		if cookie.Name == p.cookieName {
+			continue
+		}
+		if slices.Contains(p.sessionCookies, anyCookie) || slices.Contains(p.sessionCookies, cookie.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin vérifie que la requête provient du proxy lui-même ou d'une
+// origine de confiance, d'après Origin ou à défaut Referer. Sans aucun des
+// deux headers, seul le token est vérifié.
+func (p *csrfProtection) checkOrigin(c *gin.Context) error {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		referer := c.GetHeader("Referer")
+		if referer == "" {
+			return nil
+		}
+		refererURL, err := url.Parse(referer)
+		if err != nil || refererURL.Host == "" {
+			return fmt.Errorf("referer %q invalide", referer)
+		}
+		origin = refererURL.Scheme + "://" + refererURL.Host
+	}
+
+	originURL, err := url.Parse(origin)
+	if origin == "null" || err != nil || originURL.Host == "" {
+		return fmt.Errorf("origine %q invalide", origin)
+	}
+	if strings.EqualFold(originURL.Host, c.Request.Host) {
+		return nil
+	}
+	if slices.Contains(p.trustedOrigins, strings.ToLower(originURL.Scheme+"://"+originURL.Host)) {
+		return nil
+	}
+	return fmt.Errorf("origine %q non autorisée", origin)
+}
+
+// safeMethod indique si la méthode ne modifie pas l'état (RFC 9110 §9.2.1)
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// csrfError refuse une requête suspecte de CSRF
+func csrfError(c *gin.Context, message string) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "Forbidden",
+		"message": message,
+	})
+	c.Abort()
+}
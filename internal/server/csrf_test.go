@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestCSRFProtectsLoginRoutes(t *testing.T) {
+	issuer := newTestIssuer(t)
+	cfg := loginConfig(testConfig(issuer), "http://issuer.test/token")
+	cfg.Server.CSRF.Enabled = true
+	s, gateway := newTestGateway(t, cfg)
+
+	tests := []struct {
+		name          string
+		header        http.Header
+		wantLogout    bool
+		wantForbidden bool
+	}{
+		{name: "without token", wantForbidden: true},
+		{name: "cross site origin", header: http.Header{
+			"Origin":       {"https://evil.example"},
+			"X-Csrf-Token": {"token"},
+		}, wantForbidden: true},
+		{name: "token mismatch", header: http.Header{"X-Csrf-Token": {"other"}}, wantForbidden: true},
+		{name: "valid token", header: http.Header{"X-Csrf-Token": {"token"}}, wantLogout: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := newTestSession(t, s, &Session{ID: "session-" + tt.name, Subject: "alice"})
+			header := http.Header{"Cookie": {session.String() + "; csrf_token=token"}}
+			for name, values := range tt.header {
+				header[name] = values
+			}
+			resp := doRequest(t, gateway, http.MethodPost, "/logout", header)
+			if forbidden := resp.StatusCode == http.StatusForbidden; forbidden != tt.wantForbidden {
+				t.Errorf("POST /logout: status %d, want forbidden %v", resp.StatusCode, tt.wantForbidden)
+			}
+			_, err := s.login.sessions.Get(t.Context(), "session-"+tt.name)
+			if loggedOut := err != nil; loggedOut != tt.wantLogout {
+				t.Errorf("session deleted = %v, want %v", loggedOut, tt.wantLogout)
+			}
+		})
+	}
+}
+
+func TestCSRFExemptsBackchannelLogout(t *testing.T) {
+	issuer := newTestIssuer(t)
+	cfg := loginConfig(testConfig(issuer,
+		config.Route{Path: "/api", Target: "http://backend.invalid", Auth: authNone},
+	), "http://issuer.test/token")
+	cfg.Server.CSRF.Enabled = true
+	cfg.Server.CSRF.SessionCookies = []string{anyCookie}
+	_, gateway := newTestGateway(t, cfg)
+
+	// Le fournisseur poste le logout token sans token CSRF : la requête
+	// atteint le handler, qui refuse le token invalide
+	header := http.Header{
+		"Content-Type": {"application/x-www-form-urlencoded"},
+		"Cookie":       {"backend_session=1"},
+		"Origin":       {"https://issuer.example"},
+	}
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, gateway.URL+defaultBackchannelLogoutPath,
+		strings.NewReader(url.Values{"logout_token": {"invalid"}}.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	resp, err := gateway.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("back-channel logout: status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	// Les autres chemins restent protégés
+	if resp := doRequest(t, gateway, http.MethodPost, "/api", header); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("POST /api: status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// defaultDiscoveryRefreshInterval est utilisé si aucun intervalle n'est configuré
+const defaultDiscoveryRefreshInterval = time.Hour
+
+// providerMetadata représente le document /.well-known/openid-configuration
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	IntrospectionEndpoint string `json:"introspection_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// oidcDiscovery récupère et rafraîchit la configuration OpenID du fournisseur
+type oidcDiscovery struct {
+	issuer          string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	mu        sync.RWMutex
+	endpoints config.OAuth2Endpoints
+}
+
+// newOIDCDiscovery crée un client de découverte pour l'issuer donné
+func newOIDCDiscovery(issuer string, refreshInterval time.Duration) *oidcDiscovery {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultDiscoveryRefreshInterval
+	}
+	return &oidcDiscovery{
+		issuer:          strings.TrimSuffix(issuer, "/"),
+		refreshInterval: refreshInterval,
+		client:          &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// start effectue la découverte initiale puis la rafraîchit périodiquement
+func (d *oidcDiscovery) start(ctx context.Context) error {
+	if err := d.refresh(ctx); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(d.refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.refresh(ctx); err != nil {
+					log.Printf("Erreur de rafraîchissement de la découverte OIDC: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// refresh télécharge le document de découverte et met à jour les endpoints
+func (d *oidcDiscovery) refresh(ctx context.Context) error {
+	wellKnown := d.issuer + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create discovery request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("discovery endpoint returned status: %d", resp.StatusCode)
+	}
+
+	var metadata providerMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return fmt.Errorf("failed to decode discovery document: %w", err)
+	}
+
+	// L'issuer annoncé doit correspondre exactement à l'issuer configuré (OIDC Discovery §4.3)
+	if strings.TrimSuffix(metadata.Issuer, "/") != d.issuer {
+		return fmt.Errorf("discovery issuer mismatch: expected %q, got %q", d.issuer, metadata.Issuer)
+	}
+
+	d.mu.Lock()
+	d.endpoints = config.OAuth2Endpoints{
+		AuthURL:          metadata.AuthorizationEndpoint,
+		TokenURL:         metadata.TokenEndpoint,
+		UserInfoURL:      metadata.UserinfoEndpoint,
+		IntrospectionURL: metadata.IntrospectionEndpoint,
+		JWKSURL:          metadata.JWKSURI,
+		EndSessionURL:    metadata.EndSessionEndpoint,
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+// discovered retourne les derniers endpoints découverts
+func (d *oidcDiscovery) discovered() config.OAuth2Endpoints {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.endpoints
+}
+
+// mergeEndpoints complète les endpoints explicites avec les valeurs découvertes
+func mergeEndpoints(explicit, discovered config.OAuth2Endpoints) config.OAuth2Endpoints {
+	merged := explicit
+	fallback := func(value *string, discoveredValue string) {
+		if *value == "" {
+			*value = discoveredValue
+		}
+	}
+	fallback(&merged.AuthURL, discovered.AuthURL)
+	fallback(&merged.TokenURL, discovered.TokenURL)
+	fallback(&merged.TokenInfoURL, discovered.TokenInfoURL)
+	fallback(&merged.UserInfoURL, discovered.UserInfoURL)
+	fallback(&merged.IntrospectionURL, discovered.IntrospectionURL)
+	fallback(&merged.JWKSURL, discovered.JWKSURL)
+	fallback(&merged.EndSessionURL, discovered.EndSessionURL)
+	return merged
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// discoveryServer publie un document de découverte OIDC modifiable
+type discoveryServer struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	metadata map[string]string
+}
+
+// newDiscoveryServer démarre un fournisseur dont l'issuer est l'URL du serveur
+func newDiscoveryServer(t *testing.T) *discoveryServer {
+	t.Helper()
+	d := &discoveryServer{status: http.StatusOK}
+	d.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(d.status)
+		json.NewEncoder(w).Encode(d.metadata)
+	}))
+	t.Cleanup(d.server.Close)
+	d.set(http.StatusOK, map[string]string{"issuer": d.server.URL})
+	return d
+}
+
+// set remplace la réponse du serveur
+func (d *discoveryServer) set(status int, metadata map[string]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status = status
+	d.metadata = metadata
+}
+
+func TestOIDCDiscoveryRefresh(t *testing.T) {
+	d := newDiscoveryServer(t)
+	tests := []struct {
+		name     string
+		issuer   string
+		status   int
+		metadata map[string]string
+		wantErr  bool
+	}{
+		{name: "document", issuer: d.server.URL, status: http.StatusOK, metadata: map[string]string{
+			"issuer":                 d.server.URL,
+			"authorization_endpoint": d.server.URL + "/auth",
+			"token_endpoint":         d.server.URL + "/token",
+			"userinfo_endpoint":      d.server.URL + "/userinfo",
+			"introspection_endpoint": d.server.URL + "/introspect",
+			"jwks_uri":               d.server.URL + "/certs",
+			"end_session_endpoint":   d.server.URL + "/logout",
+		}},
+		{name: "configured issuer with trailing slash", issuer: d.server.URL + "/", status: http.StatusOK,
+			metadata: map[string]string{"issuer": d.server.URL, "jwks_uri": d.server.URL + "/certs"}},
+		{name: "announced issuer with trailing slash", issuer: d.server.URL, status: http.StatusOK,
+			metadata: map[string]string{"issuer": d.server.URL + "/", "jwks_uri": d.server.URL + "/certs"}},
+		{name: "issuer mismatch", issuer: d.server.URL, status: http.StatusOK,
+			metadata: map[string]string{"issuer": "https://evil.example", "jwks_uri": "https://evil.example/certs"}, wantErr: true},
+		{name: "missing issuer", issuer: d.server.URL, status: http.StatusOK,
+			metadata: map[string]string{"jwks_uri": d.server.URL + "/certs"}, wantErr: true},
+		{name: "error status", issuer: d.server.URL, status: http.StatusInternalServerError, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d.set(tt.status, tt.metadata)
+			discovery := newOIDCDiscovery(tt.issuer, 0)
+			err := discovery.refresh(t.Context())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("refresh() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := discovery.discovered()
+			if tt.wantErr {
+				if got != (config.OAuth2Endpoints{}) {
+					t.Errorf("discovered() = %+v after a failed refresh", got)
+				}
+				return
+			}
+			want := config.OAuth2Endpoints{
+				AuthURL:          tt.metadata["authorization_endpoint"],
+				TokenURL:         tt.metadata["token_endpoint"],
+				UserInfoURL:      tt.metadata["userinfo_endpoint"],
+				IntrospectionURL: tt.metadata["introspection_endpoint"],
+				JWKSURL:          tt.metadata["jwks_uri"],
+				EndSessionURL:    tt.metadata["end_session_endpoint"],
+			}
+			if got != want {
+				t.Errorf("discovered() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// Un rafraîchissement en échec conserve les derniers endpoints découverts
+func TestOIDCDiscoveryKeepsEndpointsOnFailure(t *testing.T) {
+	d := newDiscoveryServer(t)
+	discovery := newOIDCDiscovery(d.server.URL, 0)
+	d.set(http.StatusOK, map[string]string{"issuer": d.server.URL, "jwks_uri": d.server.URL + "/certs"})
+	if err := discovery.refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	d.set(http.StatusOK, map[string]string{"issuer": d.server.URL, "jwks_uri": d.server.URL + "/rotated"})
+	if err := discovery.refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if got := discovery.discovered().JWKSURL; got != d.server.URL+"/rotated" {
+		t.Fatalf("JWKSURL = %q after refresh", got)
+	}
+
+	for _, failure := range []struct {
+		status   int
+		metadata map[string]string
+	}{
+		{status: http.StatusServiceUnavailable},
+		{status: http.StatusOK, metadata: map[string]string{"issuer": "https://evil.example", "jwks_uri": "https://evil.example/certs"}},
+	} {
+		d.set(failure.status, failure.metadata)
+		if err := discovery.refresh(t.Context()); err == nil {
+			t.Fatalf("refresh() succeeded with status %d and %v", failure.status, failure.metadata)
+		}
+		if got := discovery.discovered().JWKSURL; got != d.server.URL+"/rotated" {
+			t.Errorf("JWKSURL = %q after a failed refresh", got)
+		}
+	}
+}
+
+func TestMergeEndpoints(t *testing.T) {
+	discovered := config.OAuth2Endpoints{
+		AuthURL:          "https://idp/auth",
+		TokenURL:         "https://idp/token",
+		UserInfoURL:      "https://idp/userinfo",
+		IntrospectionURL: "https://idp/introspect",
+		JWKSURL:          "https://idp/certs",
+		EndSessionURL:    "https://idp/logout",
+	}
+	tests := []struct {
+		name       string
+		explicit   config.OAuth2Endpoints
+		discovered config.OAuth2Endpoints
+		want       config.OAuth2Endpoints
+	}{
+		{name: "discovered only", discovered: discovered, want: discovered},
+		{name: "explicit only", explicit: discovered, want: discovered},
+		{
+			name:       "explicit values win",
+			explicit:   config.OAuth2Endpoints{JWKSURL: "https://internal/certs", TokenInfoURL: "https://internal/tokeninfo"},
+			discovered: discovered,
+			want: config.OAuth2Endpoints{
+				AuthURL:          "https://idp/auth",
+				TokenURL:         "https://idp/token",
+				TokenInfoURL:     "https://internal/tokeninfo",
+				UserInfoURL:      "https://idp/userinfo",
+				IntrospectionURL: "https://idp/introspect",
+				JWKSURL:          "https://internal/certs",
+				EndSessionURL:    "https://idp/logout",
+			},
+		},
+		{
+			name:       "missing in both",
+			explicit:   config.OAuth2Endpoints{TokenURL: "https://internal/token"},
+			discovered: config.OAuth2Endpoints{JWKSURL: "https://idp/certs"},
+			want:       config.OAuth2Endpoints{TokenURL: "https://internal/token", JWKSURL: "https://idp/certs"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeEndpoints(tt.explicit, tt.discovered); got != tt.want {
+				t.Errorf("mergeEndpoints() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Le fournisseur valide les tokens avec le JWKS annoncé par la découverte,
+// sauf si un endpoint explicite le remplace
+func TestDiscoveredProvider(t *testing.T) {
+	issuer := newTestIssuer(t)
+	d := newDiscoveryServer(t)
+	backend, _ := newTestBackend(t)
+
+	tests := []struct {
+		name     string
+		jwksURI  string
+		explicit string
+		iss      string
+		want     int
+	}{
+		{name: "discovered JWKS", jwksURI: issuer.server.URL + "/jwks", iss: d.server.URL, want: http.StatusOK},
+		{name: "explicit JWKS over discovered", jwksURI: d.server.URL + "/missing", explicit: issuer.server.URL + "/jwks", iss: d.server.URL, want: http.StatusOK},
+		{name: "token from another issuer", jwksURI: issuer.server.URL + "/jwks", iss: "https://other.example", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d.set(http.StatusOK, map[string]string{"issuer": d.server.URL, "jwks_uri": tt.jwksURI})
+			cfg := testConfig(issuer, config.Route{Path: "/api", Target: backend.URL, Auth: authRequired})
+			cfg.Server.OAuth2.Provider = config.Provider{
+				Issuer:     d.server.URL,
+				ClientID:   "gateway",
+				Endpoints:  config.OAuth2Endpoints{JWKSURL: tt.explicit},
+				Validators: []string{validatorJWKS},
+			}
+			_, gateway := newTestGateway(t, cfg)
+			token := issuer.token(t, "alice", map[string]any{"iss": tt.iss})
+			if resp := doRequest(t, gateway, http.MethodGet, "/api", bearer(token)); resp.StatusCode != tt.want {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+// La passerelle ne démarre pas si la découverte échoue
+func TestDiscoveryFailureStopsStartup(t *testing.T) {
+	d := newDiscoveryServer(t)
+	d.set(http.StatusOK, map[string]string{"issuer": "https://evil.example"})
+	cfg := testConfig(newTestIssuer(t))
+	cfg.Server.OAuth2.Provider = config.Provider{Issuer: d.server.URL, Validators: []string{validatorJWKS}}
+	s := &proxyServer{cfg: cfg}
+	if err := s.initTokenValidation(t.Context()); err == nil {
+		t.Fatal("initTokenValidation() succeeded with a mismatching issuer")
+	}
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// defaultExtAuthzTimeout est utilisé si aucun timeout n'est configuré
+	defaultExtAuthzTimeout = time.Second
+	// defaultExtAuthzCacheMaxEntries est utilisé si aucune taille n'est configurée
+	defaultExtAuthzCacheMaxEntries = 10000
+)
+
+// Métriques du service d'autorisation externe, exposées sur /ops/metrics
+var (
+	extAuthzDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oauth2_ext_authz_decisions_total",
+		Help: "Number of external authorization decisions by outcome (allow, deny, error).",
+	}, []string{"decision"})
+	extAuthzCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oauth2_ext_authz_cache_hits_total",
+		Help: "Number of external authorization decisions served from the cache.",
+	})
+	extAuthzCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oauth2_ext_authz_cache_misses_total",
+		Help: "Number of external authorization decisions not found in the cache.",
+	})
+	extAuthzCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oauth2_ext_authz_cache_evictions_total",
+		Help: "Number of cached decisions evicted because the cache was full.",
+	})
+)
+
+// extAuthzCacheMetrics sont les compteurs des caches de décisions
+var extAuthzCacheMetrics = cacheMetrics{hits: extAuthzCacheHits, misses: extAuthzCacheMisses, evictions: extAuthzCacheEvictions}
+
+// errExtAuthzUnavailable signale un service injoignable, hors délai ou en
+// erreur 5xx : seuls ces échecs sont couverts par fail_open
+var errExtAuthzUnavailable = errors.New("authorization service unavailable")
+
+// extAuthzRequest est le document envoyé au service d'autorisation
+type extAuthzRequest struct {
+	Route   string         `json:"route"`
+	Request map[string]any `json:"request"`
+	Token   map[string]any `json:"token,omitempty"`
+	Claims  map[string]any `json:"claims,omitempty"`
+}
+
+// extAuthzDecision est la réponse du service d'autorisation. Headers est
+// ajouté à la requête transmise au backend si elle est autorisée, à la
+// réponse renvoyée au client sinon ; Status et Body remplacent la réponse
+// 403 par défaut d'un refus.
+type extAuthzDecision struct {
+	Allow   bool              `json:"allow"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Status  int               `json:"status,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// extAuthzClient appelle le service d'autorisation d'une route
+type extAuthzClient struct {
+	cfg    config.ExtAuthz
+	client *http.Client
+	// cache conserve les décisions pendant cacheTTL
+	cache    *lruCache[*extAuthzDecision]
+	cacheTTL time.Duration
+}
+
+// newExtAuthzClient crée le client du service d'autorisation d'une route
+func newExtAuthzClient(cfg config.ExtAuthz) *extAuthzClient {
+	timeout := time.Duration(cfg.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultExtAuthzTimeout
+	}
+	authz := &extAuthzClient{
+		cfg:    cfg,
+		client: &http.Client{Timeout: timeout},
+	}
+	if cfg.CacheTTL > 0 {
+		maxEntries := cfg.CacheMaxEntries
+		if maxEntries <= 0 {
+			maxEntries = defaultExtAuthzCacheMaxEntries
+		}
+		authz.cache = newLRUCache[*extAuthzDecision](maxEntries, extAuthzCacheMetrics)
+		authz.cacheTTL = time.Duration(cfg.CacheTTL) * time.Second
+	}
+	return authz
+}
+
+// check retourne la décision du service pour la requête, depuis le cache si possible
+func (a *extAuthzClient) check(ctx context.Context, key string, payload extAuthzRequest) (*extAuthzDecision, error) {
+	if a.cache != nil {
+		if decision, ok := a.cache.get(key); ok {
+			return decision, nil
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode authorization request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorization request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errExtAuthzUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: status %d", errExtAuthzUnavailable, resp.StatusCode)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		// Refus exprimé par le statut de la réponse
+		return &extAuthzDecision{Status: resp.StatusCode}, nil
+	case resp.StatusCode >= http.StatusBadRequest:
+		log.Printf("Service d'autorisation en erreur %d, requête refusée", resp.StatusCode)
+		return &extAuthzDecision{}, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("authorization service returned status: %d", resp.StatusCode)
+	}
+
+	var decision extAuthzDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("failed to decode authorization decision: %w", err)
+	}
+	if decision.Status != 0 && (decision.Status < 100 || decision.Status > 599) {
+		return nil, fmt.Errorf("authorization service returned an invalid status %d", decision.Status)
+	}
+
+	if a.cache != nil {
+		a.cache.set(key, &decision, time.Now().Add(a.cacheTTL))
+	}
+	return &decision, nil
+}
+
+// payload construit le document décrivant la requête et le token validé
+func (a *extAuthzClient) payload(c *gin.Context, route config.Route) extAuthzRequest {
+	headers := make(map[string]any, len(a.cfg.IncludeHeaders))
+	for _, name := range a.cfg.IncludeHeaders {
+		if values := c.Request.Header.Values(name); len(values) > 0 {
+			headers[strings.ToLower(name)] = strings.Join(values, ",")
+		}
+	}
+
+	payload := extAuthzRequest{
+		Route: route.Path,
+		Request: map[string]any{
+			"method":    c.Request.Method,
+			"path":      c.Request.URL.Path,
+			"host":      c.Request.Host,
+			"query":     c.Request.URL.Query(),
+			"headers":   headers,
+			"client_ip": c.ClientIP(),
+		},
+	}
+	if value, ok := c.Get("tokenInfo"); ok {
+		tokenInfo := value.(*TokenInfo)
+		payload.Token = tokenDocument(tokenInfo)
+		payload.Claims = tokenInfo.Claims
+	}
+	return payload
+}
+
+// cacheKey identifie une décision : requête, token et headers transmis
+func (a *extAuthzClient) cacheKey(c *gin.Context) string {
+	parts := []string{c.Request.Method, c.Request.Host, c.Request.URL.Path, c.Request.URL.RawQuery, c.GetString("accessToken")}
+	names := append([]string(nil), a.cfg.IncludeHeaders...)
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, name+"="+strings.Join(c.Request.Header.Values(name), ","))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// extAuthzMiddleware soumet la requête au service d'autorisation externe
+// avant de la transmettre au backend
+func (s *proxyServer) extAuthzMiddleware(route config.Route) gin.HandlerFunc {
+	authz := newExtAuthzClient(route.ExtAuthz)
+	return func(c *gin.Context) {
+		decision, err := authz.check(c.Request.Context(), authz.cacheKey(c), authz.payload(c, route))
+		if err != nil {
+			extAuthzDecisions.WithLabelValues("error").Inc()
+			if authz.cfg.FailOpen && errors.Is(err, errExtAuthzUnavailable) {
+				log.Printf("Service d'autorisation indisponible, requête autorisée (fail-open): %v", err)
+				c.Next()
+				return
+			}
+			log.Printf("Service d'autorisation indisponible, requête refusée: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":   "Service Unavailable",
+				"message": "Service d'autorisation indisponible",
+			})
+			c.Abort()
+			return
+		}
+
+		if !decision.Allow {
+			extAuthzDecisions.WithLabelValues("deny").Inc()
+			for name, value := range decision.Headers {
+				c.Header(name, value)
+			}
+			status := decision.Status
+			if status == 0 {
+				status = http.StatusForbidden
+			}
+			if len(decision.Body) > 0 {
+				c.Data(status, "application/json; charset=utf-8", decision.Body)
+			} else {
+				c.JSON(status, gin.H{
+					"error":   http.StatusText(status),
+					"message": "Accès refusé par le service d'autorisation",
+				})
+			}
+			c.Abort()
+			return
+		}
+
+		extAuthzDecisions.WithLabelValues("allow").Inc()
+		for name, value := range decision.Headers {
+			c.Request.Header.Set(name, value)
+		}
+		c.Next()
+	}
+}
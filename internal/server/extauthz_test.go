@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestExtAuthz(t *testing.T) {
+	allow := func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(extAuthzDecision{Allow: true, Headers: map[string]string{"X-Authz": "ok"}})
+	}
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		failOpen    bool
+		want        int
+		wantBackend bool
+		wantHeader  string
+	}{
+		{name: "allow", handler: allow, want: http.StatusOK, wantBackend: true, wantHeader: "ok"},
+		{name: "deny", handler: func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(extAuthzDecision{Allow: false})
+		}, want: http.StatusForbidden},
+		{name: "deny with status", handler: func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(extAuthzDecision{Allow: false, Status: http.StatusTooManyRequests})
+		}, want: http.StatusTooManyRequests},
+		{name: "403", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}, want: http.StatusForbidden},
+		{name: "403 with fail open", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}, failOpen: true, want: http.StatusForbidden},
+		{name: "401 with fail open", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}, failOpen: true, want: http.StatusUnauthorized},
+		{name: "404 with fail open", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}, failOpen: true, want: http.StatusForbidden},
+		{name: "5xx", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, want: http.StatusServiceUnavailable},
+		{name: "5xx with fail open", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}, failOpen: true, want: http.StatusOK, wantBackend: true},
+		{name: "timeout", handler: func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			allow(w, r)
+		}, want: http.StatusServiceUnavailable},
+		{name: "timeout with fail open", handler: func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}, failOpen: true, want: http.StatusOK, wantBackend: true},
+		{name: "invalid decision with fail open", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("not json"))
+		}, failOpen: true, want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := httptest.NewServer(tt.handler)
+			t.Cleanup(service.Close)
+			issuer := newTestIssuer(t)
+			backend, received := newTestBackend(t)
+			_, gateway := newTestGateway(t, testConfig(issuer, config.Route{
+				Path:     "/api",
+				Target:   backend.URL,
+				Auth:     authNone,
+				ExtAuthz: config.ExtAuthz{URL: service.URL, TimeoutMs: 50, FailOpen: tt.failOpen},
+			}))
+
+			resp := doRequest(t, gateway, http.MethodGet, "/api/items", nil)
+			if resp.StatusCode != tt.want {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+			request, ok := lastRequest(received)
+			if ok != tt.wantBackend {
+				t.Fatalf("backend reached = %v, want %v", ok, tt.wantBackend)
+			}
+			if header := request.header.Get("X-Authz"); header != tt.wantHeader {
+				t.Errorf("X-Authz = %q, want %q", header, tt.wantHeader)
+			}
+		})
+	}
+}
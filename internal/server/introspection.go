@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errTokenInactive = errors.New("token is not active")
+
+// introspectionResponse représente la réponse de l'endpoint d'introspection (RFC 7662)
+type introspectionResponse struct {
+	TokenInfo
+	Active   bool   `json:"active"`
+	Username string `json:"username"`
+}
+
+// introspectionValidator valide les tokens (opaques ou JWT) auprès de l'endpoint d'introspection
+type introspectionValidator struct {
+	url          func() string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+}
+
+// newIntrospectionValidator crée un validateur authentifié par client credentials
+func newIntrospectionValidator(url func() string, clientID, clientSecret string) *introspectionValidator {
+	return &introspectionValidator{
+		url:          url,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Validate interroge l'endpoint d'introspection et mappe la réponse en TokenInfo
+func (v *introspectionValidator) Validate(ctx context.Context, tokenString string) (*TokenInfo, error) {
+	form := url.Values{
+		"token":           {tokenString},
+		"token_type_hint": {"access_token"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create introspection request: %w", err)
+	}
+
+	// Authentification client_secret_basic (RFC 6749 §2.3.1)
+	req.SetBasicAuth(url.QueryEscape(v.clientID), url.QueryEscape(v.clientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to introspect token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned status: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read introspection response: %w", err)
+	}
+	var introspection introspectionResponse
+	if err := json.Unmarshal(body, &introspection); err != nil {
+		return nil, fmt.Errorf("failed to decode introspection response: %w", err)
+	}
+	if err := introspection.decodeClaims(body); err != nil {
+		return nil, err
+	}
+
+	if !introspection.Active {
+		return nil, errTokenInactive
+	}
+
+	tokenInfo := introspection.TokenInfo
+	if tokenInfo.PreferredUsername == "" {
+		tokenInfo.PreferredUsername = introspection.Username
+	}
+
+	// Le serveur d'autorisation fait foi, mais on refuse un token déjà expiré
+	if tokenInfo.Expiration != 0 && time.Now().After(time.Unix(tokenInfo.Expiration, 0)) {
+		return nil, errTokenExpired
+	}
+
+	if err := tokenInfo.normalize(); err != nil {
+		return nil, err
+	}
+	return &tokenInfo, nil
+}
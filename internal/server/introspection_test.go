@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// introspectionServer répond aux introspections authentifiées par
+// clientID/secret selon la réponse associée au token
+func introspectionServer(t *testing.T, clientID, secret string, responses map[string]map[string]any) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// client_secret_basic : identifiants encodés en form-urlencoded (RFC 6749 §2.3.1)
+		user, password, ok := r.BasicAuth()
+		if !ok || user != url.QueryEscape(clientID) || password != url.QueryEscape(secret) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.PostFormValue("token_type_hint") != "access_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response, ok := responses[r.PostFormValue("token")]
+		if !ok {
+			response = map[string]any{"active": false}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIntrospectionValidator(t *testing.T) {
+	const clientID, secret = "gateway client", "s3cr:t&+"
+	exp := time.Now().Add(time.Hour).Unix()
+	server := introspectionServer(t, clientID, secret, map[string]map[string]any{
+		"active":  {"active": true, "sub": "alice", "username": "alice.martin", "scope": "openid orders:read", "client_id": "web", "exp": exp},
+		"named":   {"active": true, "sub": "bob", "username": "bob", "preferred_username": "robert", "exp": exp},
+		"false":   {"active": false, "sub": "alice", "exp": exp},
+		"absent":  {"sub": "alice", "exp": exp},
+		"string":  {"active": "true", "sub": "alice", "exp": exp},
+		"expired": {"active": true, "sub": "alice", "exp": time.Now().Add(-time.Minute).Unix()},
+	})
+
+	tests := []struct {
+		name         string
+		token        string
+		clientSecret string
+		wantErr      error
+		wantAnyErr   bool
+		wantUser     string
+	}{
+		{name: "active", token: "active", clientSecret: secret, wantUser: "alice.martin"},
+		{name: "preferred username kept", token: "named", clientSecret: secret, wantUser: "robert"},
+		{name: "inactive", token: "false", clientSecret: secret, wantErr: errTokenInactive},
+		{name: "active absent", token: "absent", clientSecret: secret, wantErr: errTokenInactive},
+		{name: "unknown token", token: "unknown", clientSecret: secret, wantErr: errTokenInactive},
+		{name: "active not a boolean", token: "string", clientSecret: secret, wantAnyErr: true},
+		{name: "expired", token: "expired", clientSecret: secret, wantErr: errTokenExpired},
+		{name: "wrong client secret", token: "active", clientSecret: "wrong", wantAnyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := newIntrospectionValidator(func() string { return server.URL }, clientID, tt.clientSecret)
+			info, err := validator.Validate(t.Context(), tt.token)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case tt.wantAnyErr:
+				if err == nil {
+					t.Fatal("Validate() succeeded, want an error")
+				}
+				return
+			case err != nil:
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if info.PreferredUsername != tt.wantUser {
+				t.Errorf("PreferredUsername = %q, want %q", info.PreferredUsername, tt.wantUser)
+			}
+		})
+	}
+}
+
+// Un token opaque est validé par introspection et ses claims servent aux
+// contrôles d'autorisation de la route
+func TestIntrospectionOnRoute(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	introspection := introspectionServer(t, "gateway", "secret", map[string]map[string]any{
+		"reader":  {"active": true, "sub": "alice", "scope": "orders:read", "exp": exp},
+		"other":   {"active": true, "sub": "bob", "scope": "profile", "exp": exp},
+		"revoked": {"active": false},
+	})
+	issuer := newTestIssuer(t)
+	backend, _ := newTestBackend(t)
+	cfg := testConfig(issuer, config.Route{Path: "/api/orders", Target: backend.URL, AccessRule: config.AccessRule{Scopes: []string{"orders:read"}}})
+	cfg.Server.OAuth2.Provider.ClientSecret = "secret"
+	cfg.Server.OAuth2.Provider.Endpoints.IntrospectionURL = introspection.URL
+	cfg.Server.OAuth2.Provider.Validators = []string{validatorIntrospection}
+	_, gateway := newTestGateway(t, cfg)
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{token: "reader", want: http.StatusOK},
+		{token: "other", want: http.StatusForbidden},
+		{token: "revoked", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			if resp := doRequest(t, gateway, http.MethodGet, "/api/orders", bearer(tt.token)); resp.StatusCode != tt.want {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+// L'introspection exige un client_id et un endpoint, à défaut celui de tokeninfo
+func TestIntrospectionRequirements(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider config.Provider
+		wantErr  bool
+	}{
+		{name: "introspection endpoint", provider: config.Provider{ClientID: "gateway", Endpoints: config.OAuth2Endpoints{IntrospectionURL: "http://idp/introspect"}}},
+		{name: "tokeninfo endpoint", provider: config.Provider{ClientID: "gateway", Endpoints: config.OAuth2Endpoints{TokenInfoURL: "http://idp/tokeninfo"}}},
+		{name: "no endpoint", provider: config.Provider{ClientID: "gateway", Endpoints: config.OAuth2Endpoints{AuthURL: "http://idp/auth"}}, wantErr: true},
+		{name: "no client id", provider: config.Provider{Endpoints: config.OAuth2Endpoints{IntrospectionURL: "http://idp/introspect"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newIdentityProvider(tt.provider)
+			if _, err := provider.validatorByName(t.Context(), validatorIntrospection); (err != nil) != tt.wantErr {
+				t.Errorf("validatorByName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
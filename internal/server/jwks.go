@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// defaultJWKSRefreshInterval est utilisé si aucun intervalle n'est configuré
+	defaultJWKSRefreshInterval = 15 * time.Minute
+	// jwksMinRefetchInterval limite les rechargements déclenchés par un kid inconnu
+	jwksMinRefetchInterval = 10 * time.Second
+)
+
+var errUnknownKey = errors.New("no matching key found in JWKS")
+
+// jsonWebKey représente une clé publique au format JWK (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksKey est une clé publique décodée prête à vérifier des signatures
+type jwksKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// jwksCache maintient en mémoire le jeu de clés du fournisseur d'identité
+type jwksCache struct {
+	url             func() string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	mu        sync.RWMutex
+	keys      []jwksKey
+	lastFetch time.Time
+	// refetches regroupe les rechargements déclenchés par un kid inconnu
+	refetches singleflight.Group
+}
+
+// newJWKSCache crée un cache JWKS ; l'URL est relue à chaque rechargement
+// afin de suivre les changements annoncés par la découverte OIDC
+func newJWKSCache(url func() string, refreshInterval time.Duration) *jwksCache {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+	return &jwksCache{
+		url:             url,
+		refreshInterval: refreshInterval,
+		client:          &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// start charge le JWKS puis le rafraîchit périodiquement en arrière-plan
+func (j *jwksCache) start(ctx context.Context) error {
+	if err := j.refresh(ctx); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(j.refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := j.refresh(ctx); err != nil {
+					log.Printf("Erreur de rafraîchissement du JWKS: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// keysFor retourne les clés candidates pour un kid et un algorithme donnés.
+// Si le kid est inconnu, le JWKS est rechargé une fois (rotation de clés).
+func (j *jwksCache) keysFor(ctx context.Context, kid, alg string) ([]crypto.PublicKey, error) {
+	if keys := j.lookup(kid, alg); len(keys) > 0 {
+		return keys, nil
+	}
+
+	// Les requêtes concurrentes attendent un seul rechargement, qui n'est pas
+	// annulé avec la requête qui l'a déclenché ; les suivantes le réutilisent
+	// pendant jwksMinRefetchInterval
+	_, err, _ := j.refetches.Do("", func() (any, error) {
+		j.mu.RLock()
+		recentlyFetched := time.Since(j.lastFetch) < jwksMinRefetchInterval
+		j.mu.RUnlock()
+		if recentlyFetched {
+			return nil, nil
+		}
+		return nil, j.refresh(context.WithoutCancel(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if keys := j.lookup(kid, alg); len(keys) > 0 {
+		return keys, nil
+	}
+	return nil, fmt.Errorf("%w (kid=%q, alg=%s)", errUnknownKey, kid, alg)
+}
+
+// lookup recherche les clés correspondant au kid et compatibles avec l'algorithme
+func (j *jwksCache) lookup(kid, alg string) []crypto.PublicKey {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	var keys []crypto.PublicKey
+	for _, k := range j.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		if !keyMatchesAlg(k.key, alg) {
+			continue
+		}
+		keys = append(keys, k.key)
+	}
+	return keys
+}
+
+// refresh télécharge et décode le JWKS
+func (j *jwksCache) refresh(ctx context.Context) error {
+	url := j.url()
+	if url == "" {
+		return errors.New("no JWKS URL configured or discovered")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS endpoint returned status: %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make([]jwksKey, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("Clé JWKS ignorée (kid=%s): %v", jwk.Kid, err)
+			continue
+		}
+		keys = append(keys, jwksKey{kid: jwk.Kid, alg: jwk.Alg, key: key})
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.lastFetch = time.Now()
+	j.mu.Unlock()
+	return nil
+}
+
+// publicKey convertit un JWK en clé publique Go
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if _, err := key.ECDH(); err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// keyMatchesAlg vérifie que le type de clé est compatible avec l'algorithme
+func keyMatchesAlg(key crypto.PublicKey, alg string) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return alg[:2] == "RS" || alg[:2] == "PS"
+	case *ecdsa.PublicKey:
+		return alg[:2] == "ES"
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newSlowJWKSCache retourne un cache JWKS dont chaque téléchargement dure
+// delay, et le compteur de ses téléchargements
+func newSlowJWKSCache(t *testing.T, delay time.Duration) (*jwksCache, *atomic.Int32) {
+	t.Helper()
+	issuer := newTestIssuer(t)
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		time.Sleep(delay)
+		issuer.writeJWKS(w)
+	}))
+	t.Cleanup(server.Close)
+	return newJWKSCache(func() string { return server.URL }, time.Hour), &fetches
+}
+
+func TestJWKSRefetchIsCoalesced(t *testing.T) {
+	cache, fetches := newSlowJWKSCache(t, 50*time.Millisecond)
+
+	// Des requêtes simultanées portant un kid absent du cache ne déclenchent
+	// qu'un téléchargement
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.keysFor(t.Context(), testKID, "RS256"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("keysFor: %v", err)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", n)
+	}
+
+	// Un kid inconnu juste après le téléchargement ne le relance pas
+	if _, err := cache.keysFor(t.Context(), "rotated", "RS256"); !errors.Is(err, errUnknownKey) {
+		t.Errorf("keysFor(unknown kid) error = %v, want %v", err, errUnknownKey)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times after an unknown kid, want 1", n)
+	}
+}
+
+func TestJWKSRefetchSurvivesCanceledCaller(t *testing.T) {
+	cache, _ := newSlowJWKSCache(t, 50*time.Millisecond)
+
+	// Le téléchargement partagé n'est pas annulé avec la requête qui l'a
+	// déclenché
+	ctx, cancel := context.WithCancel(t.Context())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := cache.keysFor(ctx, testKID, "RS256"); err != nil {
+		t.Errorf("keysFor with a canceled context: %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	errMalformedJWT     = errors.New("malformed JWT")
+	errUnsupportedAlg   = errors.New("unsupported JWT signing algorithm")
+	errInvalidSignature = errors.New("invalid JWT signature")
+	errTokenExpired     = errors.New("token expired")
+	errTokenNotYetValid = errors.New("token not yet valid")
+)
+
+// jwtHeader représente l'en-tête JOSE d'un JWT
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// parsedJWT contient les différentes parties d'un JWT compact
+type parsedJWT struct {
+	header       jwtHeader
+	payload      []byte
+	signingInput string
+	signature    []byte
+}
+
+// signingHashes associe chaque algorithme supporté à sa fonction de hachage
+var signingHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// parseJWT découpe et décode un JWT compact sans en vérifier la signature
+func parseJWT(tokenString string) (*parsedJWT, error) {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedJWT
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %v", errMalformedJWT, err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", errMalformedJWT, err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", errMalformedJWT, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", errMalformedJWT, err)
+	}
+
+	return &parsedJWT{
+		header:       header,
+		payload:      payload,
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}, nil
+}
+
+// verify vérifie la signature du JWT avec la clé publique fournie
+func (t *parsedJWT) verify(key crypto.PublicKey) error {
+	hash, ok := signingHashes[t.header.Alg]
+	if !ok {
+		return fmt.Errorf("%w: %q", errUnsupportedAlg, t.header.Alg)
+	}
+	h := hash.New()
+	h.Write([]byte(t.signingInput))
+	digest := h.Sum(nil)
+
+	switch t.header.Alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type mismatch", errInvalidSignature)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, t.signature); err != nil {
+			return errInvalidSignature
+		}
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type mismatch", errInvalidSignature)
+		}
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: hash}
+		if err := rsa.VerifyPSS(pub, hash, digest, t.signature, opts); err != nil {
+			return errInvalidSignature
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type mismatch", errInvalidSignature)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if hash.Size()*8 != curveHashBits(pub.Curve.Params().BitSize) || len(t.signature) != 2*size {
+			return errInvalidSignature
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		sig := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(pub, digest, r, sig) {
+			return errInvalidSignature
+		}
+	}
+	return nil
+}
+
+// curveHashBits retourne la taille de hash attendue pour une courbe (RFC 7518 §3.4)
+func curveHashBits(curveBits int) int {
+	if curveBits == 521 {
+		return 512
+	}
+	return curveBits
+}
+
+// checkTimeClaims vérifie exp, nbf et iat avec la tolérance configurée
+func checkTimeClaims(tokenInfo *TokenInfo, leeway time.Duration) error {
+	now := time.Now()
+	if tokenInfo.Expiration == 0 {
+		return fmt.Errorf("missing required claim 'exp'")
+	}
+	if now.After(time.Unix(tokenInfo.Expiration, 0).Add(leeway)) {
+		return errTokenExpired
+	}
+	if tokenInfo.NotBefore != 0 && now.Add(leeway).Before(time.Unix(tokenInfo.NotBefore, 0)) {
+		return errTokenNotYetValid
+	}
+	if tokenInfo.IssuedAt != 0 && now.Add(leeway).Before(time.Unix(tokenInfo.IssuedAt, 0)) {
+		return fmt.Errorf("%w: issued in the future", errTokenNotYetValid)
+	}
+	return nil
+}
+
+// jwksValidator valide localement les JWT à l'aide du JWKS du fournisseur d'identité
+type jwksValidator struct {
+	keys   *jwksCache
+	leeway time.Duration
+}
+
+// Validate vérifie la signature et les dates du JWT puis en extrait les claims
+func (v *jwksValidator) Validate(ctx context.Context, tokenString string) (*TokenInfo, error) {
+	tokenInfo, err := v.verify(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if err := tokenInfo.normalize(); err != nil {
+		return nil, err
+	}
+	return tokenInfo, nil
+}
+
+// verify vérifie la signature et les dates du JWT et en décode les claims,
+// sans exiger ceux d'un access token (un logout token peut n'avoir que sid)
+func (v *jwksValidator) verify(ctx context.Context, tokenString string) (*TokenInfo, error) {
+	token, err := parseJWT(tokenString)
+	if err != nil {
+		// Token opaque : laisser la main au validateur suivant de la chaîne
+		return nil, fmt.Errorf("%w: %w", errTokenNotSupported, err)
+	}
+	if _, ok := signingHashes[token.header.Alg]; !ok {
+		return nil, fmt.Errorf("%w: %q", errUnsupportedAlg, token.header.Alg)
+	}
+
+	keys, err := v.keys.keysFor(ctx, token.header.Kid, token.header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	verified := false
+	for _, key := range keys {
+		if token.verify(key) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errInvalidSignature
+	}
+
+	var tokenInfo TokenInfo
+	if err := json.Unmarshal(token.payload, &tokenInfo); err != nil {
+		return nil, fmt.Errorf("failed to decode token claims: %w", err)
+	}
+	if err := tokenInfo.decodeClaims(token.payload); err != nil {
+		return nil, err
+	}
+
+	if err := checkTimeClaims(&tokenInfo, v.leeway); err != nil {
+		return nil, err
+	}
+	return &tokenInfo, nil
+}
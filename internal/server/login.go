@@ -0,0 +1,526 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// loginCookie conserve state, nonce et code_verifier pendant l'aller-retour chez le fournisseur
+	loginCookie = "oauth2_login"
+	// sessionCookie porte l'identifiant chiffré de la session navigateur
+	sessionCookie = "oauth2_session"
+	// loginTimeout borne la durée d'une tentative de connexion
+	loginTimeout = 10 * time.Minute
+	// defaultSessionMaxAge est la durée de vie par défaut d'une session
+	defaultSessionMaxAge = 8 * time.Hour
+	// defaultRefreshBefore est le délai par défaut de rafraîchissement avant expiration
+	defaultRefreshBefore = time.Minute
+	// sessionCleanupInterval espace les purges des sessions expirées
+	sessionCleanupInterval = 10 * time.Minute
+	// defaultLoginRedirect est la page d'arrivée par défaut après login ou logout
+	defaultLoginRedirect = "/"
+)
+
+// defaultLoginScopes sont demandés si aucun scope n'est configuré
+var defaultLoginScopes = []string{"openid", "profile", "email"}
+
+// pendingLogin contient les secrets d'une tentative de connexion
+type pendingLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"redirect"`
+	Expires  int64  `json:"exp"`
+}
+
+// browserLogin implémente le flow authorization code + PKCE des navigateurs.
+// Les tokens obtenus restent dans une session côté serveur (backend for
+// frontend) : le navigateur ne reçoit qu'un cookie de session chiffré.
+type browserLogin struct {
+	cfg          config.Login
+	provider     *identityProvider
+	codec        *cookieCodec
+	client       *http.Client
+	idTokens     *jwksValidator
+	callbackPath string
+	backchannel  string
+	secure       bool
+
+	sessions      SessionStore
+	sessionMaxAge time.Duration
+	refreshBefore time.Duration
+	sameSite      http.SameSite
+	refreshes     singleflight.Group
+}
+
+// initLogin prépare le login navigateur avec le premier fournisseur d'identité
+func (s *proxyServer) initLogin(ctx context.Context) error {
+	loginCfg := s.cfg.Server.OAuth2.Login
+	if !loginCfg.Enabled {
+		return nil
+	}
+	if len(s.providers) == 0 {
+		return errors.New("login: no identity provider")
+	}
+	provider := s.providers[0]
+	endpoints := provider.endpoints()
+	switch {
+	case provider.cfg.ClientID == "":
+		return errors.New("login: client_id is required")
+	case provider.cfg.RedirectURL == "":
+		return errors.New("login: redirect_url is required")
+	case endpoints.AuthURL == "" || endpoints.TokenURL == "":
+		return errors.New("login: endpoints.auth_url and endpoints.token_url are required")
+	}
+	redirectURL, err := url.Parse(provider.cfg.RedirectURL)
+	if err != nil || redirectURL.Path == "" {
+		return fmt.Errorf("login: invalid redirect_url %q", provider.cfg.RedirectURL)
+	}
+
+	// L'id_token est vérifié avec les clés publiques du fournisseur
+	validator, err := provider.validatorByName(ctx, validatorJWKS)
+	if err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+	idTokens := validator.(*jwksValidator)
+	backchannel := loginCfg.BackchannelLogoutPath
+	if backchannel == "" {
+		backchannel = defaultBackchannelLogoutPath
+	}
+	codec, err := newCookieCodec(loginCfg.CookieSecret)
+	if err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+	if loginCfg.CookieSecret == "" {
+		log.Printf("Aucun cookie_secret configuré : clé de chiffrement des cookies générée au démarrage")
+	}
+
+	sessionCfg := loginCfg.Session
+	var sessions SessionStore
+	switch sessionCfg.Store {
+	case "", sessionStoreMemory:
+		sessions = newMemorySessionStore()
+	case sessionStoreFile:
+		if sessions, err = newFileSessionStore(sessionCfg.Directory, codec); err != nil {
+			return fmt.Errorf("login: %w", err)
+		}
+	default:
+		return fmt.Errorf("login: unknown session store %q", sessionCfg.Store)
+	}
+	sameSite := http.SameSiteLaxMode
+	switch sessionCfg.SameSite {
+	case "", "lax":
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	default:
+		return fmt.Errorf("login: unknown session same_site %q", sessionCfg.SameSite)
+	}
+	sessionMaxAge := time.Duration(sessionCfg.MaxAge) * time.Second
+	if sessionMaxAge <= 0 {
+		sessionMaxAge = defaultSessionMaxAge
+	}
+	refreshBefore := time.Duration(sessionCfg.RefreshBefore) * time.Second
+	if refreshBefore <= 0 {
+		refreshBefore = defaultRefreshBefore
+	}
+
+	s.login = &browserLogin{
+		cfg:           loginCfg,
+		provider:      provider,
+		codec:         codec,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		idTokens:      idTokens,
+		callbackPath:  redirectURL.Path,
+		backchannel:   backchannel,
+		secure:        redirectURL.Scheme == "https",
+		sessions:      sessions,
+		sessionMaxAge: sessionMaxAge,
+		refreshBefore: refreshBefore,
+		sameSite:      sameSite,
+	}
+	s.logouts = newLogoutRegistry()
+	go s.login.cleanupSessions(ctx)
+	return nil
+}
+
+// cleanupSessions purge périodiquement les sessions expirées
+func (l *browserLogin) cleanupSessions(ctx context.Context) {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.sessions.DeleteExpired(ctx); err != nil {
+				log.Printf("Erreur de purge des sessions expirées: %v", err)
+			}
+		}
+	}
+}
+
+// addLoginRoutes enregistre /login, le callback, /logout et la réception des
+// déconnexions back-channel
+func (s *proxyServer) addLoginRoutes() {
+	if s.login == nil {
+		return
+	}
+	log.Printf("Browser login enabled: /login, %s, /logout, %s", s.login.callbackPath, s.login.backchannel)
+	s.engine.GET("/login", s.login.start)
+	s.engine.GET(s.login.callbackPath, s.login.callback)
+	// La déconnexion modifie l'état : un lien ou une image d'un autre site ne
+	// doit pas pouvoir la déclencher, la protection CSRF ignorant les GET
+	s.engine.POST("/logout", s.login.logout)
+	s.engine.POST(s.login.backchannel, s.backchannelLogout)
+}
+
+// oauth2Config retourne la configuration du client OAuth2, avec les
+// endpoints courants du fournisseur
+func (l *browserLogin) oauth2Config() *oauth2.Config {
+	endpoints := l.provider.endpoints()
+	scopes := l.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultLoginScopes
+	}
+	return &oauth2.Config{
+		ClientID:     l.provider.cfg.ClientID,
+		ClientSecret: l.provider.cfg.ClientSecret,
+		RedirectURL:  l.provider.cfg.RedirectURL,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  endpoints.AuthURL,
+			TokenURL: endpoints.TokenURL,
+		},
+	}
+}
+
+// start redirige le navigateur vers le fournisseur d'identité
+func (l *browserLogin) start(c *gin.Context) {
+	pending := pendingLogin{
+		State:    rand.Text(),
+		Nonce:    rand.Text(),
+		Verifier: oauth2.GenerateVerifier(),
+		Redirect: l.localRedirect(c.Query("redirect")),
+		Expires:  time.Now().Add(loginTimeout).Unix(),
+	}
+	value, err := l.codec.encode(loginCookie, pending)
+	if err != nil {
+		log.Printf("Erreur de création du cookie de login: %v", err)
+		loginError(c, http.StatusInternalServerError, "Impossible de démarrer la connexion")
+		return
+	}
+	l.setCookie(c, loginCookie, value, l.callbackPath, int(loginTimeout.Seconds()), http.SameSiteLaxMode)
+
+	authURL := l.oauth2Config().AuthCodeURL(pending.State,
+		oauth2.S256ChallengeOption(pending.Verifier),
+		oauth2.SetAuthURLParam("nonce", pending.Nonce))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// callback échange le code d'autorisation contre les tokens, vérifie
+// l'id_token puis ouvre une session
+func (l *browserLogin) callback(c *gin.Context) {
+	// La tentative de connexion ne peut servir qu'une fois
+	cookie, cookieErr := c.Cookie(loginCookie)
+	l.setCookie(c, loginCookie, "", l.callbackPath, -1, http.SameSiteLaxMode)
+
+	var pending pendingLogin
+	if cookieErr != nil || l.codec.decode(loginCookie, cookie, &pending) != nil || time.Now().Unix() > pending.Expires {
+		loginError(c, http.StatusBadRequest, "Tentative de connexion inconnue ou expirée")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(pending.State)) != 1 {
+		loginError(c, http.StatusBadRequest, "Paramètre state invalide")
+		return
+	}
+	if errorCode := c.Query("error"); errorCode != "" {
+		log.Printf("Connexion refusée par le fournisseur: %s %s", errorCode, c.Query("error_description"))
+		loginError(c, http.StatusUnauthorized, "Connexion refusée par le fournisseur d'identité")
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		loginError(c, http.StatusBadRequest, "Code d'autorisation manquant")
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), oauth2.HTTPClient, l.client)
+	token, err := l.oauth2Config().Exchange(ctx, code, oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		log.Printf("Erreur d'échange du code d'autorisation: %v", err)
+		loginError(c, http.StatusBadGateway, "Échec de l'échange du code d'autorisation")
+		return
+	}
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" {
+		loginError(c, http.StatusBadGateway, "id_token absent de la réponse du fournisseur")
+		return
+	}
+	tokenInfo, err := l.verifyIDToken(c.Request.Context(), idToken, pending.Nonce)
+	if err != nil {
+		log.Printf("id_token refusé: %v", err)
+		loginError(c, http.StatusUnauthorized, "id_token invalide")
+		return
+	}
+
+	// Une nouvelle session remplace toujours la précédente (fixation de session)
+	if id, ok := l.sessionID(c); ok {
+		l.deleteSession(c.Request.Context(), id)
+	}
+	session := &Session{
+		ID:           rand.Text(),
+		Subject:      tokenInfo.Sub,
+		SID:          tokenSID(tokenInfo),
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		IDToken:      idToken,
+		TokenExpiry:  token.Expiry,
+		ExpiresAt:    time.Now().Add(l.sessionMaxAge),
+	}
+	if err := l.sessions.Save(c.Request.Context(), session); err != nil {
+		log.Printf("Erreur d'enregistrement de la session: %v", err)
+		loginError(c, http.StatusInternalServerError, "Impossible de finaliser la connexion")
+		return
+	}
+	value, err := l.codec.encode(sessionCookie, session.ID)
+	if err != nil {
+		log.Printf("Erreur de création du cookie de session: %v", err)
+		loginError(c, http.StatusInternalServerError, "Impossible de finaliser la connexion")
+		return
+	}
+	l.setCookie(c, sessionCookie, value, "/", int(l.sessionMaxAge.Seconds()), l.sameSite)
+
+	log.Printf("Connexion navigateur réussie pour: %s (%s)", tokenInfo.Name, tokenInfo.Email)
+	c.Redirect(http.StatusFound, pending.Redirect)
+}
+
+// verifyIDToken vérifie la signature, l'émetteur, le destinataire et le nonce de l'id_token
+func (l *browserLogin) verifyIDToken(ctx context.Context, idToken, nonce string) (*TokenInfo, error) {
+	tokenInfo, err := l.idTokens.Validate(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+	if l.provider.cfg.Issuer != "" && !l.provider.matchesIssuer(tokenInfo.Issuer) {
+		return nil, fmt.Errorf("unexpected issuer %q", tokenInfo.Issuer)
+	}
+	clientID := l.provider.cfg.ClientID
+	if !slices.Contains(tokenInfo.Audience, clientID) {
+		return nil, fmt.Errorf("audience %v does not contain %q", []string(tokenInfo.Audience), clientID)
+	}
+	if len(tokenInfo.Audience) > 1 && tokenInfo.AuthorizedParty != clientID {
+		return nil, fmt.Errorf("unexpected azp %q", tokenInfo.AuthorizedParty)
+	}
+	tokenNonce, _ := tokenInfo.Claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce mismatch")
+	}
+	return tokenInfo, nil
+}
+
+// logout ferme la session puis redirige vers le end_session_endpoint du
+// fournisseur pour fermer aussi sa session (RP-initiated logout), ou à
+// défaut vers une page locale
+func (l *browserLogin) logout(c *gin.Context) {
+	var idToken string
+	if id, ok := l.sessionID(c); ok {
+		if session, err := l.sessions.Get(c.Request.Context(), id); err == nil {
+			idToken = session.IDToken
+		}
+	}
+	l.endSession(c)
+
+	endSessionURL := l.provider.endpoints().EndSessionURL
+	if endSessionURL == "" {
+		c.Redirect(http.StatusFound, l.localRedirect(c.Query("redirect")))
+		return
+	}
+	logoutURL, err := url.Parse(endSessionURL)
+	if err != nil {
+		log.Printf("end_session_endpoint invalide %q: %v", endSessionURL, err)
+		c.Redirect(http.StatusFound, l.localRedirect(c.Query("redirect")))
+		return
+	}
+	query := logoutURL.Query()
+	query.Set("client_id", l.provider.cfg.ClientID)
+	if idToken != "" {
+		query.Set("id_token_hint", idToken)
+	}
+	if l.cfg.PostLogoutRedirectURL != "" {
+		query.Set("post_logout_redirect_uri", l.cfg.PostLogoutRedirectURL)
+	}
+	logoutURL.RawQuery = query.Encode()
+	c.Redirect(http.StatusFound, logoutURL.String())
+}
+
+// endSession supprime la session du navigateur et son cookie
+func (l *browserLogin) endSession(c *gin.Context) {
+	if id, ok := l.sessionID(c); ok {
+		l.deleteSession(c.Request.Context(), id)
+	}
+	l.setCookie(c, sessionCookie, "", "/", -1, l.sameSite)
+}
+
+// deleteSession supprime une session du stockage
+func (l *browserLogin) deleteSession(ctx context.Context, id string) {
+	if err := l.sessions.Delete(ctx, id); err != nil {
+		log.Printf("Erreur de suppression de la session: %v", err)
+	}
+}
+
+// sessionID retourne l'identifiant déchiffré du cookie de session
+func (l *browserLogin) sessionID(c *gin.Context) (string, bool) {
+	cookie, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	var id string
+	if err := l.codec.decode(sessionCookie, cookie, &id); err != nil || id == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// sessionToken retourne l'access token de la session du navigateur, rafraîchi
+// s'il arrive à expiration ; la session est conservée dans le contexte
+func (l *browserLogin) sessionToken(c *gin.Context) (string, bool) {
+	if value, ok := c.Get("session"); ok {
+		return value.(*Session).AccessToken, true
+	}
+	id, ok := l.sessionID(c)
+	if !ok {
+		return "", false
+	}
+	session, err := l.sessions.Get(c.Request.Context(), id)
+	if err != nil {
+		if !errors.Is(err, errSessionNotFound) {
+			log.Printf("Erreur de lecture de la session: %v", err)
+		}
+		return "", false
+	}
+	if l.needsRefresh(session) {
+		if session, err = l.refresh(c.Request.Context(), session); err != nil {
+			return "", false
+		}
+	}
+	c.Set("session", session)
+	return session.AccessToken, true
+}
+
+// needsRefresh indique si l'access token de la session doit être rafraîchi
+func (l *browserLogin) needsRefresh(session *Session) bool {
+	return session.RefreshToken != "" && !session.TokenExpiry.IsZero() &&
+		time.Until(session.TokenExpiry) < l.refreshBefore
+}
+
+// refresh obtient un nouvel access token avec le refresh token de la session.
+// Les rafraîchissements concurrents d'une même session ne font qu'un appel,
+// le refresh token pouvant être à usage unique. En cas d'échec, le token
+// courant reste utilisé tant qu'il est valide ; une session dont le refresh
+// token est refusé est supprimée.
+func (l *browserLogin) refresh(ctx context.Context, session *Session) (*Session, error) {
+	result, err, _ := l.refreshes.Do(session.ID, func() (any, error) {
+		ctx := context.WithValue(context.WithoutCancel(ctx), oauth2.HTTPClient, l.client)
+		// Une autre requête a pu rafraîchir la session entre-temps
+		current, err := l.sessions.Get(ctx, session.ID)
+		if err != nil || !l.needsRefresh(current) {
+			return current, err
+		}
+		token, err := l.oauth2Config().TokenSource(ctx, &oauth2.Token{RefreshToken: current.RefreshToken}).Token()
+		if err != nil {
+			var retrieveErr *oauth2.RetrieveError
+			if errors.As(err, &retrieveErr) {
+				l.deleteSession(ctx, current.ID)
+			} else if time.Now().Before(current.TokenExpiry) {
+				log.Printf("Erreur de rafraîchissement du token, token courant conservé: %v", err)
+				return current, nil
+			}
+			return nil, fmt.Errorf("failed to refresh token: %w", err)
+		}
+		current.AccessToken = token.AccessToken
+		current.TokenExpiry = token.Expiry
+		if token.RefreshToken != "" {
+			current.RefreshToken = token.RefreshToken
+		}
+		if idToken, ok := token.Extra("id_token").(string); ok && idToken != "" {
+			current.IDToken = idToken
+		}
+		if err := l.sessions.Save(ctx, current); err != nil {
+			return nil, err
+		}
+		return current, nil
+	})
+	if err != nil {
+		log.Printf("Session non rafraîchie: %v", err)
+		return nil, err
+	}
+	return result.(*Session), nil
+}
+
+// redirectToLogin renvoie un navigateur non authentifié vers /login
+func (l *browserLogin) redirectToLogin(c *gin.Context) {
+	c.Redirect(http.StatusFound, "/login?redirect="+url.QueryEscape(c.Request.URL.RequestURI()))
+	c.Abort()
+}
+
+// localRedirect n'accepte que les chemins locaux, pour éviter les redirections ouvertes
+func (l *browserLogin) localRedirect(target string) string {
+	if isLocalPath(target) {
+		return target
+	}
+	if l.cfg.DefaultRedirect != "" {
+		return l.cfg.DefaultRedirect
+	}
+	return defaultLoginRedirect
+}
+
+// isLocalPath indique si target désigne un chemin du gateway : ni schéma ni
+// hôte, un seul slash initial et aucun caractère de contrôle ou antislash,
+// que les navigateurs suppriment ou lisent comme un slash
+func isLocalPath(target string) bool {
+	if strings.ContainsFunc(target, func(r rune) bool { return unicode.IsControl(r) || r == '\\' }) {
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || u.Opaque != "" {
+		return false
+	}
+	return strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//")
+}
+
+// setCookie dépose un cookie HttpOnly ; maxAge négatif supprime le cookie.
+// Le cookie de login doit être SameSite=Lax pour être envoyé au retour du
+// fournisseur d'identité.
+func (l *browserLogin) setCookie(c *gin.Context, name, value, path string, maxAge int, sameSite http.SameSite) {
+	c.SetSameSite(sameSite)
+	c.SetCookie(name, value, maxAge, path, "", l.secure, true)
+}
+
+// wantsHTML indique si la requête provient d'une navigation de navigateur
+func wantsHTML(c *gin.Context) bool {
+	return c.Request.Method == http.MethodGet && strings.Contains(c.GetHeader("Accept"), "text/html")
+}
+
+// loginError répond une erreur du flow de login
+func loginError(c *gin.Context, status int, message string) {
+	c.JSON(status, gin.H{
+		"error":   http.StatusText(status),
+		"message": message,
+	})
+	c.Abort()
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestLocalRedirect(t *testing.T) {
+	decoded := func(raw string) string {
+		value, err := url.QueryUnescape(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{name: "local path", target: "/orders", want: "/orders"},
+		{name: "local path with query", target: "/ok?x=1#top", want: "/ok?x=1#top"},
+		{name: "encoded slash kept encoded", target: "/a%2Fb", want: "/a%2Fb"},
+		{name: "empty", target: "", want: "/home"},
+		{name: "relative", target: "orders", want: "/home"},
+		{name: "scheme relative", target: "//evil.com", want: "/home"},
+		{name: "absolute", target: "https://evil.com/", want: "/home"},
+		{name: "javascript", target: "javascript:alert(1)", want: "/home"},
+		{name: "backslash", target: `/\evil.com`, want: "/home"},
+		{name: "backslash later", target: `/a/..\..\evil.com`, want: "/home"},
+		{name: "tab", target: decoded("%2F%09%2Fevil.com"), want: "/home"},
+		{name: "newline", target: decoded("%2F%0A%2Fevil.com"), want: "/home"},
+		{name: "carriage return", target: "/\r/evil.com", want: "/home"},
+		{name: "nul", target: "/\x00/evil.com", want: "/home"},
+		{name: "unicode control", target: "/\u0085/evil.com", want: "/home"},
+		{name: "invalid escape", target: "/%zz", want: "/home"},
+	}
+	l := &browserLogin{cfg: config.Login{DefaultRedirect: "/home"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := l.localRedirect(tt.target); got != tt.want {
+				t.Errorf("localRedirect(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+
+	if got := (&browserLogin{}).localRedirect("//evil.com"); got != defaultLoginRedirect {
+		t.Errorf("localRedirect without default = %q, want %q", got, defaultLoginRedirect)
+	}
+}
+
+func TestSessionTokenOnlyForAuthenticatingRoutes(t *testing.T) {
+	issuer := newTestIssuer(t)
+	backend, received := newTestBackend(t)
+	var refreshes atomic.Int32
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes.Add(1)
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+	}))
+	t.Cleanup(tokenEndpoint.Close)
+
+	s, gateway := newTestGateway(t, loginConfig(testConfig(issuer,
+		config.Route{Path: "/api/public", Target: backend.URL, Auth: authNone},
+		config.Route{Path: "/api/private", Target: backend.URL, Auth: authRequired},
+	), tokenEndpoint.URL))
+	accessToken := issuer.token(t, "alice", nil)
+	cookieHeader := func(session *http.Cookie) http.Header {
+		return http.Header{"Cookie": {session.String() + "; theme=dark"}}
+	}
+
+	// Session dont le token arrive à expiration : une route publique ne doit
+	// ni la rafraîchir ni transmettre son token
+	expiring := newTestSession(t, s, &Session{
+		ID:           "expiring",
+		Subject:      "alice",
+		AccessToken:  accessToken,
+		RefreshToken: "refresh-token",
+		TokenExpiry:  time.Now().Add(time.Second),
+	})
+	if resp := doRequest(t, gateway, http.MethodGet, "/api/public", cookieHeader(expiring)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("public route: status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	request, ok := lastRequest(received)
+	if !ok {
+		t.Fatal("public route did not reach the backend")
+	}
+	if authorization := request.header.Get("Authorization"); authorization != "" {
+		t.Errorf("public route forwarded Authorization %q", authorization)
+	}
+	if cookie := request.header.Get("Cookie"); cookie != "theme=dark" {
+		t.Errorf("public route forwarded Cookie %q, want theme=dark", cookie)
+	}
+	if n := refreshes.Load(); n != 0 {
+		t.Errorf("public route refreshed the session %d times", n)
+	}
+
+	// Une route authentifiée reçoit le token de la session à la place du cookie
+	active := newTestSession(t, s, &Session{
+		ID:          "active",
+		Subject:     "alice",
+		AccessToken: accessToken,
+		TokenExpiry: time.Now().Add(time.Hour),
+	})
+	if resp := doRequest(t, gateway, http.MethodGet, "/api/private", cookieHeader(active)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("private route: status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if request, ok = lastRequest(received); !ok {
+		t.Fatal("private route did not reach the backend")
+	}
+	if authorization := request.header.Get("Authorization"); authorization != "Bearer "+accessToken {
+		t.Errorf("private route forwarded Authorization %q, want the session token", authorization)
+	}
+	if cookie := request.header.Get("Cookie"); cookie != "theme=dark" {
+		t.Errorf("private route forwarded Cookie %q, want theme=dark", cookie)
+	}
+}
+
+func TestLogoutRequiresPost(t *testing.T) {
+	issuer := newTestIssuer(t)
+	s, gateway := newTestGateway(t, loginConfig(testConfig(issuer), "http://issuer.test/token"))
+
+	// Un lien ou une image d'un autre site ne déconnecte pas l'utilisateur
+	session := newTestSession(t, s, &Session{ID: "get-logout", Subject: "alice"})
+	resp := doRequest(t, gateway, http.MethodGet, "/logout", http.Header{"Cookie": {session.String()}})
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /logout: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	if _, err := s.login.sessions.Get(t.Context(), "get-logout"); err != nil {
+		t.Errorf("GET /logout deleted the session: %v", err)
+	}
+
+	session = newTestSession(t, s, &Session{ID: "post-logout", Subject: "alice"})
+	doRequest(t, gateway, http.MethodPost, "/logout", http.Header{"Cookie": {session.String()}})
+	if _, err := s.login.sessions.Get(t.Context(), "post-logout"); err == nil {
+		t.Error("POST /logout kept the session")
+	}
+}
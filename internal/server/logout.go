@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// backchannelLogoutEvent identifie un logout token (OpenID Connect Back-Channel Logout 1.0)
+	backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+	// defaultBackchannelLogoutPath reçoit les logout tokens du fournisseur
+	defaultBackchannelLogoutPath = "/backchannel-logout"
+	// logoutRetention borne la mémoire des déconnexions : au-delà, les access
+	// tokens émis avant la déconnexion ont expiré
+	logoutRetention = 24 * time.Hour
+	// logoutTokenType est le type explicite d'un logout token (en-tête typ)
+	logoutTokenType = "logout+jwt"
+)
+
+// errLoggedOut refuse un token dont la session a été fermée chez le fournisseur
+var errLoggedOut = errors.New("session fermée par le fournisseur d'identité")
+
+// logoutMatches indique si une déconnexion du fournisseur (sub, sid) vise
+// une session ou un token de l'utilisateur subject et de la session OIDC
+// sessionSID : la session sid si elle est précisée, sinon toutes celles de sub
+func logoutMatches(subject, sessionSID, sub, sid string) bool {
+	if sid != "" {
+		return sessionSID == sid && (sub == "" || subject == sub)
+	}
+	return sub != "" && subject == sub
+}
+
+// tokenSID retourne la session OIDC du token (claim sid)
+func tokenSID(tokenInfo *TokenInfo) string {
+	sid, _ := tokenInfo.Claims["sid"].(string)
+	return sid
+}
+
+// logoutRegistry mémorise les déconnexions back-channel pour refuser les
+// access tokens encore valides émis avant elles, ainsi que les logout tokens
+// reçus pour en refuser le rejeu
+type logoutRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]time.Time
+	subjects map[string]time.Time
+	// tokens associe le jti de chaque logout token reçu à son expiration
+	tokens map[string]time.Time
+}
+
+// newLogoutRegistry crée un registre de déconnexions vide
+func newLogoutRegistry() *logoutRegistry {
+	return &logoutRegistry{
+		sessions: make(map[string]time.Time),
+		subjects: make(map[string]time.Time),
+		tokens:   make(map[string]time.Time),
+	}
+}
+
+// firstUse enregistre le jti d'un logout token jusqu'à son expiration et
+// indique s'il n'avait pas déjà été reçu
+func (r *logoutRegistry) firstUse(jti string, expiresAt time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for key, tokenExpiry := range r.tokens {
+		if now.After(tokenExpiry) {
+			delete(r.tokens, key)
+		}
+	}
+	if _, ok := r.tokens[jti]; ok {
+		return false
+	}
+	r.tokens[jti] = expiresAt
+	return true
+}
+
+// add enregistre la déconnexion de la session sid ou, sans sid, de toutes
+// les sessions de sub
+func (r *logoutRegistry) add(sub, sid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, entries := range []map[string]time.Time{r.sessions, r.subjects} {
+		for key, loggedOutAt := range entries {
+			if now.Sub(loggedOutAt) > logoutRetention {
+				delete(entries, key)
+			}
+		}
+	}
+	if sid != "" {
+		r.sessions[sid] = now
+	} else {
+		// iat est exprimé en secondes : un token émis dans la seconde de la
+		// déconnexion reste accepté
+		r.subjects[sub] = now.Truncate(time.Second)
+	}
+}
+
+// revoked indique si le token appartient à une session déconnectée ; les
+// tokens de l'utilisateur émis après sa déconnexion restent acceptés. Sans
+// iat, la date d'émission est inconnue et seule la session sid est vérifiée ;
+// la validation userinfo, qui ne fournit pas d'iat, interroge de toute façon
+// le fournisseur à chaque requête.
+func (r *logoutRegistry) revoked(tokenInfo *TokenInfo) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sid := tokenSID(tokenInfo); sid != "" {
+		if _, ok := r.sessions[sid]; ok {
+			return true
+		}
+	}
+	if tokenInfo.IssuedAt == 0 {
+		return false
+	}
+	loggedOutAt, ok := r.subjects[tokenInfo.Sub]
+	return ok && time.Unix(tokenInfo.IssuedAt, 0).Before(loggedOutAt)
+}
+
+// logoutClaims sont les claims d'un logout token vérifié
+type logoutClaims struct {
+	sub string
+	sid string
+	jti string
+	// expiresAt borne la conservation du jti, au-delà le token est refusé
+	expiresAt time.Time
+}
+
+// verifyLogoutToken vérifie un logout token et retourne l'utilisateur et la
+// session OIDC déconnectés, avec le jti qui permet d'en refuser le rejeu
+func (l *browserLogin) verifyLogoutToken(ctx context.Context, logoutToken string) (*logoutClaims, error) {
+	tokenInfo, err := l.idTokens.verify(ctx, logoutToken)
+	if err != nil {
+		return nil, err
+	}
+	// Le type explicite distingue un logout token d'un id_token (RFC 8725 §3.11)
+	token, err := parseJWT(logoutToken)
+	if err != nil {
+		return nil, err
+	}
+	if typ := token.header.Typ; typ != "" && !strings.EqualFold(typ, logoutTokenType) && !strings.EqualFold(typ, "application/"+logoutTokenType) {
+		return nil, fmt.Errorf("unexpected token type %q", typ)
+	}
+	if l.provider.cfg.Issuer != "" && !l.provider.matchesIssuer(tokenInfo.Issuer) {
+		return nil, fmt.Errorf("unexpected issuer %q", tokenInfo.Issuer)
+	}
+	clientID := l.provider.cfg.ClientID
+	if !slices.Contains(tokenInfo.Audience, clientID) {
+		return nil, fmt.Errorf("audience %v does not contain %q", []string(tokenInfo.Audience), clientID)
+	}
+	if tokenInfo.IssuedAt == 0 {
+		return nil, errors.New("missing iat claim")
+	}
+	events, _ := tokenInfo.Claims["events"].(map[string]any)
+	if _, ok := events[backchannelLogoutEvent].(map[string]any); !ok {
+		return nil, errors.New("missing back-channel logout event")
+	}
+	if _, ok := tokenInfo.Claims["nonce"]; ok {
+		return nil, errors.New("logout token must not contain a nonce")
+	}
+	jti, _ := tokenInfo.Claims["jti"].(string)
+	if jti == "" {
+		return nil, errors.New("missing jti claim")
+	}
+	sid := tokenSID(tokenInfo)
+	if tokenInfo.Sub == "" && sid == "" {
+		return nil, errors.New("logout token requires sub or sid")
+	}
+	return &logoutClaims{
+		sub:       tokenInfo.Sub,
+		sid:       sid,
+		jti:       jti,
+		expiresAt: time.Unix(tokenInfo.Expiration, 0).Add(l.idTokens.leeway),
+	}, nil
+}
+
+// backchannelLogout reçoit un logout token du fournisseur d'identité et
+// invalide immédiatement les sessions et les validations en cache visées
+func (s *proxyServer) backchannelLogout(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	logoutToken := c.PostForm("logout_token")
+	if logoutToken == "" {
+		logoutError(c, "logout_token manquant")
+		return
+	}
+	claims, err := s.login.verifyLogoutToken(c.Request.Context(), logoutToken)
+	if err != nil {
+		log.Printf("Logout token refusé: %v", err)
+		logoutError(c, "logout_token invalide")
+		return
+	}
+	if !s.logouts.firstUse(claims.jti, claims.expiresAt) {
+		log.Printf("Logout token refusé: jti %q déjà reçu", claims.jti)
+		logoutError(c, "logout_token déjà reçu")
+		return
+	}
+
+	sub, sid := claims.sub, claims.sid
+	s.logouts.add(sub, sid)
+	sessions, err := s.login.sessions.DeleteLoggedOut(c.Request.Context(), sub, sid)
+	if err != nil {
+		log.Printf("Erreur de suppression des sessions déconnectées: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	cached := 0
+	if s.tokenCache != nil {
+		cached = s.tokenCache.invalidate(func(tokenInfo *TokenInfo) bool {
+			return logoutMatches(tokenInfo.Sub, tokenSID(tokenInfo), sub, sid)
+		})
+	}
+	log.Printf("Déconnexion back-channel (sub=%q, sid=%q): %d session(s) et %d validation(s) en cache supprimées",
+		sub, sid, sessions, cached)
+	c.Status(http.StatusOK)
+}
+
+// logoutError refuse un logout token (OpenID Connect Back-Channel Logout 1.0 §2.8)
+func logoutError(c *gin.Context, description string) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":             "invalid_request",
+		"error_description": description,
+	})
+	c.Abort()
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// postLogoutToken envoie un logout token au point de déconnexion back-channel
+func postLogoutToken(t *testing.T, gatewayURL, logoutToken string) int {
+	t.Helper()
+	resp, err := http.PostForm(gatewayURL+defaultBackchannelLogoutPath, url.Values{"logout_token": {logoutToken}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestBackchannelLogoutToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	_, gateway := newTestGateway(t, loginConfig(testConfig(issuer), "http://issuer.test/token"))
+
+	logoutToken := func(typ, jti string) string {
+		header := map[string]any{"alg": "RS256", "kid": testKID}
+		if typ != "" {
+			header["typ"] = typ
+		}
+		now := time.Now()
+		claims := map[string]any{
+			"sub":    "alice",
+			"aud":    "gateway",
+			"iat":    now.Unix(),
+			"exp":    now.Add(2 * time.Minute).Unix(),
+			"events": map[string]any{backchannelLogoutEvent: map[string]any{}},
+		}
+		if jti != "" {
+			claims["jti"] = jti
+		}
+		return issuer.sign(t, header, claims)
+	}
+	replayed := logoutToken(logoutTokenType, "replayed")
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{name: "logout token", token: replayed, want: http.StatusOK},
+		{name: "replay", token: replayed, want: http.StatusBadRequest},
+		{name: "reused jti", token: logoutToken(logoutTokenType, "replayed"), want: http.StatusBadRequest},
+		{name: "media type", token: logoutToken("application/logout+jwt", "media-type"), want: http.StatusOK},
+		{name: "upper case type", token: logoutToken("Logout+JWT", "upper-case"), want: http.StatusOK},
+		{name: "without type", token: logoutToken("", "untyped"), want: http.StatusOK},
+		{name: "id token type", token: logoutToken("JWT", "id-token"), want: http.StatusBadRequest},
+		{name: "access token type", token: logoutToken("at+jwt", "access-token"), want: http.StatusBadRequest},
+		{name: "missing jti", token: logoutToken(logoutTokenType, ""), want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := postLogoutToken(t, gateway.URL, tt.token); status != tt.want {
+				t.Errorf("status %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogoutRegistryForgetsExpiredTokens(t *testing.T) {
+	registry := newLogoutRegistry()
+	if !registry.firstUse("a", time.Now().Add(time.Minute)) {
+		t.Fatal("first use of a refused")
+	}
+	if registry.firstUse("a", time.Now().Add(time.Minute)) {
+		t.Error("replay of a accepted")
+	}
+	registry.firstUse("expired", time.Now().Add(-time.Second))
+	registry.firstUse("b", time.Now().Add(time.Minute))
+	if _, ok := registry.tokens["expired"]; ok || len(registry.tokens) != 2 {
+		t.Errorf("registry keeps %d jti, want a and b", len(registry.tokens))
+	}
+}
+
+func TestLogoutRegistryRevoked(t *testing.T) {
+	registry := newLogoutRegistry()
+	registry.add("", "session-1")
+	registry.add("alice", "")
+	loggedOutAt := registry.subjects["alice"]
+
+	tests := []struct {
+		name      string
+		tokenInfo *TokenInfo
+		want      bool
+	}{
+		{name: "logged out session", tokenInfo: &TokenInfo{Sub: "bob", Claims: map[string]any{"sid": "session-1"}}, want: true},
+		{name: "other session", tokenInfo: &TokenInfo{Sub: "bob", IssuedAt: 1, Claims: map[string]any{"sid": "session-2"}}},
+		{name: "issued before the logout", tokenInfo: &TokenInfo{Sub: "alice", IssuedAt: loggedOutAt.Add(-time.Second).Unix()}, want: true},
+		{name: "issued in the same second", tokenInfo: &TokenInfo{Sub: "alice", IssuedAt: loggedOutAt.Unix()}},
+		{name: "issued after the logout", tokenInfo: &TokenInfo{Sub: "alice", IssuedAt: loggedOutAt.Add(time.Second).Unix()}},
+		{name: "unknown issue date", tokenInfo: &TokenInfo{Sub: "alice"}},
+		{name: "other subject", tokenInfo: &TokenInfo{Sub: "bob", IssuedAt: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := registry.revoked(tt.tokenInfo); got != tt.want {
+				t.Errorf("revoked() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackchannelLogoutKeepsUserInfoTokens(t *testing.T) {
+	userinfo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer opaque-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{"sub":"alice","email":"alice@corp.com"}`)
+	}))
+	t.Cleanup(userinfo.Close)
+
+	issuer := newTestIssuer(t)
+	backend, _ := newTestBackend(t)
+	cfg := loginConfig(testConfig(issuer,
+		config.Route{Path: "/api", Target: backend.URL, Auth: authRequired},
+	), "http://issuer.test/token")
+	cfg.Server.OAuth2.Provider.Endpoints.UserInfoURL = userinfo.URL
+	cfg.Server.OAuth2.Provider.Validators = []string{validatorUserInfo}
+	_, gateway := newTestGateway(t, cfg)
+
+	now := time.Now()
+	logoutToken := issuer.sign(t, map[string]any{"alg": "RS256", "kid": testKID, "typ": logoutTokenType}, map[string]any{
+		"sub":    "alice",
+		"aud":    "gateway",
+		"iat":    now.Unix(),
+		"exp":    now.Add(2 * time.Minute).Unix(),
+		"jti":    "alice-logout",
+		"events": map[string]any{backchannelLogoutEvent: map[string]any{}},
+	})
+	if status := postLogoutToken(t, gateway.URL, logoutToken); status != http.StatusOK {
+		t.Fatalf("back-channel logout: status %d", status)
+	}
+
+	// userinfo ne fournit pas d'iat : la déconnexion de l'utilisateur ne doit
+	// pas refuser ses tokens, que le fournisseur valide lui-même
+	if resp := doRequest(t, gateway, http.MethodGet, "/api", bearer("opaque-token")); resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -34,4 +34,7 @@ func (s *proxyServer) addMiddlewares() {
 	// Recovery middleware to recover from panics
 	s.engine.Use(gin.Recovery())
 
+	// Browser login endpoints (/login, callback, /logout), registered last so
+	// that the global middlewares above, CSRF included, apply to them
+	s.addLoginRoutes()
 }
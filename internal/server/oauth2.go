@@ -1,8 +1,10 @@
 package server
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -10,82 +12,226 @@ import (
 	ginoauth2 "github.com/zalando/gin-oauth2"
 )
 
+// Modes d'authentification d'une route
+const (
+	authRequired = "required"
+	authOptional = "optional"
+	authNone     = "none"
+)
+
+// Modes de correspondance des teams d'une route
+const (
+	matchAny = "any"
+	matchAll = "all"
+)
+
 // Modification de addOAuth2Middleware pour gérer les routes publiques/privées
 func (s *proxyServer) addOAuth2Middleware() {
 	s.engine.Use(ginoauth2.RequestLogger([]string{"uid"}, "data"))
 	// Middleware d'extraction de token pour toutes les routes
 	s.engine.Use(s.tokenExtractionMiddleware())
+	// Protection CSRF, une fois la session du navigateur chargée
+	if s.csrf != nil {
+		s.engine.Use(s.csrf.middleware())
+	}
+
+	for _, compiled := range s.routes {
+		route := compiled.cfg
 
-	for _, route := range s.cfg.Routes {
-		routeGroup := s.engine.Group(route.Path)
+		// Les méthodes non listées par les règles sont refusées avant toute validation
+		if len(route.Rules) > 0 {
+			compiled.use(s.methodRulesMiddleware(route))
+		}
 
-		// Appliquer OAuth2 seulement si des teams sont configurées
-		if len(route.Teams) > 0 {
-			log.Printf("Protecting route %s with teams: %+v", route.Path, route.Teams)
-			routeGroup.Use(s.oauth2Middleware(route.Teams))
-		} else {
+		// Le token n'est validé qu'une seule fois, par la route, puis
+		// réutilisé depuis le contexte pour les contrôles d'autorisation
+		switch routeAuthMode(route) {
+		case authRequired:
+			log.Printf("Protecting route %s with teams: %+v, roles: %v, client roles: %+v, scopes: %v, policy: %q, path params: %+v, method rules: %+v",
+				route.Path, route.Teams, route.Roles, route.ClientRoles, route.Scopes, route.Policy, route.Params, route.Rules)
+			compiled.use(s.authenticationMiddleware(compiled), s.oauth2Middleware(route))
+		case authOptional:
 			log.Printf("Public route: %s", route.Path)
-			routeGroup.Use(s.publicMiddleware())
+			compiled.use(s.authenticationMiddleware(compiled), s.oauth2Middleware(route))
+		case authNone:
+			log.Printf("Public route without token validation: %s", route.Path)
+			compiled.use(s.publicMiddleware())
+		}
+
+		// Isolation des tenants, une fois le token validé
+		if route.Tenancy.Claim != "" {
+			log.Printf("Tenant isolation for route %s with claim: %s", route.Path, route.Tenancy.Claim)
+			compiled.use(s.tenancyMiddleware(route))
+		}
+
+		// Décision du service d'autorisation externe, une fois le token validé
+		if route.ExtAuthz.URL != "" {
+			log.Printf("External authorization for route %s: %s", route.Path, route.ExtAuthz.URL)
+			compiled.use(s.extAuthzMiddleware(route))
 		}
 
-		routeGroup.Any("", s.proxy)
+		compiled.use(s.proxy)
+	}
+	s.engine.NoRoute(s.dispatch)
+}
+
+// routeAuthMode retourne le mode d'authentification de la route : par défaut
+// un token est requis si des exigences d'autorisation sont configurées,
+// optionnel sinon
+func routeAuthMode(route config.Route) string {
+	if route.Auth != "" {
+		return route.Auth
+	}
+	if hasAccessRules(route) {
+		return authRequired
+	}
+	return authOptional
+}
+
+// teamMatch retourne le mode de correspondance des teams (any par défaut)
+func teamMatch(rule config.AccessRule) string {
+	if rule.Match == "" {
+		return matchAny
+	}
+	return rule.Match
+}
+
+// methodRulesMiddleware refuse (405) les méthodes non listées par les règles de la route
+func (s *proxyServer) methodRulesMiddleware(route config.Route) gin.HandlerFunc {
+	allow := strings.Join(allowedMethods(route), ", ")
+	return func(c *gin.Context) {
+		if _, ok := methodRule(route, c.Request.Method); !ok {
+			c.Header("Allow", allow)
+			c.JSON(http.StatusMethodNotAllowed, gin.H{
+				"error":   "Method Not Allowed",
+				"message": "Méthode non autorisée sur cette route",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
 	}
 }
 
-// oauth2Middleware avec extraction et propagation des informations du token
-func (s *proxyServer) oauth2Middleware(teams []config.Team) gin.HandlerFunc {
+// authenticationMiddleware valide le token de la requête avec la chaîne de la
+// route et stocke le TokenInfo dans le contexte. Si aucune exigence ne
+// s'applique à la méthode, une requête sans token est acceptée mais un token
+// invalide reste rejeté.
+func (s *proxyServer) authenticationMiddleware(compiled *compiledRoute) gin.HandlerFunc {
+	route := compiled.cfg
+	validator := s.validatorFor(compiled)
+	trust := s.newTokenTrust(route)
 	return func(c *gin.Context) {
-		log.Printf("OAuth2 Middleware - Vérification du token")
+		// Token déjà validé pour cette requête
+		if value, ok := c.Get("tokenInfo"); ok {
+			if err := trust.check(value.(*TokenInfo)); err != nil {
+				rejectToken(c, err)
+				return
+			}
+			c.Next()
+			return
+		}
 
-		// Récupérer le token du header Authorization
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		tokenString, err := s.requestToken(c)
+		if err != nil {
+			if !requiresToken(route, c.Request.Method) {
+				c.Next()
+				return
+			}
+			// Un navigateur non authentifié est invité à se connecter
+			if s.login != nil && wantsHTML(c) {
+				s.login.redirectToLogin(c)
+				return
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error":   "Unauthorized",
-				"message": "Token d'accès manquant",
+				"message": err.Error(),
 			})
 			c.Abort()
 			return
 		}
 
-		if !strings.HasPrefix(authHeader, "Bearer ") {
+		// Extraire les informations du token
+		tokenInfo, err := validator.Validate(c.Request.Context(), tokenString)
+		if err != nil {
+			log.Printf("Erreur d'extraction du token: %v", err)
+			if s.login != nil && wantsHTML(c) {
+				s.login.redirectToLogin(c)
+				return
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error":   "Unauthorized",
-				"message": "Format de token invalide",
+				"message": "Token invalide ou expiré",
 			})
 			c.Abort()
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		// Vérifier l'émetteur et le destinataire du token
+		if err := trust.check(tokenInfo); err != nil {
+			log.Printf("Token refusé: %v", err)
+			rejectToken(c, err)
+			return
+		}
+		if s.logouts != nil && s.logouts.revoked(tokenInfo) {
+			log.Printf("Token refusé: session %q de %s déconnectée par le fournisseur", tokenSID(tokenInfo), tokenInfo.Sub)
+			rejectToken(c, errLoggedOut)
+			return
+		}
+
+		// Ajouter les informations du token dans les headers et le contexte
+		s.setTokenHeaders(c, tokenInfo, tokenString)
 
-		// Extraire les informations du token
-		tokenInfo, err := s.extractTokenInfo(c.Request.Context(), tokenString)
-		if err != nil {
-			log.Printf("Erreur d'extraction du token: %v", err)
+		log.Printf("Token validé pour: %s (%s)", tokenInfo.Name, tokenInfo.Email)
+		c.Next()
+	}
+}
+
+// rejectToken répond 401 en expliquant pourquoi le token est refusé (RFC 6750 §3)
+func rejectToken(c *gin.Context, err error) {
+	c.Header("WWW-Authenticate", fmt.Sprintf("Bearer error=\"invalid_token\", error_description=%q", err.Error()))
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"error":   "Unauthorized",
+		"message": err.Error(),
+	})
+	c.Abort()
+}
+
+// oauth2Middleware vérifie les autorisations à partir du TokenInfo déjà
+// validé, selon les exigences de la route et de la règle de la méthode
+func (s *proxyServer) oauth2Middleware(route config.Route) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get("tokenInfo")
+		if !ok {
+			if !requiresToken(route, c.Request.Method) {
+				log.Printf("Accès public à: %s", c.Request.URL.Path)
+				c.Next()
+				return
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error":   "Unauthorized",
-				"message": "Token invalide",
+				"message": "Token d'accès manquant",
 			})
 			c.Abort()
 			return
 		}
+		tokenInfo := value.(*TokenInfo)
 
-		// Vérifier les teams requises
-		if len(teams) > 0 && !s.hasRequiredTeams(tokenInfo, teams) {
-			log.Printf("Accès refusé - Teams insuffisantes")
+		// Vérifier les teams, rôles, scopes, politiques et paramètres de chemin requis
+		rules := accessRulesFor(route, c.Request.Method)
+		missing := s.checkAccessRules(c, tokenInfo, rules)
+		missing.Params = checkPathParams(c, tokenInfo, route.Params)
+		if !missing.empty() {
+			log.Printf("Accès refusé - Exigences non satisfaites: %+v", missing)
 			c.JSON(http.StatusForbidden, gin.H{
 				"error":   "Forbidden",
 				"message": "Accès non autorisé",
+				"missing": missing,
 			})
 			c.Abort()
 			return
 		}
 
-		// Ajouter les informations du token dans les headers
-		s.setTokenHeaders(c, tokenInfo, tokenString)
-
-		log.Printf("Token validé pour: %s (%s)", tokenInfo.Name, tokenInfo.Email)
 		c.Next()
 	}
 }
@@ -146,27 +292,35 @@ func (s *proxyServer) oauth2Middleware(teams []config.Team) gin.HandlerFunc {
 // 	return true
 // }
 
-// hasRequiredTeams vérifie si l'utilisateur appartient aux teams requises
-func (s *proxyServer) hasRequiredTeams(tokenInfo *TokenInfo, requiredTeams []config.Team) bool {
+// hasRequiredTeams vérifie si l'utilisateur appartient aux teams requises :
+// à au moins une d'entre elles (match "any") ou à toutes (match "all"). Une
+// liste vide n'exige rien ; un mode inconnu, refusé au démarrage, n'accorde
+// jamais l'accès.
+func (s *proxyServer) hasRequiredTeams(tokenInfo *TokenInfo, requiredTeams []config.Team, match string) bool {
 	// Cette implémentation dépend de la structure de votre token
 	// Adaptez-la selon comment les teams sont stockées dans le token
 
 	// Exemple: si les teams sont dans tokenInfo.Groups
 	tokenTeams := tokenInfo.Groups // ou tokenInfo.Teams selon votre structure
 
-	for _, requiredTeam := range requiredTeams {
-		found := false
-		for _, tokenTeam := range tokenTeams {
-			if tokenTeam == requiredTeam.Name {
-				found = true
-				break
+	if len(requiredTeams) == 0 {
+		return true
+	}
+	isMember := func(team config.Team) bool {
+		return slices.Contains(tokenTeams, team.Name)
+	}
+	switch match {
+	case matchAny:
+		return slices.ContainsFunc(requiredTeams, isMember)
+	case matchAll:
+		for _, requiredTeam := range requiredTeams {
+			if !isMember(requiredTeam) {
+				return false
 			}
 		}
-		if !found {
-			return false
-		}
+		return true
 	}
-	return true
+	return false
 }
 
 // Middleware simplifié pour les routes publiques
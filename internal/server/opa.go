@@ -0,0 +1,180 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/rego"
+)
+
+// maskedHeaders ne figurent jamais en clair dans les decision logs
+var maskedHeaders = []string{"authorization", "cookie", "proxy-authorization"}
+
+// loadRegoPolicies charge et compile les fichiers .rego du répertoire configuré
+func (s *proxyServer) loadRegoPolicies() error {
+	directory := s.cfg.Server.Rego.Directory
+	if directory == "" {
+		return nil
+	}
+	engine, err := rego.LoadDir(directory)
+	if err != nil {
+		return fmt.Errorf("rego: %w", err)
+	}
+	s.regoEngine = engine
+	log.Printf("Politiques Rego chargées depuis %s", directory)
+	return nil
+}
+
+// prepareRegoQueries vérifie au démarrage que les règles Rego référencées par
+// une route existent
+func (s *proxyServer) prepareRegoQueries(route config.Route) error {
+	queries := []string{route.Rego}
+	for _, rule := range route.Rules {
+		queries = append(queries, rule.Rego)
+	}
+	for _, path := range queries {
+		if path == "" {
+			continue
+		}
+		if _, ok := s.regoQueries[path]; ok {
+			continue
+		}
+		if s.regoEngine == nil {
+			return fmt.Errorf("rego rule %q requires server.rego.directory", path)
+		}
+		query, err := s.regoEngine.Query(path)
+		if err != nil {
+			return fmt.Errorf("rego: %w", err)
+		}
+		if s.regoQueries == nil {
+			s.regoQueries = make(map[string]*rego.Query)
+		}
+		s.regoQueries[path] = query
+	}
+	return nil
+}
+
+// checkRego évalue une règle Rego ; seule la valeur true autorise l'accès,
+// une règle indéfinie ou une erreur d'évaluation le refuse
+func (s *proxyServer) checkRego(c *gin.Context, tokenInfo *TokenInfo, path string) bool {
+	query, ok := s.regoQueries[path]
+	if !ok {
+		log.Printf("Règle Rego non préparée: %q", path)
+		return false
+	}
+
+	input := policyVariables(c, tokenInfo)
+	input["token"] = tokenDocument(tokenInfo)
+
+	start := time.Now()
+	result, defined, err := query.Eval(c.Request.Context(), input)
+	if s.cfg.Server.Rego.DecisionLogs {
+		logDecision(c, query, input, result, err, time.Since(start))
+	}
+	if err != nil {
+		log.Printf("Erreur d'évaluation de la règle Rego %s: %v", query, err)
+		return false
+	}
+	return defined && result == true
+}
+
+// tokenDocument présente le TokenInfo normalisé aux politiques Rego
+func tokenDocument(tokenInfo *TokenInfo) map[string]any {
+	clientRoles := make(map[string]any, len(tokenInfo.ResourceAccess))
+	for client, access := range tokenInfo.ResourceAccess {
+		clientRoles[client] = access.Roles
+	}
+	return map[string]any{
+		"sub":                tokenInfo.Sub,
+		"uid":                tokenInfo.UID,
+		"email":              tokenInfo.Email,
+		"name":               tokenInfo.Name,
+		"preferred_username": tokenInfo.PreferredUsername,
+		"groups":             tokenInfo.Groups,
+		"teams":              tokenInfo.Teams,
+		"roles":              tokenInfo.RealmAccess.Roles,
+		"client_roles":       clientRoles,
+		"scopes":             strings.Fields(tokenInfo.Scope),
+		"issuer":             tokenInfo.Issuer,
+		"audience":           []string(tokenInfo.Audience),
+		"azp":                tokenInfo.AuthorizedParty,
+		"client_id":          tokenInfo.ClientID,
+		"exp":                tokenInfo.Expiration,
+	}
+}
+
+// decisionLog reprend le format des decision logs d'OPA
+type decisionLog struct {
+	DecisionID string         `json:"decision_id"`
+	RequestID  string         `json:"request_id,omitempty"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Path       string         `json:"path"`
+	Input      map[string]any `json:"input"`
+	Result     any            `json:"result,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Metrics    map[string]any `json:"metrics"`
+}
+
+// logDecision journalise une évaluation Rego, en masquant les headers sensibles
+func logDecision(c *gin.Context, query *rego.Query, input map[string]any, result any, err error, duration time.Duration) {
+	entry := decisionLog{
+		DecisionID: newDecisionID(),
+		RequestID:  c.GetHeader("X-Request-ID"),
+		Timestamp:  time.Now().UTC(),
+		Path:       query.String(),
+		Input:      maskInput(input),
+		Result:     result,
+		Metrics:    map[string]any{"timer_rego_query_eval_ns": duration.Nanoseconds()},
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	data, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		log.Printf("Erreur d'encodage du decision log: %v", marshalErr)
+		return
+	}
+	log.Printf("decision_log %s", data)
+}
+
+// maskInput copie l'input en remplaçant la valeur des headers sensibles
+func maskInput(input map[string]any) map[string]any {
+	request, ok := input["request"].(map[string]any)
+	if !ok {
+		return input
+	}
+	headers, ok := request["headers"].(map[string]any)
+	if !ok {
+		return input
+	}
+
+	headersCopy := maps.Clone(headers)
+	for _, name := range maskedHeaders {
+		if _, ok := headersCopy[name]; ok {
+			headersCopy[name] = "***"
+		}
+	}
+	requestCopy := maps.Clone(request)
+	requestCopy["headers"] = headersCopy
+
+	masked := maps.Clone(input)
+	masked["request"] = requestCopy
+	return masked
+}
+
+// newDecisionID génère un identifiant aléatoire de décision
+func newDecisionID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// routeParams retourne les paramètres nommés (:name) d'un chemin
+func routeParams(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// declaredPathParams retourne les paramètres nommés du chemin d'une route :
+// ses segments :name, ou les groupes nommés de son expression régulière
+func declaredPathParams(route config.Route) []string {
+	if route.Match != pathMatchRegex {
+		return routeParams(route.Path)
+	}
+	pattern, err := regexp.Compile(route.Path)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, name := range pattern.SubexpNames() {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// expandTarget remplace les paramètres nommés du chemin de la cible par leur valeur
+func expandTarget(target string, params map[string]string) string {
+	if len(params) == 0 {
+		return target
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	segments := strings.Split(targetURL.Path, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			if value, found := params[name]; found {
+				segments[i] = value
+			}
+		}
+	}
+	targetURL.Path = strings.Join(segments, "/")
+	targetURL.RawPath = ""
+	return targetURL.String()
+}
+
+// validatePathParams vérifie au démarrage les paramètres nommés d'une route
+// et leurs contraintes
+func validatePathParams(route config.Route) error {
+	names := declaredPathParams(route)
+	if len(route.Params) > 0 && route.Auth == authNone {
+		return fmt.Errorf("path parameter constraints require token validation (auth %q)", route.Auth)
+	}
+	for _, param := range route.Params {
+		if !slices.Contains(names, param.Name) {
+			return fmt.Errorf("path parameter %q is not declared in the route path", param.Name)
+		}
+		if param.Claim == "" {
+			return fmt.Errorf("path parameter %q has no claim", param.Name)
+		}
+	}
+	if targetURL, err := url.Parse(route.Target); err == nil {
+		for _, name := range routeParams(targetURL.Path) {
+			if !slices.Contains(names, name) {
+				return fmt.Errorf("target parameter %q is not declared in the route path", name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkPathParams retourne les paramètres de chemin qui ne correspondent pas
+// aux claims du token
+func checkPathParams(c *gin.Context, tokenInfo *TokenInfo, params []config.PathParam) []string {
+	var missing []string
+	for _, param := range params {
+		value := c.Param(param.Name)
+		claim, ok := claimValue(tokenInfo, param.Claim)
+		if value == "" || !ok || !claimMatches(claim, value) {
+			missing = append(missing, param.Name)
+		}
+	}
+	return missing
+}
+
+// claimValue retourne la valeur d'un claim du token ; les claims imbriqués
+// sont séparés par des points. À défaut de claims bruts, le TokenInfo
+// normalisé est utilisé.
+func claimValue(tokenInfo *TokenInfo, claim string) (any, bool) {
+	claims := tokenInfo.Claims
+	if claims == nil {
+		claims = tokenDocument(tokenInfo)
+	}
+	var value any = claims
+	for _, key := range strings.Split(claim, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// claimMatches indique si la valeur d'un paramètre est égale au claim, ou
+// figure parmi ses valeurs si le claim est une liste
+func claimMatches(claim any, value string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == value
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64) == value
+	case bool:
+		return strconv.FormatBool(v) == value
+	case []string:
+		return slices.Contains(v, value)
+	case []any:
+		return slices.ContainsFunc(v, func(element any) bool {
+			switch element.(type) {
+			case []any, map[string]any:
+				return false
+			}
+			return claimMatches(element, value)
+		})
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestExpandTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		params map[string]string
+		want   string
+	}{
+		{name: "no params", target: "http://backend/users/:id", want: "http://backend/users/:id"},
+		{name: "one param", target: "http://backend/users/:id/orders", params: map[string]string{"id": "42"}, want: "http://backend/users/42/orders"},
+		{name: "several params", target: "http://backend/:tenant/users/:id", params: map[string]string{"tenant": "acme", "id": "42"}, want: "http://backend/acme/users/42"},
+		{name: "undeclared param kept", target: "http://backend/users/:id", params: map[string]string{"other": "42"}, want: "http://backend/users/:id"},
+		{name: "only whole segments", target: "http://backend/users/x:id", params: map[string]string{"id": "42"}, want: "http://backend/users/x:id"},
+		{name: "space", target: "http://backend/users/:id", params: map[string]string{"id": "alice smith"}, want: "http://backend/users/alice%20smith"},
+		{name: "query delimiter", target: "http://backend/users/:id", params: map[string]string{"id": "a?admin=1"}, want: "http://backend/users/a%3Fadmin=1"},
+		{name: "fragment delimiter", target: "http://backend/users/:id", params: map[string]string{"id": "a#b"}, want: "http://backend/users/a%23b"},
+		{name: "percent", target: "http://backend/users/:id", params: map[string]string{"id": "100%"}, want: "http://backend/users/100%25"},
+		{name: "unicode", target: "http://backend/users/:id", params: map[string]string{"id": "élodie"}, want: "http://backend/users/%C3%A9lodie"},
+		{name: "target query kept", target: "http://backend/users/:id?v=2", params: map[string]string{"id": "42"}, want: "http://backend/users/42?v=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expandTarget(tt.target, tt.params); got != tt.want {
+				t.Errorf("expandTarget() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePathParams(t *testing.T) {
+	sub := []config.PathParam{{Name: "userId", Claim: "sub"}}
+	tests := []struct {
+		name    string
+		route   config.Route
+		wantErr bool
+	}{
+		{name: "declared param", route: config.Route{Path: "/api/users/:userId", Target: "http://backend/users/:userId", Params: sub}},
+		{name: "regex named group", route: config.Route{Path: "^/api/users/(?P<userId>[a-z]+)$", Match: pathMatchRegex,
+			Target: "http://backend/users/:userId", Params: sub}},
+		{name: "undeclared param", route: config.Route{Path: "/api/users/:id", Params: sub}, wantErr: true},
+		{name: "param without claim", route: config.Route{Path: "/api/users/:userId", Params: []config.PathParam{{Name: "userId"}}}, wantErr: true},
+		{name: "auth none", route: config.Route{Path: "/api/users/:userId", Auth: authNone, Params: sub}, wantErr: true},
+		{name: "undeclared target param", route: config.Route{Path: "/api/users", Target: "http://backend/users/:userId"}, wantErr: true},
+		{name: "regex without the target param", route: config.Route{Path: "^/api/users/([a-z]+)$", Match: pathMatchRegex,
+			Target: "http://backend/users/:userId"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePathParams(tt.route); (err != nil) != tt.wantErr {
+				t.Errorf("validatePathParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClaimMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim any
+		value string
+		want  bool
+	}{
+		{name: "string", claim: "alice", value: "alice", want: true},
+		{name: "other string", claim: "alice", value: "bob"},
+		{name: "case sensitive", claim: "alice", value: "Alice"},
+		{name: "number", claim: float64(42), value: "42", want: true},
+		{name: "number format", claim: float64(42), value: "42.0"},
+		{name: "boolean", claim: true, value: "true", want: true},
+		{name: "list", claim: []any{"acme", "globex"}, value: "globex", want: true},
+		{name: "list of numbers", claim: []any{float64(1), float64(2)}, value: "2", want: true},
+		{name: "not in list", claim: []any{"acme"}, value: "globex"},
+		{name: "nested list", claim: []any{[]any{"acme"}}, value: "acme"},
+		{name: "object", claim: map[string]any{"acme": true}, value: "acme"},
+		{name: "null", claim: nil, value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := claimMatches(tt.claim, tt.value); got != tt.want {
+				t.Errorf("claimMatches(%v, %q) = %v, want %v", tt.claim, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// Les paramètres sont comparés aux claims une fois décodés, puis réencodés
+// dans la cible
+func TestPathParamsOnRoute(t *testing.T) {
+	issuer := newTestIssuer(t)
+	backend, received := newTestBackend(t)
+	_, gateway := newTestGateway(t, testConfig(issuer, config.Route{
+		Path:   "/api/users/:userId/orders",
+		Target: backend.URL + "/users/:userId/orders",
+		Params: []config.PathParam{{Name: "userId", Claim: "sub"}},
+	}))
+
+	tests := []struct {
+		name    string
+		sub     string
+		path    string
+		want    int
+		wantURI string
+	}{
+		{name: "own orders", sub: "alice", path: "/api/users/alice/orders/42", want: http.StatusOK, wantURI: "/users/alice/orders/42"},
+		{name: "other user", sub: "alice", path: "/api/users/bob/orders", want: http.StatusForbidden},
+		{name: "encoded value", sub: "alice smith", path: "/api/users/alice%20smith/orders", want: http.StatusOK, wantURI: "/users/alice%20smith/orders"},
+		{name: "encoded query delimiter", sub: "a?b", path: "/api/users/a%3Fb/orders", want: http.StatusOK, wantURI: "/users/a%3Fb/orders"},
+		{name: "encoded slash", sub: "alice/admin", path: "/api/users/alice%2Fadmin/orders", want: http.StatusBadRequest},
+		{name: "dot segment", sub: "..", path: "/api/users/%2e%2e/orders", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, gateway, http.MethodGet, tt.path, bearer(issuer.token(t, tt.sub, nil)))
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+			request, ok := lastRequest(received)
+			if ok != (tt.want == http.StatusOK) {
+				t.Fatalf("backend reached = %v", ok)
+			}
+			if ok && request.uri != tt.wantURI {
+				t.Errorf("forwarded as %s, want %s", request.uri, tt.wantURI)
+			}
+		})
+	}
+
+	if resp := doRequest(t, gateway, http.MethodGet, "/api/users/alice/orders", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without token: status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/expr"
+)
+
+// policyEnv déclare les variables disponibles dans les politiques :
+// la requête HTTP et l'ensemble des claims du token
+var policyEnv = expr.NewEnv(map[string]*expr.Type{
+	"request": expr.ObjectOf(map[string]*expr.Type{
+		"method":    expr.String,
+		"path":      expr.String,
+		"host":      expr.String,
+		"headers":   expr.MapOf(expr.String),
+		"query":     expr.MapOf(expr.String),
+		"client_ip": expr.String,
+	}),
+	"claims": expr.MapOf(expr.Dyn),
+})
+
+// compilePolicies compile et vérifie au démarrage les politiques d'une route,
+// afin qu'une expression invalide empêche le serveur de démarrer
+func (s *proxyServer) compilePolicies(route config.Route) error {
+	policies := []string{route.Policy}
+	for _, rule := range route.Rules {
+		policies = append(policies, rule.Policy)
+	}
+	for _, policy := range policies {
+		if policy == "" {
+			continue
+		}
+		if _, ok := s.policies[policy]; ok {
+			continue
+		}
+		program, err := policyEnv.CompileBool(policy)
+		if err != nil {
+			return fmt.Errorf("invalid policy %q: %w", policy, err)
+		}
+		if s.policies == nil {
+			s.policies = make(map[string]*expr.Program)
+		}
+		s.policies[policy] = program
+	}
+	return nil
+}
+
+// checkPolicy évalue une politique ; une erreur d'évaluation (claim absent,
+// type inattendu...) refuse l'accès
+func (s *proxyServer) checkPolicy(c *gin.Context, tokenInfo *TokenInfo, policy string) bool {
+	program, ok := s.policies[policy]
+	if !ok {
+		log.Printf("Politique non compilée: %q", policy)
+		return false
+	}
+	allowed, err := program.EvalBool(policyVariables(c, tokenInfo))
+	if err != nil {
+		log.Printf("Erreur d'évaluation de la politique %q: %v", policy, err)
+		return false
+	}
+	return allowed
+}
+
+// policyVariables construit les variables request et claims d'une requête
+func policyVariables(c *gin.Context, tokenInfo *TokenInfo) map[string]any {
+	headers := make(map[string]any, len(c.Request.Header))
+	for name, values := range c.Request.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	query := make(map[string]any)
+	for name, values := range c.Request.URL.Query() {
+		query[name] = strings.Join(values, ",")
+	}
+
+	claims := tokenInfo.Claims
+	if claims == nil {
+		claims = map[string]any{}
+	}
+
+	return map[string]any{
+		"request": map[string]any{
+			"method":    c.Request.Method,
+			"path":      c.Request.URL.Path,
+			"host":      c.Request.Host,
+			"headers":   headers,
+			"query":     query,
+			"client_ip": c.ClientIP(),
+		},
+		"claims": claims,
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestPolicyDeniesOnEvaluationError(t *testing.T) {
+	issuer := newTestIssuer(t)
+	backend, received := newTestBackend(t)
+	_, gateway := newTestGateway(t, testConfig(issuer, config.Route{
+		Path:       "/api/billing",
+		Target:     backend.URL,
+		AccessRule: config.AccessRule{Policy: `claims.department.startsWith("billing") && claims.clearance >= 2`},
+	}))
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		want   int
+	}{
+		{name: "granted", claims: map[string]any{"department": "billing-eu", "clearance": 3}, want: http.StatusOK},
+		{name: "false", claims: map[string]any{"department": "sales", "clearance": 3}, want: http.StatusForbidden},
+		{name: "missing claim", claims: map[string]any{"clearance": 3}, want: http.StatusForbidden},
+		{name: "claim of unexpected type", claims: map[string]any{"department": []string{"billing"}, "clearance": 3}, want: http.StatusForbidden},
+		{name: "comparison of mixed types", claims: map[string]any{"department": "billing", "clearance": "high"}, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, gateway, http.MethodGet, "/api/billing", bearer(issuer.token(t, "alice", tt.claims)))
+			if resp.StatusCode != tt.want {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+			if _, ok := lastRequest(received); ok != (tt.want == http.StatusOK) {
+				t.Errorf("backend reached = %v, want %v", ok, tt.want == http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestInvalidPolicyFailsAtStartup(t *testing.T) {
+	issuer := newTestIssuer(t)
+	for _, policy := range []string{
+		`claims.email.endsWith(`,
+		`request.method + 1`,
+		`request.unknown == "x"`,
+	} {
+		s := &proxyServer{cfg: testConfig(issuer, config.Route{
+			Path:       "/api",
+			Target:     "http://backend.invalid",
+			AccessRule: config.AccessRule{Policy: policy},
+		})}
+		if err := s.initTokenValidation(t.Context()); err == nil {
+			t.Errorf("initTokenValidation accepted the policy %q", policy)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// defaultProviderName est le nom du fournisseur déclaré directement sous oauth2
+const defaultProviderName = "default"
+
+// identityProvider regroupe l'état propre à un fournisseur d'identité :
+// découverte OIDC, validateurs instanciés et chaînes de validation
+type identityProvider struct {
+	name      string
+	cfg       config.Provider
+	discovery *oidcDiscovery
+
+	validators map[string]TokenValidator
+	chains     map[string]TokenValidator
+}
+
+// newIdentityProvider crée un fournisseur à partir de sa configuration
+func newIdentityProvider(cfg config.Provider) *identityProvider {
+	name := cfg.Name
+	if name == "" {
+		name = defaultProviderName
+	}
+	return &identityProvider{
+		name:       name,
+		cfg:        cfg,
+		validators: make(map[string]TokenValidator),
+		chains:     make(map[string]TokenValidator),
+	}
+}
+
+// isConfigured indique si le fournisseur déclare un issuer ou au moins un endpoint
+func isConfigured(cfg config.Provider) bool {
+	return cfg.Issuer != "" || cfg.Endpoints != (config.OAuth2Endpoints{})
+}
+
+// start effectue la découverte OIDC du fournisseur si un issuer est configuré
+func (p *identityProvider) start(ctx context.Context) error {
+	if p.cfg.Issuer == "" {
+		return nil
+	}
+	refresh := time.Duration(p.cfg.Discovery.RefreshInterval) * time.Second
+	p.discovery = newOIDCDiscovery(p.cfg.Issuer, refresh)
+	if err := p.discovery.start(ctx); err != nil {
+		return fmt.Errorf("provider %s: failed to discover OIDC configuration: %w", p.name, err)
+	}
+	return nil
+}
+
+// endpoints retourne les endpoints OAuth2 effectifs : les valeurs explicites
+// de la configuration priment sur celles obtenues par découverte OIDC
+func (p *identityProvider) endpoints() config.OAuth2Endpoints {
+	explicit := p.cfg.Endpoints
+	if p.discovery == nil {
+		return explicit
+	}
+	return mergeEndpoints(explicit, p.discovery.discovered())
+}
+
+// introspectionURL retourne l'endpoint d'introspection, ou à défaut l'endpoint tokeninfo
+func (p *identityProvider) introspectionURL() string {
+	endpoints := p.endpoints()
+	if endpoints.IntrospectionURL != "" {
+		return endpoints.IntrospectionURL
+	}
+	return endpoints.TokenInfoURL
+}
+
+// matchesIssuer indique si le fournisseur a émis les tokens portant cet iss
+func (p *identityProvider) matchesIssuer(issuer string) bool {
+	return p.cfg.Issuer != "" && strings.TrimSuffix(p.cfg.Issuer, "/") == strings.TrimSuffix(issuer, "/")
+}
+
+// chain retourne (en la construisant au besoin) la chaîne de validation du
+// fournisseur pour une liste de validateurs, précédée du regroupement des
+// appels concurrents et du cache de validation s'il est activé
+func (p *identityProvider) chain(ctx context.Context, names []string, cache *tokenCache) (TokenValidator, error) {
+	names = p.validatorNames(names)
+
+	// Le nom de la chaîne inclut le fournisseur, il sert de préfixe aux clés de cache
+	chainName := p.name + "/" + strings.Join(names, ",")
+	if validator, ok := p.chains[chainName]; ok {
+		return validator, nil
+	}
+
+	chain := &validatorChain{name: chainName}
+	for _, name := range names {
+		validator, err := p.validatorByName(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", p.name, err)
+		}
+		chain.validators = append(chain.validators, validator)
+	}
+
+	// Les validations concurrentes d'un même token ne font qu'un appel amont
+	var validator TokenValidator = &coalescingValidator{name: chain.name, next: chain}
+	if cache != nil {
+		validator = &cachedValidator{name: chain.name, next: validator, cache: cache}
+	}
+	p.chains[chainName] = validator
+	return validator, nil
+}
+
+// validatorNames retourne les validateurs effectivement utilisés : ceux de
+// la route, à défaut ceux du fournisseur, à défaut userinfo
+func (p *identityProvider) validatorNames(names []string) []string {
+	if len(names) == 0 {
+		names = p.cfg.Validators
+	}
+	if len(names) == 0 {
+		names = []string{validatorUserInfo}
+	}
+	return names
+}
+
+// validatorByName instancie (une seule fois) le validateur portant ce nom
+func (p *identityProvider) validatorByName(ctx context.Context, name string) (TokenValidator, error) {
+	if validator, ok := p.validators[name]; ok {
+		return validator, nil
+	}
+
+	var validator TokenValidator
+	switch name {
+	case validatorUserInfo:
+		if p.endpoints().UserInfoURL == "" {
+			return nil, errors.New("endpoints.userinfo_url is required for userinfo validation")
+		}
+		validator = newUserInfoValidator(func() string { return p.endpoints().UserInfoURL }, p.cfg.Issuer)
+	case validatorJWKS:
+		if p.endpoints().JWKSURL == "" {
+			return nil, errors.New("endpoints.jwks_url is required for jwks validation")
+		}
+		refresh := time.Duration(p.cfg.JWKS.RefreshInterval) * time.Second
+		keys := newJWKSCache(func() string { return p.endpoints().JWKSURL }, refresh)
+		if err := keys.start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to load JWKS: %w", err)
+		}
+		validator = &jwksValidator{
+			keys:   keys,
+			leeway: time.Duration(p.cfg.JWKS.Leeway) * time.Second,
+		}
+	case validatorIntrospection:
+		if p.introspectionURL() == "" {
+			return nil, errors.New("endpoints.introspection_url is required for introspection validation")
+		}
+		if p.cfg.ClientID == "" {
+			return nil, errors.New("client_id is required for introspection validation")
+		}
+		validator = newIntrospectionValidator(p.introspectionURL, p.cfg.ClientID, p.cfg.ClientSecret)
+	default:
+		return nil, fmt.Errorf("unknown token validator %q", name)
+	}
+
+	p.validators[name] = validator
+	return validator, nil
+}
+
+// peekIssuer lit le claim iss d'un JWT sans le vérifier ; il ne sert qu'à
+// choisir le fournisseur dont la chaîne validera ensuite le token
+func peekIssuer(tokenString string) string {
+	token, err := parseJWT(tokenString)
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(token.payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Issuer
+}
+
+// providerRoute associe un fournisseur accepté par une route à sa chaîne de validation
+type providerRoute struct {
+	provider  *identityProvider
+	validator TokenValidator
+}
+
+// providerValidator aiguille les tokens vers le fournisseur qui les a émis
+type providerValidator struct {
+	providers []providerRoute
+}
+
+// Validate implémente TokenValidator. Un JWT est confié au fournisseur dont
+// l'issuer correspond à son claim iss ; un token opaque est présenté à chaque
+// fournisseur accepté jusqu'à ce que l'un d'eux le reconnaisse.
+func (v *providerValidator) Validate(ctx context.Context, tokenString string) (*TokenInfo, error) {
+	candidates := v.providers
+	if issuer := peekIssuer(tokenString); issuer != "" {
+		candidates = v.candidatesFor(issuer)
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("no accepted provider for issuer %q", issuer)
+		}
+	}
+
+	lastErr := errors.New("no accepted identity provider")
+	for _, candidate := range candidates {
+		tokenInfo, err := candidate.validator.Validate(ctx, tokenString)
+		if err == nil {
+			return tokenInfo, nil
+		}
+		lastErr = fmt.Errorf("provider %s: %w", candidate.provider.name, err)
+	}
+	return nil, lastErr
+}
+
+// candidatesFor retourne les fournisseurs correspondant à un issuer, ou à
+// défaut ceux qui ne déclarent pas d'issuer (endpoints configurés à la main)
+func (v *providerValidator) candidatesFor(issuer string) []providerRoute {
+	var matching, unnamed []providerRoute
+	for _, candidate := range v.providers {
+		switch {
+		case candidate.provider.matchesIssuer(issuer):
+			matching = append(matching, candidate)
+		case candidate.provider.cfg.Issuer == "":
+			unnamed = append(unnamed, candidate)
+		}
+	}
+	if len(matching) > 0 {
+		return matching
+	}
+	return unnamed
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestProviderValidatorRoutesByIssuer(t *testing.T) {
+	issuer := newTestIssuer(t)
+	jwt := func(iss string) string { return issuer.token(t, "alice", map[string]any{"iss": iss}) }
+	errSignature := errors.New("invalid signature")
+
+	tests := []struct {
+		name      string
+		token     string
+		manual    bool
+		employees *stubValidator
+		partners  *stubValidator
+		wantSub   string
+		wantErr   bool
+		wantCalls [3]int32
+	}{
+		{name: "issuer of the first provider", token: jwt("https://idp/realms/employees"), wantSub: "employees", wantCalls: [3]int32{1, 0, 0}},
+		{name: "issuer with a trailing slash", token: jwt("https://idp/realms/partners/"), wantSub: "partners", wantCalls: [3]int32{0, 1, 0}},
+		{name: "error of the issuing provider", token: jwt("https://idp/realms/employees"), employees: &stubValidator{err: errSignature},
+			wantErr: true, wantCalls: [3]int32{1, 0, 0}},
+		{name: "unknown issuer", token: jwt("https://evil.example"), wantErr: true},
+		{name: "unknown issuer with a provider without issuer", token: jwt("https://idp/realms/legacy"), manual: true,
+			wantSub: "manual", wantCalls: [3]int32{0, 0, 1}},
+		{name: "opaque token", token: "opaque-token", employees: &stubValidator{err: errTokenNotSupported},
+			wantSub: "partners", wantCalls: [3]int32{1, 1, 0}},
+		{name: "opaque token rejected by every provider", token: "opaque-token",
+			employees: &stubValidator{err: errTokenNotSupported}, partners: &stubValidator{err: errTokenNotSupported},
+			wantErr: true, wantCalls: [3]int32{1, 1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.employees == nil {
+				tt.employees = &stubValidator{info: &TokenInfo{Sub: "employees"}}
+			}
+			if tt.partners == nil {
+				tt.partners = &stubValidator{info: &TokenInfo{Sub: "partners"}}
+			}
+			manual := &stubValidator{info: &TokenInfo{Sub: "manual"}}
+			validator := &providerValidator{providers: []providerRoute{
+				{provider: newIdentityProvider(config.Provider{Name: "employees", Issuer: "https://idp/realms/employees"}), validator: tt.employees},
+				{provider: newIdentityProvider(config.Provider{Name: "partners", Issuer: "https://idp/realms/partners"}), validator: tt.partners},
+			}}
+			if tt.manual {
+				validator.providers = append(validator.providers, providerRoute{provider: newIdentityProvider(config.Provider{Name: "legacy"}), validator: manual})
+			}
+
+			info, err := validator.Validate(t.Context(), tt.token)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Validate() = %+v, want an error", info)
+				}
+			} else if err != nil || info.Sub != tt.wantSub {
+				t.Errorf("Validate() = %+v, %v, want sub %q", info, err, tt.wantSub)
+			}
+			calls := [3]int32{tt.employees.calls.Load(), tt.partners.calls.Load(), manual.calls.Load()}
+			if calls != tt.wantCalls {
+				t.Errorf("provider calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// Chaque fournisseur vérifie les tokens de son issuer avec ses propres clés
+func TestProvidersOnRoutes(t *testing.T) {
+	employeesKeys, partnersKeys := newTestIssuer(t), newTestIssuer(t)
+	employees, partners := newDiscoveryServer(t), newDiscoveryServer(t)
+	employees.set(http.StatusOK, map[string]string{"issuer": employees.server.URL, "jwks_uri": employeesKeys.server.URL + "/jwks"})
+	partners.set(http.StatusOK, map[string]string{"issuer": partners.server.URL, "jwks_uri": partnersKeys.server.URL + "/jwks"})
+
+	backend, _ := newTestBackend(t)
+	cfg := testConfig(employeesKeys,
+		config.Route{Path: "/api", Target: backend.URL, Auth: authRequired},
+		config.Route{Path: "/partners", Target: backend.URL, Auth: authRequired, Providers: []string{"partners"}},
+	)
+	cfg.Server.OAuth2.Provider = config.Provider{}
+	cfg.Server.OAuth2.Providers = []config.Provider{
+		{Name: "employees", Issuer: employees.server.URL, Validators: []string{validatorJWKS}},
+		{Name: "partners", Issuer: partners.server.URL, Validators: []string{validatorJWKS}},
+	}
+	_, gateway := newTestGateway(t, cfg)
+
+	employee := employeesKeys.token(t, "alice", map[string]any{"iss": employees.server.URL})
+	partner := partnersKeys.token(t, "bob", map[string]any{"iss": partners.server.URL})
+	// Token signé par les partenaires au nom des employés
+	impersonation := partnersKeys.token(t, "mallory", map[string]any{"iss": employees.server.URL})
+	unknown := employeesKeys.token(t, "alice", map[string]any{"iss": "https://evil.example"})
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{name: "employee", path: "/api", token: employee, want: http.StatusOK},
+		{name: "partner", path: "/api", token: partner, want: http.StatusOK},
+		{name: "key of another provider", path: "/api", token: impersonation, want: http.StatusUnauthorized},
+		{name: "unknown issuer", path: "/api", token: unknown, want: http.StatusUnauthorized},
+		{name: "partner on a partners route", path: "/partners", token: partner, want: http.StatusOK},
+		{name: "employee on a partners route", path: "/partners", token: employee, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := doRequest(t, gateway, http.MethodGet, tt.path, bearer(tt.token)); resp.StatusCode != tt.want {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestProvidersConfiguration(t *testing.T) {
+	issuer := newTestIssuer(t)
+	named := func(name string) config.Provider {
+		provider := issuer.provider()
+		provider.Name = name
+		return provider
+	}
+	tests := []struct {
+		name      string
+		providers []config.Provider
+		route     config.Route
+		wantErr   bool
+	}{
+		{name: "named providers", providers: []config.Provider{named("employees"), named("partners")},
+			route: config.Route{Providers: []string{"partners"}}},
+		{name: "unnamed provider", providers: []config.Provider{named("")}, wantErr: true},
+		{name: "duplicate provider", providers: []config.Provider{named("employees"), named("employees")}, wantErr: true},
+		{name: "unknown provider on a route", providers: []config.Provider{named("employees")},
+			route: config.Route{Providers: []string{"partners"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := tt.route
+			route.Path = "/api"
+			route.Target = "http://backend"
+			cfg := testConfig(issuer, route)
+			cfg.Server.OAuth2.Provider = config.Provider{}
+			cfg.Server.OAuth2.Providers = tt.providers
+			s := &proxyServer{cfg: cfg}
+			if err := s.initTokenValidation(t.Context()); (err != nil) != tt.wantErr {
+				t.Errorf("initTokenValidation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
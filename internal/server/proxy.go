@@ -1,68 +1,112 @@
 package server
 
 import (
-	"io"
-	"maps"
+	"context"
+	"errors"
+	"log"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// proxy gère le forwarding des requêtes
-func (s *proxyServer) proxy(c *gin.Context) {
+// proxyRequestKey porte la requête gin et sa cible dans le contexte de la
+// requête transmise au backend
+type proxyRequestKey struct{}
 
-	targetURL := s.getTargetURL(c)
+// proxyRequest est la requête en cours de forwarding
+type proxyRequest struct {
+	c      *gin.Context
+	target *url.URL
+}
 
-	req, err := http.NewRequest(c.Request.Method, targetURL, c.Request.Body)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to create request"})
-		return
+// newReverseProxy crée le reverse proxy partagé par toutes les routes : les
+// corps sont transmis en streaming, les headers multi-valués et les trailers
+// sont conservés et les connexions aux backends sont réutilisées
+func (s *proxyServer) newReverseProxy() *httputil.ReverseProxy {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 100
+	// Le timeout porte sur l'attente des headers de la réponse, pour ne pas
+	// couper les réponses longues transmises en streaming
+	transport.ResponseHeaderTimeout = time.Duration(s.cfg.Server.TimeOut) * time.Second
+
+	return &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			request := pr.In.Context().Value(proxyRequestKey{}).(*proxyRequest)
+			pr.Out.URL.Scheme = request.target.Scheme
+			pr.Out.URL.Host = request.target.Host
+			pr.Out.URL.Path = request.target.Path
+			pr.Out.URL.RawPath = request.target.RawPath
+			pr.Out.URL.RawQuery = joinQuery(request.target.RawQuery, pr.In.URL.RawQuery)
+			pr.Out.Host = ""
+
+			// Les headers X-Forwarded-* reçus sont complétés et non remplacés
+			pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+			pr.SetXForwarded()
+
+			// Propagate token-related headers to backend
+			s.propagateTokenHeadersToBackend(request.c, pr.Out)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			// Les headers du backend remplacent ceux déjà posés sur la réponse
+			// par les middlewares
+			request := resp.Request.Context().Value(proxyRequestKey{}).(*proxyRequest)
+			for key := range resp.Header {
+				request.c.Writer.Header().Del(key)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, context.Canceled) {
+				// Client parti avant la réponse du backend
+				return
+			}
+			log.Printf("Erreur de forwarding vers %s: %v", r.URL.Redacted(), err)
+			request := r.Context().Value(proxyRequestKey{}).(*proxyRequest)
+			request.c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to reach backend"})
+		},
 	}
+}
 
-	// Copy headers
-	maps.Copy(req.Header, c.Request.Header)
-
-	// Propagate token-related headers to backend
-	s.propagateTokenHeadersToBackend(c, req)
-
-	client := &http.Client{}
-	client.Timeout = time.Duration(s.cfg.Server.TimeOut) * time.Second
-
-	resp, err := client.Do(req)
-	if err != nil {
-		c.JSON(502, gin.H{"error": "Failed to reach backend"})
+// proxy gère le forwarding des requêtes
+func (s *proxyServer) proxy(c *gin.Context) {
+	target, err := url.Parse(s.getTargetURL(c))
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		log.Printf("Cible invalide pour %s: %v", c.Request.URL.Path, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
 		return
 	}
-	defer resp.Body.Close()
-
-	// Copy response headers
-	for k, v := range resp.Header {
-		c.Header(k, v[0])
-		c.Status(resp.StatusCode)
-		_, err = io.Copy(c.Writer, resp.Body)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to copy response"})
-		}
+
+	ctx := context.WithValue(c.Request.Context(), proxyRequestKey{}, &proxyRequest{c: c, target: target})
+	s.reverseProxy.ServeHTTP(c.Writer, c.Request.WithContext(ctx))
+}
+
+// joinQuery ajoute la query string de la requête à celle de la cible
+func joinQuery(targetQuery, requestQuery string) string {
+	if targetQuery == "" || requestQuery == "" {
+		return targetQuery + requestQuery
 	}
+	return targetQuery + "&" + requestQuery
 }
 
 // getTargetURL construit l'URL cible pour le proxy
 func (s *proxyServer) getTargetURL(c *gin.Context) string {
-	// Récupérer le chemin de la requête
-	requestPath := c.Request.URL.Path
-
-	// Trouver la configuration de route correspondante
-	targetBase := s.cfg.Server.DefaultTarget
-	for _, route := range s.cfg.Routes {
-		if strings.HasPrefix(requestPath, route.Path) {
-			targetBase = route.Target // Ajouter Target à votre config
-			break
-		}
+	value, ok := c.Get("route")
+	if !ok {
+		return s.cfg.Server.DefaultTarget
 	}
+	match := value.(*routeMatch)
 
-	return targetBase
+	target := match.route.cfg.Target
+	// Cible propre au tenant de la requête
+	if tenantTarget, ok := tenantTarget(match.route.cfg.Tenancy, c.GetString("tenant")); ok {
+		target = tenantTarget
+	}
+	return joinTargetPath(expandTarget(target, match.params), match.forwardedPath(c.Request.URL.Path))
 }
 
 // propagateTokenHeadersToBackend propage les headers vers la requête backend
@@ -81,6 +125,19 @@ func (s *proxyServer) propagateTokenHeadersToBackend(c *gin.Context, backendReq
 		}
 	}
 
+	// Propager le tenant de la requête, qui remplace celui envoyé par le client
+	if tenant := c.GetString("tenant"); tenant != "" {
+		backendReq.Header.Set(c.GetString("tenantHeader"), tenant)
+	}
+
+	// Le cookie de session du proxy n'est jamais transmis ; le token de la
+	// session navigateur le remplace sur les routes qui l'ont authentifié
+	// (backend for frontend)
+	removeCookie(backendReq, sessionCookie)
+	if value, ok := c.Get("session"); ok {
+		backendReq.Header.Set("Authorization", "Bearer "+value.(*Session).AccessToken)
+	}
+
 	// Propager les headers de resource access
 	for key, values := range c.Writer.Header() {
 		if strings.HasPrefix(key, "X-Resource-") && strings.HasSuffix(key, "-Roles") {
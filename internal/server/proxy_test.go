@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestProxyForwardsHeadersTrailersAndQuery(t *testing.T) {
+	var got *http.Request
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Clone(r.Context())
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Add("X-Multi", "one")
+		w.Header().Add("X-Multi", "two")
+		w.Header().Set("Trailer", "X-Checksum")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "body")
+		w.Header().Set("X-Checksum", "abc123")
+	}))
+	t.Cleanup(backend.Close)
+
+	issuer := newTestIssuer(t)
+	_, gateway := newTestGateway(t, testConfig(issuer,
+		config.Route{Path: "/api", Target: backend.URL + "/v1?version=2", Auth: authNone},
+	))
+	resp := doRequest(t, gateway, http.MethodGet, "/api/items?tag=a&tag=b&q=x%20y", http.Header{
+		"X-Request-Tag":   {"first", "second"},
+		"X-Forwarded-For": {"203.0.113.7"},
+	})
+	body := readBody(t, resp)
+
+	if resp.StatusCode != http.StatusCreated || body != "body" {
+		t.Fatalf("got %d %q, want %d %q", resp.StatusCode, body, http.StatusCreated, "body")
+	}
+	if cookies := resp.Header.Values("Set-Cookie"); !slices.Equal(cookies, []string{"a=1", "b=2"}) {
+		t.Errorf("Set-Cookie = %q, want both cookies", cookies)
+	}
+	if values := resp.Header.Values("X-Multi"); !slices.Equal(values, []string{"one", "two"}) {
+		t.Errorf("X-Multi = %q, want [one two]", values)
+	}
+	if trailer := resp.Trailer.Get("X-Checksum"); trailer != "abc123" {
+		t.Errorf("trailer X-Checksum = %q, want abc123", trailer)
+	}
+
+	if got == nil {
+		t.Fatal("request did not reach the backend")
+	}
+	if got.URL.Path != "/v1/items" {
+		t.Errorf("backend path = %q, want /v1/items", got.URL.Path)
+	}
+	// La query de la cible précède celle de la requête, dont les valeurs
+	// multiples sont conservées
+	query := got.URL.Query()
+	if !strings.HasPrefix(got.URL.RawQuery, "version=2&") || !slices.Equal(query["tag"], []string{"a", "b"}) || query.Get("q") != "x y" {
+		t.Errorf("backend query = %q, want version=2 then tag=a&tag=b and q=x y", got.URL.RawQuery)
+	}
+	if values := got.Header.Values("X-Request-Tag"); !slices.Equal(values, []string{"first", "second"}) {
+		t.Errorf("X-Request-Tag = %q, want [first second]", values)
+	}
+	if forwardedFor := got.Header.Get("X-Forwarded-For"); forwardedFor != "203.0.113.7, 127.0.0.1" {
+		t.Errorf("X-Forwarded-For = %q, want the client chain completed", forwardedFor)
+	}
+}
+
+func TestProxyStreamsResponses(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		io.WriteString(w, "second\n")
+	}))
+	t.Cleanup(backend.Close)
+	defer close(release)
+
+	issuer := newTestIssuer(t)
+	_, gateway := newTestGateway(t, testConfig(issuer,
+		config.Route{Path: "/events", Target: backend.URL, Auth: authNone},
+	))
+	resp := doRequest(t, gateway, http.MethodGet, "/events", nil)
+	reader := bufio.NewReader(resp.Body)
+
+	// La première partie arrive avant que le backend n'ait terminé sa réponse
+	first := make(chan string, 1)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		first <- line
+	}()
+	select {
+	case line := <-first:
+		if line != "first\n" {
+			t.Fatalf("first chunk = %q, want %q", line, "first\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first chunk was buffered by the proxy")
+	}
+
+	release <- struct{}{}
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "second\n" {
+		t.Errorf("rest = %q, want %q", rest, "second\n")
+	}
+}
+
+func TestProxyStreamsRequestBodies(t *testing.T) {
+	var got backendRequest
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = backendRequest{method: r.Method, body: string(body)}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(backend.Close)
+
+	issuer := newTestIssuer(t)
+	_, gateway := newTestGateway(t, testConfig(issuer,
+		config.Route{Path: "/upload", Target: backend.URL, Auth: authNone},
+	))
+
+	// Corps de longueur inconnue, envoyé en chunked
+	payload := strings.Repeat("0123456789", 100000)
+	reader, writer := io.Pipe()
+	go func() {
+		for chunk := range slices.Chunk([]byte(payload), 64*1024) {
+			writer.Write(chunk)
+		}
+		writer.Close()
+	}()
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, gateway.URL+"/upload", reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := gateway.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if got.method != http.MethodPut || got.body != payload {
+		t.Errorf("backend received %s with %d bytes, want PUT with %d bytes", got.method, len(got.body), len(payload))
+	}
+}
+
+func TestProxyResponseWithoutHeaders(t *testing.T) {
+	// Backend HTTP/1.0 minimal : ni Content-Type, ni Content-Length, le corps
+	// se termine à la fermeture de la connexion
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				http.ReadRequest(bufio.NewReader(conn))
+				io.WriteString(conn, "HTTP/1.0 200 OK\r\n\r\nraw body")
+			}()
+		}
+	}()
+
+	issuer := newTestIssuer(t)
+	_, gateway := newTestGateway(t, testConfig(issuer,
+		config.Route{Path: "/raw", Target: "http://" + listener.Addr().String(), Auth: authNone},
+	))
+	resp := doRequest(t, gateway, http.MethodGet, "/raw", nil)
+	if body := readBody(t, resp); resp.StatusCode != http.StatusOK || body != "raw body" {
+		t.Errorf("got %d %q, want %d %q", resp.StatusCode, body, http.StatusOK, "raw body")
+	}
+}
+
+func TestProxyBackendDown(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	target := backend.URL
+	backend.Close()
+
+	issuer := newTestIssuer(t)
+	_, gateway := newTestGateway(t, testConfig(issuer,
+		config.Route{Path: "/api", Target: target, Auth: authNone},
+	))
+	resp := doRequest(t, gateway, http.MethodGet, "/api/items", nil)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status %d, want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+	if body := readBody(t, resp); body != `{"error":"Failed to reach backend"}` {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestProxyInvalidTarget(t *testing.T) {
+	issuer := newTestIssuer(t)
+	_, gateway := newTestGateway(t, testConfig(issuer,
+		config.Route{Path: "/api", Target: "backend-without-scheme", Auth: authNone},
+	))
+	resp := doRequest(t, gateway, http.MethodGet, "/api", nil)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// compiledRoute est une route configurée, prête à être servie
+type compiledRoute struct {
+	cfg   config.Route
+	match string
+	// segments du chemin (exact, prefix) ou expression du chemin (regex)
+	segments []string
+	pattern  *regexp.Regexp
+	rewrite  *regexp.Regexp
+	// Conditions sur l'hôte, les headers et la query string
+	hosts   []string
+	headers []valueMatcher
+	query   []valueMatcher
+
+	validator TokenValidator
+	handlers  gin.HandlersChain
+}
+
+// routeMatch est la route retenue pour une requête et la valeur de ses
+// paramètres nommés, stockée dans le contexte
+type routeMatch struct {
+	route  *compiledRoute
+	params map[string]string
+	// depth est le nombre de segments du chemin couverts par la route
+	depth int
+}
+
+// compileRoute vérifie au démarrage la correspondance et la réécriture du
+// chemin d'une route
+func compileRoute(route config.Route) (*compiledRoute, error) {
+	compiled := &compiledRoute{cfg: route, match: route.Match}
+	switch route.Match {
+	case "", pathMatchPrefix:
+		compiled.match = pathMatchPrefix
+		compiled.segments = pathSegments(route.Path)
+	case pathMatchExact:
+		compiled.segments = pathSegments(route.Path)
+	case pathMatchRegex:
+		// L'expression porte sur le chemin complet
+		pattern, err := regexp.Compile("^(?:" + route.Path + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid path regex: %w", err)
+		}
+		compiled.pattern = pattern
+	default:
+		return nil, fmt.Errorf("unknown match type %q", route.Match)
+	}
+
+	if prefix := route.StripPrefix; prefix != "" {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("strip_prefix %q must start with /", prefix)
+		}
+		if compiled.match != pathMatchRegex && (!strings.HasPrefix(route.Path, prefix) || strings.Contains(prefix, ":")) {
+			return nil, fmt.Errorf("strip_prefix %q is not a static prefix of the route path", prefix)
+		}
+	}
+	if err := compiled.compileConditions(); err != nil {
+		return nil, err
+	}
+
+	rewrite := route.Rewrite
+	if rewrite.Regex == "" && rewrite.Template == "" {
+		return compiled, nil
+	}
+	if rewrite.Regex == "" || rewrite.Template == "" {
+		return nil, fmt.Errorf("rewrite requires a regex and a template")
+	}
+	re, err := regexp.Compile(rewrite.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rewrite regex: %w", err)
+	}
+	compiled.rewrite = re
+	return compiled, nil
+}
+
+// use ajoute des handlers à la route
+func (r *compiledRoute) use(handlers ...gin.HandlerFunc) {
+	r.handlers = append(r.handlers, handlers...)
+}
+
+// pathParams retourne la valeur des paramètres nommés d'une route exacte ou
+// préfixe, d'après les segments de la requête
+func (r *compiledRoute) pathParams(segments []string) map[string]string {
+	var params map[string]string
+	for i, segment := range r.segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[name] = segments[i]
+		}
+	}
+	return params
+}
+
+// forwardedPath retourne la partie du chemin de la requête ajoutée à la
+// cible : le chemin réécrit, le reste du chemin après le préfixe retiré ou,
+// par défaut, après le chemin de la route (rien pour une expression)
+func (m *routeMatch) forwardedPath(requestPath string) string {
+	route := m.route
+	if route.rewrite != nil {
+		if match := route.rewrite.FindStringSubmatchIndex(requestPath); match != nil {
+			return string(route.rewrite.ExpandString(nil, route.cfg.Rewrite.Template, requestPath, match))
+		}
+	}
+	if route.cfg.StripPrefix != "" {
+		return strings.TrimPrefix(requestPath, route.cfg.StripPrefix)
+	}
+	if route.match == pathMatchRegex {
+		return ""
+	}
+	return pathAfterSegments(requestPath, m.depth)
+}
+
+// joinTargetPath ajoute un chemin à celui de l'URL cible
+func joinTargetPath(target, path string) string {
+	if path == "" {
+		return target
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	targetURL.Path = strings.TrimSuffix(targetURL.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+	targetURL.RawPath = ""
+	return targetURL.String()
+}
+
+// dispatch sert les routes configurées : la route correspondant au chemin
+// exécute ses middlewares puis le proxy. Les routes ne sont pas enregistrées
+// dans le routeur de gin, qui refuse qu'une route et ses sous-chemins
+// (/api/*path) cohabitent avec une route plus précise (/api/public).
+func (s *proxyServer) dispatch(c *gin.Context) {
+	if !routablePath(c.Request.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "Chemin de requête invalide",
+		})
+		c.Abort()
+		return
+	}
+	match, ok := s.routeTable.match(c.Request)
+	if !ok {
+		// gin répond 404
+		return
+	}
+	c.Status(http.StatusOK)
+	for name, value := range match.params {
+		c.Params = append(c.Params, gin.Param{Key: name, Value: value})
+	}
+	c.Set("route", match)
+
+	// dispatch est le dernier handler de gin : le c.Next() des middlewares
+	// de la route est sans effet et la route enchaîne elle-même ses handlers
+	for _, handler := range match.route.handlers {
+		handler(c)
+		if c.IsAborted() {
+			return
+		}
+	}
+}
+
+// routablePath refuse les chemins qu'un backend pourrait normaliser hors du
+// préfixe de la route retenue : segments . et .. (encodés ou non, suivis ou
+// non de paramètres ;, que Tomcat, Jetty ou Spring ignorent), slash encodé
+// et antislash (encodé ou non). La route est choisie sur le chemin décodé et
+// son reste transmis tel quel.
+func routablePath(u *url.URL) bool {
+	if strings.ContainsRune(u.Path, '\\') {
+		return false
+	}
+	if escaped := strings.ToLower(u.EscapedPath()); strings.Contains(escaped, "%2f") || strings.Contains(escaped, "%5c") {
+		return false
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment, _, _ = strings.Cut(segment, ";"); segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestDispatchRejectsPathTraversal(t *testing.T) {
+	issuer := newTestIssuer(t)
+	backend, received := newTestBackend(t)
+	cfg := testConfig(issuer,
+		config.Route{Path: "/api/public", Target: backend.URL + "/public", Auth: authNone},
+		config.Route{Path: "/api/admin", Target: backend.URL + "/admin", AccessRule: config.AccessRule{Roles: []string{"admin"}}},
+		config.Route{
+			Path:    "/api/tenants/:tenant",
+			Target:  backend.URL + "/tenants/:tenant",
+			Tenancy: config.Tenancy{Claim: "tenant_id", Param: "tenant"},
+		},
+	)
+	_, gateway := newTestGateway(t, cfg)
+	acme := bearer(issuer.token(t, "alice", map[string]any{"tenant_id": "acme"}))
+
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+	}{
+		{name: "dot dot", path: "/api/public/../admin/secret"},
+		{name: "encoded dot dot", path: "/api/public/%2e%2e/admin/secret"},
+		{name: "mixed case encoded dot dot", path: "/api/public/%2E%2e/admin/secret"},
+		{name: "encoded slash", path: "/api/public/..%2fadmin"},
+		{name: "encoded slash upper case", path: "/api/public/..%2Fadmin"},
+		{name: "dot", path: "/api/public/./secret"},
+		{name: "trailing dot dot", path: "/api/public/.."},
+		{name: "backslash", path: "/api/public/..%5cadmin"},
+		{name: "dot dot with parameter", path: "/api/public/..;/admin/secret"},
+		{name: "encoded dot dot with parameter", path: "/api/public/%2e%2e;/admin/secret"},
+		{name: "dot dot with named parameter", path: "/api/public/..;jsessionid=1/admin/secret"},
+		{name: "dot dot with encoded semicolon", path: "/api/public/..%3b/admin/secret"},
+		{name: "dot with parameter", path: "/api/public/.;/secret"},
+		{name: "other tenant", path: "/api/tenants/acme/../globex/invoices", header: acme},
+		{name: "other tenant encoded", path: "/api/tenants/acme/%2e%2e/globex", header: acme},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, gateway, http.MethodGet, tt.path, tt.header)
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("GET %s: status %d, want %d", tt.path, resp.StatusCode, http.StatusBadRequest)
+			}
+			if request, ok := lastRequest(received); ok {
+				t.Errorf("GET %s reached the backend as %s", tt.path, request.uri)
+			}
+		})
+	}
+}
+
+func TestDispatchForwardsSubpaths(t *testing.T) {
+	issuer := newTestIssuer(t)
+	backend, received := newTestBackend(t)
+	cfg := testConfig(issuer,
+		config.Route{Path: "/api/public", Target: backend.URL + "/public", Auth: authNone},
+		config.Route{Path: "/api/v1", Target: backend.URL, StripPrefix: "/api", Auth: authNone},
+		config.Route{
+			Path:    "/legacy",
+			Target:  backend.URL,
+			Auth:    authNone,
+			Rewrite: config.Rewrite{Regex: `^/legacy/(\w+)/(\d+)$`, Template: "/v2/$1/items/$2"},
+		},
+	)
+	_, gateway := newTestGateway(t, cfg)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/api/public", want: "/public"},
+		{path: "/api/public/docs/index.html", want: "/public/docs/index.html"},
+		{path: "/api/public/file..txt", want: "/public/file..txt"},
+		{path: "/api/public/items;v=2", want: "/public/items;v=2"},
+		{path: "/api/public/a%20b", want: "/public/a%20b"},
+		{path: "/api/v1/users?page=2", want: "/v1/users?page=2"},
+		{path: "/legacy/orders/42", want: "/v2/orders/items/42"},
+		{path: "/legacy/orders/latest", want: "/orders/latest"},
+	}
+	for _, tt := range tests {
+		resp := doRequest(t, gateway, http.MethodGet, tt.path, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: status %d, want %d", tt.path, resp.StatusCode, http.StatusOK)
+			continue
+		}
+		request, ok := lastRequest(received)
+		if !ok {
+			t.Errorf("GET %s did not reach the backend", tt.path)
+			continue
+		}
+		if request.uri != tt.want {
+			t.Errorf("GET %s forwarded as %s, want %s", tt.path, request.uri, tt.want)
+		}
+	}
+}
+
+func TestDispatchUnknownRoute(t *testing.T) {
+	issuer := newTestIssuer(t)
+	backend, _ := newTestBackend(t)
+	_, gateway := newTestGateway(t, testConfig(issuer,
+		config.Route{Path: "/api/public", Target: backend.URL, Auth: authNone},
+	))
+	if resp := doRequest(t, gateway, http.MethodGet, "/other", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Types de correspondance du chemin d'une route
+const (
+	pathMatchPrefix = "prefix"
+	pathMatchExact  = "exact"
+	pathMatchRegex  = "regex"
+)
+
+// routeNode est un nœud de l'arbre des routes, indexé par segment de chemin ;
+// les paramètres nommés partagent un même enfant quelle que soit leur nom
+type routeNode struct {
+	static map[string]*routeNode
+	param  *routeNode
+	exact  []*compiledRoute
+	prefix []*compiledRoute
+}
+
+// child retourne l'enfant d'un segment de route, créé au besoin
+func (n *routeNode) child(segment string) *routeNode {
+	if strings.HasPrefix(segment, ":") {
+		if n.param == nil {
+			n.param = &routeNode{}
+		}
+		return n.param
+	}
+	if n.static == nil {
+		n.static = make(map[string]*routeNode)
+	}
+	child, ok := n.static[segment]
+	if !ok {
+		child = &routeNode{}
+		n.static[segment] = child
+	}
+	return child
+}
+
+// routeCandidate est une route correspondant au chemin de la requête
+type routeCandidate struct {
+	route *compiledRoute
+	// depth est le nombre de segments du chemin couverts par la route et
+	// statics le nombre de segments statiques parmi eux
+	depth   int
+	statics int
+	params  map[string]string
+	// host est la correspondance de l'hôte de la requête
+	host int
+}
+
+// routeTable sélectionne la route d'une requête parmi celles dont l'hôte,
+// les headers et la query string correspondent : la priorité la plus haute,
+// puis l'hôte exact, puis un hôte générique (*.domaine), puis une route
+// exacte, puis le préfixe le plus long (segment par segment, un segment
+// statique l'emportant sur un paramètre), puis les expressions régulières ;
+// à égalité, la route ayant le plus de conditions sur les headers et la query.
+// L'ordre de déclaration des routes n'intervient jamais.
+type routeTable struct {
+	root  routeNode
+	regex []*compiledRoute
+}
+
+// newRouteTable construit la table des routes ; deux routes qu'une même
+// requête peut sélectionner sans que les règles de précédence ne les
+// départagent sont ambiguës et empêchent le démarrage
+func newRouteTable(routes []*compiledRoute) (*routeTable, error) {
+	table := &routeTable{}
+	for i, route := range routes {
+		for _, other := range routes[:i] {
+			if mayTie(other, route) {
+				return nil, fmt.Errorf("routes %s and %s are ambiguous: a request can match both with the same %s match, priority %d, host and number of conditions, set a different priority",
+					other.cfg.Path, route.cfg.Path, route.match, route.cfg.Priority)
+			}
+		}
+
+		if route.match == pathMatchRegex {
+			table.regex = append(table.regex, route)
+			continue
+		}
+		node := &table.root
+		for _, segment := range route.segments {
+			node = node.child(segment)
+		}
+		if route.match == pathMatchExact {
+			node.exact = append(node.exact, route)
+		} else {
+			node.prefix = append(node.prefix, route)
+		}
+	}
+	return table, nil
+}
+
+// mayTie indique si une requête peut correspondre aux deux routes sans que
+// betterThan ne les départage. Le recouvrement de deux expressions
+// régulières n'est pas décidable : elles doivent différer par leur priorité,
+// leurs hôtes ou leurs conditions.
+func mayTie(a, b *compiledRoute) bool {
+	if a.cfg.Priority != b.cfg.Priority || a.match != b.match || a.conditions() != b.conditions() {
+		return false
+	}
+	if !hostsMayTie(a, b) || conditionsDisjoint(a, b) {
+		return false
+	}
+	if a.match == pathMatchRegex {
+		return true
+	}
+	// Même profondeur et autant de segments statiques, sur des chemins
+	// compatibles segment par segment
+	if len(a.segments) != len(b.segments) || a.statics() != b.statics() {
+		return false
+	}
+	for i, segment := range a.segments {
+		other := b.segments[i]
+		if segment != other && !strings.HasPrefix(segment, ":") && !strings.HasPrefix(other, ":") {
+			return false
+		}
+	}
+	return true
+}
+
+// statics retourne le nombre de segments statiques du chemin de la route
+func (r *compiledRoute) statics() int {
+	n := 0
+	for _, segment := range r.segments {
+		if !strings.HasPrefix(segment, ":") {
+			n++
+		}
+	}
+	return n
+}
+
+// hostsMayTie indique si un même hôte de requête correspond aux deux routes
+// avec la même précision. Les hôtes candidats sont ceux des routes et, pour
+// chaque hôte générique, un sous-domaine qu'aucune route ne peut nommer.
+func hostsMayTie(a, b *compiledRoute) bool {
+	if len(a.hosts) == 0 || len(b.hosts) == 0 {
+		return len(a.hosts) == len(b.hosts)
+	}
+	for _, pattern := range slices.Concat(a.hosts, b.hosts) {
+		host := pattern
+		if domain, ok := strings.CutPrefix(pattern, "*"); ok {
+			host = "\x00" + domain
+		}
+		if rank := a.hostRank(host); rank != hostMismatch && rank == b.hostRank(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionsDisjoint indique si les deux routes exigent des valeurs
+// différentes pour un même header ou paramètre de query. Une requête portant
+// les deux valeurs reste ambiguë : elle est refusée par match.
+func conditionsDisjoint(a, b *compiledRoute) bool {
+	disjoint := func(matchers, others []valueMatcher) bool {
+		for _, m := range matchers {
+			for _, other := range others {
+				if m.name == other.name && m.regex == nil && other.regex == nil &&
+					m.value != "" && other.value != "" && m.value != other.value {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return disjoint(a.headers, b.headers) || disjoint(a.query, b.query)
+}
+
+// match retourne la route retenue pour la requête
+func (t *routeTable) match(req *http.Request) (*routeMatch, bool) {
+	requestPath := req.URL.Path
+	segments := pathSegments(requestPath)
+	var candidates []routeCandidate
+	t.root.collect(segments, 0, 0, &candidates)
+	for _, route := range t.regex {
+		if values := route.pattern.FindStringSubmatch(requestPath); values != nil {
+			params := make(map[string]string)
+			for i, name := range route.pattern.SubexpNames() {
+				if name != "" {
+					params[name] = values[i]
+				}
+			}
+			candidates = append(candidates, routeCandidate{route: route, params: params})
+		}
+	}
+
+	host := requestHost(req)
+	query := req.URL.Query()
+	var best *routeCandidate
+	tied := false
+	for i := range candidates {
+		candidate := &candidates[i]
+		if candidate.host = candidate.route.hostRank(host); candidate.host == hostMismatch {
+			continue
+		}
+		if !candidate.route.acceptsRequest(req, query) {
+			continue
+		}
+		switch {
+		case best == nil || candidate.betterThan(*best):
+			best, tied = candidate, false
+		case !best.betterThan(*candidate):
+			tied = true
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+	if tied {
+		// Seule une requête portant plusieurs valeurs d'un header ou d'un
+		// paramètre exigé par des routes concurrentes peut en arriver là
+		log.Printf("Requête %s %s ambiguë entre plusieurs routes, refusée", req.Method, requestPath)
+		return nil, false
+	}
+	if best.route.match != pathMatchRegex {
+		best.params = best.route.pathParams(segments)
+	}
+	return &routeMatch{route: best.route, params: best.params, depth: best.depth}, true
+}
+
+// collect ajoute les routes du nœud et de ses descendants correspondant au chemin
+func (n *routeNode) collect(segments []string, depth, statics int, candidates *[]routeCandidate) {
+	for _, route := range n.prefix {
+		*candidates = append(*candidates, routeCandidate{route: route, depth: depth, statics: statics})
+	}
+	if depth == len(segments) {
+		for _, route := range n.exact {
+			*candidates = append(*candidates, routeCandidate{route: route, depth: depth, statics: statics})
+		}
+		return
+	}
+	if child, ok := n.static[segments[depth]]; ok {
+		child.collect(segments, depth+1, statics+1, candidates)
+	}
+	if n.param != nil && segments[depth] != "" {
+		n.param.collect(segments, depth+1, statics, candidates)
+	}
+}
+
+// betterThan indique si le candidat l'emporte sur un autre
+func (c routeCandidate) betterThan(other routeCandidate) bool {
+	if c.route.cfg.Priority != other.route.cfg.Priority {
+		return c.route.cfg.Priority > other.route.cfg.Priority
+	}
+	if c.host != other.host {
+		return c.host > other.host
+	}
+	if rank, otherRank := matchRank(c.route.match), matchRank(other.route.match); rank != otherRank {
+		return rank > otherRank
+	}
+	if c.depth != other.depth {
+		return c.depth > other.depth
+	}
+	if c.statics != other.statics {
+		return c.statics > other.statics
+	}
+	conditions, otherConditions := c.route.conditions(), other.route.conditions()
+	return conditions > otherConditions
+}
+
+// matchRank ordonne les types de correspondance, du plus précis au moins précis
+func matchRank(match string) int {
+	switch match {
+	case pathMatchExact:
+		return 2
+	case pathMatchPrefix:
+		return 1
+	}
+	return 0
+}
+
+// pathSegments découpe un chemin en segments, sans tenir compte du slash
+// de début et de celui de fin
+func pathSegments(path string) []string {
+	path = strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// pathAfterSegments retourne le chemin qui suit ses n premiers segments
+func pathAfterSegments(path string, n int) string {
+	for range n {
+		path = strings.TrimPrefix(path, "/")
+		i := strings.IndexByte(path, '/')
+		if i < 0 {
+			return ""
+		}
+		path = path[i:]
+	}
+	return path
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// newTestRouteTable compile les routes et construit leur table ; la cible de
+// chaque route sert à l'identifier
+func newTestRouteTable(t *testing.T, routes []config.Route) (*routeTable, error) {
+	t.Helper()
+	compiled := make([]*compiledRoute, len(routes))
+	for i, route := range routes {
+		if route.Target == "" {
+			route.Target = "http://" + route.Path
+		}
+		var err error
+		if compiled[i], err = compileRoute(route); err != nil {
+			t.Fatalf("compileRoute(%s): %v", route.Path, err)
+		}
+	}
+	return newRouteTable(compiled)
+}
+
+// matchTarget retourne la cible de la route retenue pour la requête
+func matchTarget(table *routeTable, req *http.Request) string {
+	match, ok := table.match(req)
+	if !ok {
+		return ""
+	}
+	return match.route.cfg.Target
+}
+
+func TestRouteTablePrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []config.Route
+		path   string
+		want   string
+	}{
+		{
+			name:   "longest prefix",
+			routes: []config.Route{{Path: "/api"}, {Path: "/api/users"}},
+			path:   "/api/users/42",
+			want:   "http:///api/users",
+		},
+		{
+			name:   "prefix segment boundary",
+			routes: []config.Route{{Path: "/api"}, {Path: "/api/users"}},
+			path:   "/api/usersx",
+			want:   "http:///api",
+		},
+		{
+			name:   "no route",
+			routes: []config.Route{{Path: "/api"}},
+			path:   "/apix",
+		},
+		{
+			name:   "exact over prefix",
+			routes: []config.Route{{Path: "/api/status", Target: "http://prefix"}, {Path: "/api/status", Match: pathMatchExact, Target: "http://exact"}},
+			path:   "/api/status",
+			want:   "http://exact",
+		},
+		{
+			name:   "exact only on its path",
+			routes: []config.Route{{Path: "/api"}, {Path: "/api/status", Match: pathMatchExact}},
+			path:   "/api/status/details",
+			want:   "http:///api",
+		},
+		{
+			name:   "static segment over parameter",
+			routes: []config.Route{{Path: "/api/:id"}, {Path: "/api/me"}},
+			path:   "/api/me",
+			want:   "http:///api/me",
+		},
+		{
+			name:   "parameter",
+			routes: []config.Route{{Path: "/api/:id"}, {Path: "/api/me"}},
+			path:   "/api/42",
+			want:   "http:///api/:id",
+		},
+		{
+			name:   "deeper parameter route over shorter static route",
+			routes: []config.Route{{Path: "/api/users"}, {Path: "/api/:kind/:id"}},
+			path:   "/api/users/42",
+			want:   "http:///api/:kind/:id",
+		},
+		{
+			name:   "prefix over regex",
+			routes: []config.Route{{Path: "^/api/.*", Match: pathMatchRegex}, {Path: "/api"}},
+			path:   "/api/items",
+			want:   "http:///api",
+		},
+		{
+			name:   "priority over longest prefix",
+			routes: []config.Route{{Path: "/api", Priority: 1}, {Path: "/api/users"}},
+			path:   "/api/users",
+			want:   "http:///api",
+		},
+		{
+			name:   "priority over exact",
+			routes: []config.Route{{Path: "^/v[0-9]+/.*", Match: pathMatchRegex, Priority: 5}, {Path: "/v1/items", Match: pathMatchExact}},
+			path:   "/v1/items",
+			want:   "http://^/v[0-9]+/.*",
+		},
+		{
+			name: "priority between overlapping regex",
+			routes: []config.Route{
+				{Path: "^/v[0-9]+/.*", Match: pathMatchRegex},
+				{Path: "^/v1/.*", Match: pathMatchRegex, Priority: 1},
+			},
+			path: "/v1/items",
+			want: "http://^/v1/.*",
+		},
+		{
+			name: "priority between crossing parameters",
+			routes: []config.Route{
+				{Path: "/api/:id/x", Priority: 1},
+				{Path: "/api/foo/:y"},
+			},
+			path: "/api/foo/x",
+			want: "http:///api/:id/x",
+		},
+		{
+			name: "more conditions",
+			routes: []config.Route{
+				{Path: "/api", Target: "http://any"},
+				{Path: "/api", Target: "http://beta", Query: []config.ValueMatcher{{Name: "beta"}}},
+			},
+			path: "/api/items?beta=1",
+			want: "http://beta",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Le résultat ne dépend pas de l'ordre de déclaration
+			reversed := slices.Clone(tt.routes)
+			slices.Reverse(reversed)
+			for _, routes := range [][]config.Route{tt.routes, reversed} {
+				table, err := newTestRouteTable(t, routes)
+				if err != nil {
+					t.Fatalf("newRouteTable: %v", err)
+				}
+				if got := matchTarget(table, httptest.NewRequest(http.MethodGet, tt.path, nil)); got != tt.want {
+					t.Errorf("GET %s matched %q, want %q", tt.path, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRouteTableAmbiguity(t *testing.T) {
+	header := func(name, value string) []config.ValueMatcher {
+		return []config.ValueMatcher{{Name: name, Value: value}}
+	}
+	tests := []struct {
+		name      string
+		routes    []config.Route
+		ambiguous bool
+	}{
+		{name: "same prefix", routes: []config.Route{{Path: "/api"}, {Path: "/api/"}}, ambiguous: true},
+		{name: "same exact path", routes: []config.Route{{Path: "/api", Match: pathMatchExact}, {Path: "/api", Match: pathMatchExact}}, ambiguous: true},
+		{name: "parameter names", routes: []config.Route{{Path: "/api/:id"}, {Path: "/api/:name"}}, ambiguous: true},
+		{name: "crossing parameters", routes: []config.Route{{Path: "/api/:id/x"}, {Path: "/api/foo/:y"}}, ambiguous: true},
+		{name: "overlapping regex", routes: []config.Route{
+			{Path: "^/v[0-9]+/.*", Match: pathMatchRegex},
+			{Path: "^/v1/.*", Match: pathMatchRegex},
+		}, ambiguous: true},
+		{name: "disjoint regex of same priority", routes: []config.Route{
+			{Path: "^/a/.*", Match: pathMatchRegex},
+			{Path: "^/b/.*", Match: pathMatchRegex},
+		}, ambiguous: true},
+		{name: "nested wildcard hosts", routes: []config.Route{
+			{Path: "/api", Hosts: []string{"*.example.com"}},
+			{Path: "/api", Hosts: []string{"*.eu.example.com"}},
+		}, ambiguous: true},
+		{name: "shared host", routes: []config.Route{
+			{Path: "/api", Hosts: []string{"a.example.com", "b.example.com"}},
+			{Path: "/api", Hosts: []string{"b.example.com"}},
+		}, ambiguous: true},
+		{name: "different header names", routes: []config.Route{
+			{Path: "/api", Headers: header("X-A", "1")},
+			{Path: "/api", Headers: header("X-B", "1")},
+		}, ambiguous: true},
+		{name: "header regex", routes: []config.Route{
+			{Path: "/api", Headers: []config.ValueMatcher{{Name: "X-Version", Regex: "^1"}}},
+			{Path: "/api", Headers: header("X-Version", "2")},
+		}, ambiguous: true},
+
+		{name: "different priorities", routes: []config.Route{{Path: "/api/:id/x", Priority: 1}, {Path: "/api/foo/:y"}}},
+		{name: "regex priorities", routes: []config.Route{
+			{Path: "^/v[0-9]+/.*", Match: pathMatchRegex},
+			{Path: "^/v1/.*", Match: pathMatchRegex, Priority: 1},
+		}},
+		{name: "more static segments", routes: []config.Route{{Path: "/api/:id/x"}, {Path: "/api/foo/y"}}},
+		{name: "different depths", routes: []config.Route{{Path: "/api/:id"}, {Path: "/api/foo/:y"}}},
+		{name: "disjoint paths", routes: []config.Route{{Path: "/api/:id/x"}, {Path: "/api/:id/y"}}},
+		{name: "prefix and exact", routes: []config.Route{{Path: "/api"}, {Path: "/api", Match: pathMatchExact}}},
+		{name: "different hosts", routes: []config.Route{
+			{Path: "/api", Hosts: []string{"a.example.com"}},
+			{Path: "/api", Hosts: []string{"b.example.com"}},
+		}},
+		{name: "exact and wildcard host", routes: []config.Route{
+			{Path: "/api", Hosts: []string{"a.example.com"}},
+			{Path: "/api", Hosts: []string{"*.example.com"}},
+		}},
+		{name: "host and any host", routes: []config.Route{{Path: "/api", Hosts: []string{"a.example.com"}}, {Path: "/api"}}},
+		{name: "header values", routes: []config.Route{
+			{Path: "/api", Headers: header("X-Version", "1")},
+			{Path: "/api", Headers: header("X-Version", "2")},
+		}},
+		{name: "number of conditions", routes: []config.Route{{Path: "/api", Headers: header("X-Version", "1")}, {Path: "/api"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestRouteTable(t, tt.routes)
+			if (err != nil) != tt.ambiguous {
+				t.Fatalf("newRouteTable error = %v, want ambiguous %v", err, tt.ambiguous)
+			}
+			if err != nil && !strings.Contains(err.Error(), "ambiguous") {
+				t.Errorf("error = %q, want it to mention the ambiguity", err)
+			}
+		})
+	}
+}
+
+func TestRouteTableRefusesRuntimeTies(t *testing.T) {
+	table, err := newTestRouteTable(t, []config.Route{
+		{Path: "/api", Target: "http://v1", Headers: []config.ValueMatcher{{Name: "X-Version", Value: "1"}}},
+		{Path: "/api", Target: "http://v2", Headers: []config.ValueMatcher{{Name: "X-Version", Value: "2"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.Header.Set("X-Version", "2")
+	if got := matchTarget(table, req); got != "http://v2" {
+		t.Errorf("X-Version 2 matched %q, want http://v2", got)
+	}
+	// Une requête portant les deux valeurs correspond aux deux routes
+	req.Header.Add("X-Version", "1")
+	if got := matchTarget(table, req); got != "" {
+		t.Errorf("a request matching both routes was routed to %q", got)
+	}
+}
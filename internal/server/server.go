@@ -1,8 +1,13 @@
 package server
 
 import (
+	"context"
+	"net/http/httputil"
+
 	"github.com/gin-gonic/gin"
 	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/expr"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/rego"
 
 	"github.com/charmbracelet/log"
 )
@@ -18,13 +23,46 @@ func NewServer(cfg *config.Config) Server {
 }
 
 type proxyServer struct {
-	engine *gin.Engine
-	cfg    *config.Config
+	engine    *gin.Engine
+	cfg       *config.Config
+	providers []*identityProvider
+
+	// Routes configurées, dans l'ordre du fichier, et table de sélection
+	routes     []*compiledRoute
+	routeTable *routeTable
+
+	// Validateur de tokens par défaut, chaque route portant le sien
+	defaultValidator TokenValidator
+	tokenCache       *tokenCache
+
+	// Politiques d'autorisation compilées, indexées par leur expression
+	policies map[string]*expr.Program
+
+	// Moteur Rego et requêtes préparées, indexées par leur chemin
+	regoEngine  *rego.Engine
+	regoQueries map[string]*rego.Query
+
+	// Login navigateur (authorization code + PKCE), nil s'il est désactivé
+	login *browserLogin
+
+	// Protection CSRF des requêtes authentifiées par cookie, nil si désactivée
+	csrf *csrfProtection
+	// logouts mémorise les déconnexions back-channel du fournisseur
+	logouts *logoutRegistry
+
+	// Reverse proxy vers les backends, partagé par toutes les routes
+	reverseProxy *httputil.ReverseProxy
 }
 
 func (s *proxyServer) Start() error {
 	// Initialize Gin engine
 	s.engine = gin.Default()
+	s.reverseProxy = s.newReverseProxy()
+
+	// Initialize token validation (OIDC discovery, JWKS, ...)
+	if err := s.initTokenValidation(context.Background()); err != nil {
+		return err
+	}
 
 	// Add operational routes (health, metrics, etc.)
 	s.addOpsRoutes()
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// testKID identifie la clé de signature du fournisseur de test
+const testKID = "test-key"
+
+// testIssuer est un fournisseur d'identité de test : il publie son JWKS et
+// signe des JWT RS256
+type testIssuer struct {
+	key    *rsa.PrivateKey
+	server *httptest.Server
+	// jwksRequests compte les téléchargements du JWKS
+	jwksRequests atomic.Int32
+}
+
+// newTestIssuer démarre un fournisseur d'identité de test
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key}
+	issuer.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.jwksRequests.Add(1)
+		issuer.writeJWKS(w)
+	}))
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// writeJWKS répond avec le JWKS du fournisseur
+func (i *testIssuer) writeJWKS(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": testKID,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+	}}})
+}
+
+// provider retourne la configuration d'un fournisseur validant les JWT par son JWKS
+func (i *testIssuer) provider() config.Provider {
+	return config.Provider{
+		ClientID:   "gateway",
+		Endpoints:  config.OAuth2Endpoints{JWKSURL: i.server.URL + "/jwks"},
+		Validators: []string{validatorJWKS},
+	}
+}
+
+// token signe un access token valide une heure pour sub, complété des claims
+func (i *testIssuer) token(t *testing.T, sub string, claims map[string]any) string {
+	t.Helper()
+	now := time.Now()
+	payload := map[string]any{
+		"sub": sub,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for name, value := range claims {
+		payload[name] = value
+	}
+	return i.sign(t, map[string]any{"alg": "RS256", "kid": testKID, "typ": "JWT"}, payload)
+}
+
+// sign signe un JWT avec l'en-tête et les claims fournis
+func (i *testIssuer) sign(t *testing.T, header, claims map[string]any) string {
+	t.Helper()
+	encode := func(value any) string {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signingInput := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// testConfig retourne la configuration d'une passerelle acceptant les
+// tokens du fournisseur de test
+func testConfig(issuer *testIssuer, routes ...config.Route) *config.Config {
+	cfg := &config.Config{Routes: routes}
+	cfg.Server.TimeOut = 5
+	cfg.Server.OAuth2.Provider = issuer.provider()
+	return cfg
+}
+
+// newTestGateway démarre la passerelle avec ses middlewares et ses routes
+func newTestGateway(t *testing.T, cfg *config.Config) (*proxyServer, *httptest.Server) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s := &proxyServer{cfg: cfg, engine: gin.New()}
+	s.reverseProxy = s.newReverseProxy()
+	if err := s.initTokenValidation(t.Context()); err != nil {
+		t.Fatalf("initTokenValidation: %v", err)
+	}
+	s.addMiddlewares()
+	gateway := httptest.NewServer(s.engine)
+	t.Cleanup(gateway.Close)
+	return s, gateway
+}
+
+// backendRequest est une requête reçue par le backend de test
+type backendRequest struct {
+	method string
+	uri    string
+	header http.Header
+	body   string
+}
+
+// newTestBackend démarre un backend qui mémorise les requêtes reçues et
+// répond 200 avec le chemin demandé
+func newTestBackend(t *testing.T) (*httptest.Server, chan backendRequest) {
+	t.Helper()
+	received := make(chan backendRequest, 16)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- backendRequest{method: r.Method, uri: r.RequestURI, header: r.Header.Clone(), body: string(body)}
+		io.WriteString(w, r.URL.Path)
+	}))
+	t.Cleanup(backend.Close)
+	return backend, received
+}
+
+// lastRequest retourne la requête reçue par le backend, s'il en a reçu une
+func lastRequest(received chan backendRequest) (backendRequest, bool) {
+	select {
+	case request := <-received:
+		return request, true
+	default:
+		return backendRequest{}, false
+	}
+}
+
+// doRequest envoie une requête brute à la passerelle : le chemin est
+// transmis tel quel, sans normalisation par le client
+func doRequest(t *testing.T, gateway *httptest.Server, method, path string, header http.Header) *http.Response {
+	t.Helper()
+	req, err := http.NewRequestWithContext(t.Context(), method, gateway.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.URL.Opaque = path
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := gateway.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// bearer retourne le header Authorization d'un token
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// readBody lit le corps de la réponse
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// loginConfig active le login navigateur sur la configuration ; tokenURL
+// reçoit les rafraîchissements des sessions
+func loginConfig(cfg *config.Config, tokenURL string) *config.Config {
+	cfg.Server.OAuth2.Provider.RedirectURL = "http://gateway.test/callback"
+	cfg.Server.OAuth2.Provider.Endpoints.AuthURL = "http://issuer.test/authorize"
+	cfg.Server.OAuth2.Provider.Endpoints.TokenURL = tokenURL
+	cfg.Server.OAuth2.Login.Enabled = true
+	return cfg
+}
+
+// newTestSession enregistre une session navigateur et retourne son cookie
+func newTestSession(t *testing.T, s *proxyServer, session *Session) *http.Cookie {
+	t.Helper()
+	if session.ExpiresAt.IsZero() {
+		session.ExpiresAt = time.Now().Add(time.Hour)
+	}
+	if err := s.login.sessions.Save(t.Context(), session); err != nil {
+		t.Fatal(err)
+	}
+	value, err := s.login.codec.encode(sessionCookie, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: sessionCookie, Value: value}
+}
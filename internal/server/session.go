@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types de stockage des sessions supportés
+const (
+	sessionStoreMemory = "memory"
+	sessionStoreFile   = "file"
+)
+
+// errSessionNotFound indique une session inconnue ou expirée
+var errSessionNotFound = errors.New("session not found")
+
+// Session contient les tokens d'un navigateur connecté, conservés par le proxy
+type Session struct {
+	ID      string `json:"id"`
+	Subject string `json:"sub"`
+	// SID est la session OIDC du fournisseur (claim sid de l'id_token)
+	SID          string    `json:"sid,omitempty"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	IDToken      string    `json:"id_token,omitempty"`
+	TokenExpiry  time.Time `json:"token_expiry"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// expired indique si la session a dépassé sa durée de vie
+func (session *Session) expired() bool {
+	return time.Now().After(session.ExpiresAt)
+}
+
+// loggedOut indique si une déconnexion du fournisseur vise la session : la
+// session OIDC sid si elle est précisée, sinon toutes celles de l'utilisateur sub
+func (session *Session) loggedOut(sub, sid string) bool {
+	return logoutMatches(session.Subject, session.SID, sub, sid)
+}
+
+// SessionStore conserve les sessions navigateur côté serveur
+type SessionStore interface {
+	// Get retourne la session, ou errSessionNotFound si elle est inconnue ou expirée
+	Get(ctx context.Context, id string) (*Session, error)
+	Save(ctx context.Context, session *Session) error
+	Delete(ctx context.Context, id string) error
+	// DeleteExpired supprime les sessions expirées
+	DeleteExpired(ctx context.Context) error
+	// DeleteLoggedOut supprime les sessions visées par une déconnexion du
+	// fournisseur et retourne leur nombre
+	DeleteLoggedOut(ctx context.Context, sub, sid string) (int, error)
+}
+
+// memorySessionStore conserve les sessions en mémoire ; elles sont perdues au
+// redémarrage et ne sont pas partagées entre instances
+type memorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]Session
+}
+
+// newMemorySessionStore crée un stockage de sessions en mémoire
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: make(map[string]Session)}
+}
+
+// Get implémente SessionStore
+func (m *memorySessionStore) Get(ctx context.Context, id string) (*Session, error) {
+	m.mu.RLock()
+	session, ok := m.sessions[id]
+	m.mu.RUnlock()
+	if !ok || session.expired() {
+		return nil, errSessionNotFound
+	}
+	return &session, nil
+}
+
+// Save implémente SessionStore
+func (m *memorySessionStore) Save(ctx context.Context, session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[session.ID] = *session
+	return nil
+}
+
+// Delete implémente SessionStore
+func (m *memorySessionStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// DeleteExpired implémente SessionStore
+func (m *memorySessionStore) DeleteExpired(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, session := range m.sessions {
+		if session.expired() {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// DeleteLoggedOut implémente SessionStore
+func (m *memorySessionStore) DeleteLoggedOut(ctx context.Context, sub, sid string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for id, session := range m.sessions {
+		if session.loggedOut(sub, sid) {
+			delete(m.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// fileSessionStore conserve chaque session dans un fichier chiffré ; les
+// sessions survivent au redémarrage si la clé des cookies est configurée
+type fileSessionStore struct {
+	directory string
+	codec     *cookieCodec
+}
+
+// newFileSessionStore crée un stockage de sessions dans un répertoire
+func newFileSessionStore(directory string, codec *cookieCodec) (*fileSessionStore, error) {
+	if directory == "" {
+		return nil, errors.New("session.directory is required for the file store")
+	}
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	return &fileSessionStore{directory: directory, codec: codec}, nil
+}
+
+// path retourne le fichier d'une session ; son nom ne révèle pas l'identifiant
+func (f *fileSessionStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(f.directory, hex.EncodeToString(sum[:])+".session")
+}
+
+// read déchiffre un fichier de session
+func (f *fileSessionStore) read(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+	var session Session
+	if err := f.codec.decode("session", string(data), &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session %s: %w", filepath.Base(path), err)
+	}
+	return &session, nil
+}
+
+// Get implémente SessionStore
+func (f *fileSessionStore) Get(ctx context.Context, id string) (*Session, error) {
+	session, err := f.read(f.path(id))
+	if err != nil {
+		return nil, err
+	}
+	if session.ID != id || session.expired() {
+		return nil, errSessionNotFound
+	}
+	return session, nil
+}
+
+// Save implémente SessionStore ; le fichier est remplacé atomiquement
+func (f *fileSessionStore) Save(ctx context.Context, session *Session) error {
+	data, err := f.codec.encode("session", session)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.directory, ".session-*")
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path(session.ID)); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// Delete implémente SessionStore
+func (f *fileSessionStore) Delete(ctx context.Context, id string) error {
+	if err := os.Remove(f.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpired implémente SessionStore ; les fichiers illisibles (clé
+// changée au redémarrage) sont aussi supprimés
+func (f *fileSessionStore) DeleteExpired(ctx context.Context) error {
+	_, err := f.deleteMatching(func(session *Session) bool {
+		return session == nil || session.expired()
+	})
+	return err
+}
+
+// DeleteLoggedOut implémente SessionStore
+func (f *fileSessionStore) DeleteLoggedOut(ctx context.Context, sub, sid string) (int, error) {
+	return f.deleteMatching(func(session *Session) bool {
+		return session != nil && session.loggedOut(sub, sid)
+	})
+}
+
+// deleteMatching supprime les fichiers de session sélectionnés ; match reçoit
+// nil pour un fichier illisible
+func (f *fileSessionStore) deleteMatching(match func(session *Session) bool) (int, error) {
+	entries, err := os.ReadDir(f.directory)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	deleted := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".session") {
+			continue
+		}
+		path := filepath.Join(f.directory, entry.Name())
+		session, err := f.read(path)
+		if err != nil {
+			session = nil
+		}
+		if !match(session) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return deleted, fmt.Errorf("failed to delete session: %w", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
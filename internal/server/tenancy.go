@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// defaultTenantHeader porte le tenant demandé et celui transmis au backend
+const defaultTenantHeader = "X-Tenant-ID"
+
+// errTenantRequired indique que le token accorde plusieurs tenants sans que
+// la requête précise lequel
+var errTenantRequired = errors.New("tenant non précisé, utilisez le header ou le chemin de la route")
+
+// tenantHeader retourne le header portant le tenant sur la route
+func tenantHeader(tenancy config.Tenancy) string {
+	if tenancy.Header == "" {
+		return defaultTenantHeader
+	}
+	return tenancy.Header
+}
+
+// validateTenancy vérifie au démarrage la configuration multi-tenant d'une route
+func validateTenancy(route config.Route) error {
+	tenancy := route.Tenancy
+	if tenancy.Claim == "" {
+		if tenancy.Param != "" || tenancy.Header != "" || len(tenancy.Targets) > 0 {
+			return fmt.Errorf("tenancy requires a claim")
+		}
+		return nil
+	}
+	if route.Auth == authNone {
+		return fmt.Errorf("tenancy requires token validation (auth %q)", route.Auth)
+	}
+	if tenancy.Param != "" && !slices.Contains(declaredPathParams(route), tenancy.Param) {
+		return fmt.Errorf("tenant parameter %q is not declared in the route path", tenancy.Param)
+	}
+	tenants := make(map[string]bool, len(tenancy.Targets))
+	for _, target := range tenancy.Targets {
+		if target.Tenant == "" || target.Target == "" {
+			return fmt.Errorf("tenant target requires a tenant and a target")
+		}
+		if tenants[target.Tenant] {
+			return fmt.Errorf("tenant %q has several targets", target.Tenant)
+		}
+		tenants[target.Tenant] = true
+		if err := validatePathParams(config.Route{Path: route.Path, Match: route.Match, Target: target.Target}); err != nil {
+			return fmt.Errorf("tenant %q: %w", target.Tenant, err)
+		}
+	}
+	return nil
+}
+
+// tenancyMiddleware refuse l'accès aux tenants non accordés par le token et
+// stocke le tenant de la requête dans le contexte pour le routage et le backend
+func (s *proxyServer) tenancyMiddleware(route config.Route) gin.HandlerFunc {
+	tenancy := route.Tenancy
+	header := tenantHeader(tenancy)
+	return func(c *gin.Context) {
+		value, ok := c.Get("tokenInfo")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "Unauthorized",
+				"message": "Token d'accès manquant",
+			})
+			c.Abort()
+			return
+		}
+
+		tenant, err := resolveTenant(c, value.(*TokenInfo), tenancy, header)
+		if err != nil {
+			log.Printf("Accès refusé - %v", err)
+			status := http.StatusForbidden
+			if errors.Is(err, errTenantRequired) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{
+				"error":   http.StatusText(status),
+				"message": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("tenant", tenant)
+		c.Set("tenantHeader", header)
+		c.Next()
+	}
+}
+
+// resolveTenant retourne le tenant de la requête : celui demandé par le
+// chemin ou le header, s'il est accordé par le token, sinon celui du token
+func resolveTenant(c *gin.Context, tokenInfo *TokenInfo, tenancy config.Tenancy, header string) (string, error) {
+	claim, ok := claimValue(tokenInfo, tenancy.Claim)
+	if !ok {
+		return "", fmt.Errorf("tenant absent du token (claim %s)", tenancy.Claim)
+	}
+
+	requested := c.GetHeader(header)
+	if tenancy.Param != "" {
+		requested = c.Param(tenancy.Param)
+	}
+	if requested != "" {
+		if !claimMatches(claim, requested) {
+			return "", fmt.Errorf("accès au tenant %q non autorisé", requested)
+		}
+		return requested, nil
+	}
+
+	switch v := claim.(type) {
+	case string:
+		if v != "" {
+			return v, nil
+		}
+	case []any:
+		if len(v) == 1 {
+			if tenant, ok := v[0].(string); ok && tenant != "" {
+				return tenant, nil
+			}
+		}
+		if len(v) > 1 {
+			return "", errTenantRequired
+		}
+	}
+	return "", fmt.Errorf("tenant du token invalide (claim %s)", tenancy.Claim)
+}
+
+// tenantTarget retourne la cible propre au tenant, s'il en a une
+func tenantTarget(tenancy config.Tenancy, tenant string) (string, bool) {
+	for _, target := range tenancy.Targets {
+		if target.Tenant == tenant {
+			return target.Target, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+func TestValidateTenancy(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   config.Route
+		wantErr bool
+	}{
+		{name: "no tenancy", route: config.Route{Path: "/api"}},
+		{name: "claim only", route: config.Route{Path: "/api", Tenancy: config.Tenancy{Claim: "tenant_id"}}},
+		{name: "path param", route: config.Route{Path: "/api/:tenant", Tenancy: config.Tenancy{Claim: "tenant_id", Param: "tenant"}}},
+		{name: "targets", route: config.Route{Path: "/api", Tenancy: config.Tenancy{Claim: "tenant_id", Targets: []config.TenantTarget{
+			{Tenant: "acme", Target: "http://acme"},
+			{Tenant: "globex", Target: "http://globex"},
+		}}}},
+		{name: "param without claim", route: config.Route{Path: "/api/:tenant", Tenancy: config.Tenancy{Param: "tenant"}}, wantErr: true},
+		{name: "header without claim", route: config.Route{Path: "/api", Tenancy: config.Tenancy{Header: "X-Org"}}, wantErr: true},
+		{name: "auth none", route: config.Route{Path: "/api", Auth: authNone, Tenancy: config.Tenancy{Claim: "tenant_id"}}, wantErr: true},
+		{name: "undeclared param", route: config.Route{Path: "/api/:org", Tenancy: config.Tenancy{Claim: "tenant_id", Param: "tenant"}}, wantErr: true},
+		{name: "target without tenant", route: config.Route{Path: "/api", Tenancy: config.Tenancy{Claim: "tenant_id", Targets: []config.TenantTarget{
+			{Target: "http://acme"},
+		}}}, wantErr: true},
+		{name: "duplicate tenant", route: config.Route{Path: "/api", Tenancy: config.Tenancy{Claim: "tenant_id", Targets: []config.TenantTarget{
+			{Tenant: "acme", Target: "http://acme-1"},
+			{Tenant: "acme", Target: "http://acme-2"},
+		}}}, wantErr: true},
+		{name: "target with undeclared param", route: config.Route{Path: "/api", Tenancy: config.Tenancy{Claim: "tenant_id", Targets: []config.TenantTarget{
+			{Tenant: "acme", Target: "http://acme/:id"},
+		}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTenancy(tt.route); (err != nil) != tt.wantErr {
+				t.Errorf("validateTenancy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTenancy(t *testing.T) {
+	issuer := newTestIssuer(t)
+	backend, received := newTestBackend(t)
+	globexBackend, globexReceived := newTestBackend(t)
+	_, gateway := newTestGateway(t, testConfig(issuer,
+		config.Route{
+			Path:    "/api/tenants/:tenant",
+			Target:  backend.URL + "/tenants/:tenant",
+			Tenancy: config.Tenancy{Claim: "tenant_id", Param: "tenant"},
+		},
+		config.Route{
+			Path:   "/api/projects",
+			Target: backend.URL + "/projects",
+			Tenancy: config.Tenancy{Claim: "tenant_id", Header: "X-Org", Targets: []config.TenantTarget{
+				{Tenant: "globex", Target: globexBackend.URL + "/globex/projects"},
+			}},
+		},
+	))
+	withHeaders := func(token string, headers ...string) http.Header {
+		header := bearer(token)
+		for i := 0; i < len(headers); i += 2 {
+			header.Set(headers[i], headers[i+1])
+		}
+		return header
+	}
+	acme := issuer.token(t, "alice", map[string]any{"tenant_id": "acme"})
+	both := issuer.token(t, "bob", map[string]any{"tenant_id": []string{"acme", "globex"}})
+	noTenant := issuer.token(t, "carol", nil)
+
+	tests := []struct {
+		name       string
+		path       string
+		header     http.Header
+		want       int
+		wantURI    string
+		wantHeader string
+		wantTenant string
+		globex     bool
+	}{
+		{name: "no token", path: "/api/tenants/acme", want: http.StatusUnauthorized},
+		{name: "own tenant in path", path: "/api/tenants/acme/invoices", header: bearer(acme),
+			want: http.StatusOK, wantURI: "/tenants/acme/invoices", wantHeader: "X-Tenant-ID", wantTenant: "acme"},
+		{name: "spoofed tenant header", path: "/api/tenants/acme", header: withHeaders(acme, "X-Tenant-ID", "globex"),
+			want: http.StatusOK, wantURI: "/tenants/acme", wantHeader: "X-Tenant-ID", wantTenant: "acme"},
+		{name: "other tenant in path", path: "/api/tenants/globex/invoices", header: bearer(acme), want: http.StatusForbidden},
+		{name: "tenant granted by a list claim", path: "/api/tenants/globex", header: bearer(both),
+			want: http.StatusOK, wantURI: "/tenants/globex", wantHeader: "X-Tenant-ID", wantTenant: "globex"},
+		{name: "missing claim", path: "/api/tenants/acme", header: bearer(noTenant), want: http.StatusForbidden},
+		{name: "missing claim on header route", path: "/api/projects", header: withHeaders(noTenant, "X-Org", "acme"), want: http.StatusForbidden},
+		{name: "tenant header", path: "/api/projects", header: withHeaders(acme, "X-Org", "acme"),
+			want: http.StatusOK, wantURI: "/projects", wantHeader: "X-Org", wantTenant: "acme"},
+		{name: "tenant of the token", path: "/api/projects", header: bearer(acme),
+			want: http.StatusOK, wantURI: "/projects", wantHeader: "X-Org", wantTenant: "acme"},
+		{name: "other tenant header", path: "/api/projects", header: withHeaders(acme, "X-Org", "globex"), want: http.StatusForbidden},
+		{name: "several tenants without header", path: "/api/projects", header: bearer(both), want: http.StatusBadRequest},
+		{name: "tenant target", path: "/api/projects", header: withHeaders(both, "X-Org", "globex"),
+			want: http.StatusOK, wantURI: "/globex/projects", wantHeader: "X-Org", wantTenant: "globex", globex: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, gateway, http.MethodGet, tt.path, tt.header)
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+			request, ok := lastRequest(received)
+			globexRequest, globexOK := lastRequest(globexReceived)
+			if tt.globex {
+				request, ok = globexRequest, globexOK
+			} else if globexOK {
+				t.Errorf("request reached the globex backend as %s", globexRequest.uri)
+			}
+			if ok != (tt.want == http.StatusOK) {
+				t.Fatalf("backend reached = %v", ok)
+			}
+			if !ok {
+				return
+			}
+			if request.uri != tt.wantURI {
+				t.Errorf("forwarded as %s, want %s", request.uri, tt.wantURI)
+			}
+			if tenant := request.header.Values(tt.wantHeader); len(tenant) != 1 || tenant[0] != tt.wantTenant {
+				t.Errorf("%s = %q, want %q", tt.wantHeader, tenant, tt.wantTenant)
+			}
+		})
+	}
+}
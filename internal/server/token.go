@@ -1,13 +1,10 @@
 package server
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -28,53 +25,35 @@ type TokenInfo struct {
 	ResourceAccess map[string]struct {
 		Roles []string `json:"roles"`
 	} `json:"resource_access"`
-	Scope             string `json:"scope"`
-	Expiration        int64  `json:"exp"`
-	IssuedAt          int64  `json:"iat"`
-	Issuer            string `json:"iss"`
-	ClientID          string `json:"client_id"`
-	TokenType         string `json:"token_type"`
-	PreferredUsername string `json:"preferred_username"`
+	Scope             string   `json:"scope"`
+	Expiration        int64    `json:"exp"`
+	NotBefore         int64    `json:"nbf"`
+	IssuedAt          int64    `json:"iat"`
+	Issuer            string   `json:"iss"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ClientID          string   `json:"client_id"`
+	TokenType         string   `json:"token_type"`
+	PreferredUsername string   `json:"preferred_username"`
+
+	// Claims contient l'ensemble des claims reçus, y compris ceux non mappés
+	// ci-dessus, pour l'évaluation des politiques d'autorisation
+	Claims map[string]any `json:"-"`
 }
 
-// extractTokenInfo extrait et parse les informations du token via l'endpoint userinfo
-func (s *proxyServer) extractTokenInfo(ctx context.Context, tokenString string) (*TokenInfo, error) {
-	// Créer la requête vers l'endpoint userinfo
-	req, err := http.NewRequestWithContext(ctx, "GET", s.cfg.Server.OAuth2.Endpoints.UserInfoURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Ajouter le token Bearer dans les headers
-	req.Header.Set("Authorization", "Bearer "+tokenString)
-	req.Header.Set("Content-Type", "application/json")
-
-	// Créer un client HTTP avec timeout
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	// Exécuter la requête
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user info: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Vérifier le statut de la réponse
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("userinfo endpoint returned status: %d", resp.StatusCode)
-	}
-
-	// Décoder la réponse JSON
-	var tokenInfo TokenInfo
-	if err := json.NewDecoder(resp.Body).Decode(&tokenInfo); err != nil {
-		return nil, fmt.Errorf("failed to decode user info: %w", err)
+// decodeClaims conserve tous les claims d'un document JSON déjà décodé dans le TokenInfo
+func (tokenInfo *TokenInfo) decodeClaims(data []byte) error {
+	if err := json.Unmarshal(data, &tokenInfo.Claims); err != nil {
+		return fmt.Errorf("failed to decode claims: %w", err)
 	}
+	return nil
+}
 
+// normalize vérifie les champs obligatoires et initialise les champs optionnels
+func (tokenInfo *TokenInfo) normalize() error {
 	// Validation des champs obligatoires
 	if tokenInfo.Sub == "" {
-		return nil, fmt.Errorf("missing required field 'sub' in token info")
+		return fmt.Errorf("missing required field 'sub' in token info")
 	}
 
 	// Initialiser les champs qui peuvent être vides
@@ -98,7 +77,7 @@ func (s *proxyServer) extractTokenInfo(ctx context.Context, tokenString string)
 		tokenInfo.Teams = []string{}
 	}
 
-	return &tokenInfo, nil
+	return nil
 }
 
 // setTokenHeaders ajoute les informations du token dans les headers de sortie
@@ -139,7 +118,9 @@ func (s *proxyServer) setTokenHeaders(c *gin.Context, tokenInfo *TokenInfo, toke
 	c.Set("userEmail", tokenInfo.Email)
 }
 
-// Middleware qui extrait et valide le token
+// Middleware qui extrait le token du header Authorization ; sa validation
+// est faite par chaque route. La session du navigateur n'est chargée que par
+// les routes qui authentifient la requête.
 func (s *proxyServer) tokenExtractionMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token, err := getTokenFromHeader(c)
@@ -154,21 +135,23 @@ func (s *proxyServer) tokenExtractionMiddleware() gin.HandlerFunc {
 		c.Set("hasToken", true)
 		c.Set("accessToken", token)
 
-		tokenInfo, err := s.extractTokenInfo(c.Request.Context(), token)
-		if err != nil {
-			c.JSON(401, gin.H{
-				"error":   "Invalid token",
-				"message": "Token invalide ou expiré",
-			})
-			c.Abort()
-			return
-		}
-		s.setTokenHeaders(c, tokenInfo, token)
-
 		c.Next()
 	}
 }
 
+// requestToken retourne le token du header Authorization ou, à défaut, celui
+// de la session du navigateur
+func (s *proxyServer) requestToken(c *gin.Context) (string, error) {
+	token, err := getTokenFromHeader(c)
+	if err == nil || s.login == nil || c.GetHeader("Authorization") != "" {
+		return token, err
+	}
+	if token, ok := s.login.sessionToken(c); ok {
+		return token, nil
+	}
+	return "", err
+}
+
 // getTokenFromHeader extrait le token Bearer du header Authorization
 func getTokenFromHeader(c *gin.Context) (string, error) {
 	authHeader := c.GetHeader("Authorization")
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// Stratégies de validation des tokens supportées
+const (
+	validatorUserInfo      = "userinfo"
+	validatorJWKS          = "jwks"
+	validatorIntrospection = "introspection"
+)
+
+// errTokenNotSupported indique qu'un validateur ne sait pas traiter ce format
+// de token : la chaîne de validation passe alors au validateur suivant
+var errTokenNotSupported = errors.New("token format not supported by validator")
+
+// TokenValidator valide un access token et en extrait les informations
+type TokenValidator interface {
+	Validate(ctx context.Context, tokenString string) (*TokenInfo, error)
+}
+
+// validatorChain essaie chaque validateur dans l'ordre. Un validateur qui ne
+// supporte pas le format du token laisse la main au suivant ; toute autre
+// erreur (signature invalide, token expiré...) est définitive.
+type validatorChain struct {
+	name       string
+	validators []TokenValidator
+}
+
+// Validate implémente TokenValidator
+func (c *validatorChain) Validate(ctx context.Context, tokenString string) (*TokenInfo, error) {
+	lastErr := errTokenNotSupported
+	for _, validator := range c.validators {
+		tokenInfo, err := validator.Validate(ctx, tokenString)
+		if err == nil {
+			return tokenInfo, nil
+		}
+		if !errors.Is(err, errTokenNotSupported) {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// initTokenValidation prépare les fournisseurs d'identité (découverte OIDC)
+// et les chaînes de validation propres à chaque route
+func (s *proxyServer) initTokenValidation(ctx context.Context) error {
+	oauth2Cfg := s.cfg.Server.OAuth2
+
+	// Fournisseur par défaut (déclaré sous oauth2) puis fournisseurs nommés
+	s.providers = nil
+	if isConfigured(oauth2Cfg.Provider) || len(oauth2Cfg.Providers) == 0 {
+		s.providers = append(s.providers, newIdentityProvider(oauth2Cfg.Provider))
+	}
+	for _, providerCfg := range oauth2Cfg.Providers {
+		if providerCfg.Name == "" {
+			return errors.New("oauth2.providers: every provider must have a name")
+		}
+		if s.provider(providerCfg.Name) != nil {
+			return fmt.Errorf("oauth2.providers: duplicate provider %q", providerCfg.Name)
+		}
+		s.providers = append(s.providers, newIdentityProvider(providerCfg))
+	}
+	for _, provider := range s.providers {
+		if err := provider.start(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Cache des validations réussies, partagé par toutes les chaînes
+	if oauth2Cfg.Cache.Enabled {
+		ttl := time.Duration(oauth2Cfg.Cache.TTL) * time.Second
+		s.tokenCache = newTokenCache(ttl, oauth2Cfg.Cache.MaxEntries)
+	}
+
+	if err := s.initLogin(ctx); err != nil {
+		return err
+	}
+	if s.cfg.Server.CSRF.Enabled {
+		// Les déconnexions back-channel sont envoyées par le fournisseur, pas
+		// par le navigateur
+		var exemptPaths []string
+		if s.login != nil {
+			exemptPaths = append(exemptPaths, s.login.backchannel)
+		}
+		csrf, err := newCSRFProtection(s.cfg.Server.CSRF, s.login != nil && s.login.secure, exemptPaths...)
+		if err != nil {
+			return err
+		}
+		s.csrf = csrf
+	}
+
+	if err := s.loadRegoPolicies(); err != nil {
+		return err
+	}
+
+	defaultValidator, err := s.buildProviderValidator(ctx, config.Route{})
+	if err != nil {
+		return err
+	}
+	s.defaultValidator = defaultValidator
+
+	s.routes = nil
+	for _, route := range s.cfg.Routes {
+		switch routeAuthMode(route) {
+		case authRequired, authOptional, authNone:
+		default:
+			return fmt.Errorf("route %s: unknown auth mode %q", route.Path, route.Auth)
+		}
+		compiled, err := compileRoute(route)
+		if err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := validateAccessRules(route); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := s.compilePolicies(route); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := s.prepareRegoQueries(route); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		validator, err := s.buildProviderValidator(ctx, route)
+		if err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := s.checkUserInfoTrust(route); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		compiled.validator = validator
+		s.routes = append(s.routes, compiled)
+	}
+
+	routeTable, err := newRouteTable(s.routes)
+	if err != nil {
+		return err
+	}
+	s.routeTable = routeTable
+	return nil
+}
+
+// provider retourne le fournisseur d'identité portant ce nom
+func (s *proxyServer) provider(name string) *identityProvider {
+	for _, provider := range s.providers {
+		if provider.name == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+// routeProviders retourne les fournisseurs acceptés par une route
+func (s *proxyServer) routeProviders(route config.Route) ([]*identityProvider, error) {
+	if len(route.Providers) == 0 {
+		return s.providers, nil
+	}
+	providers := make([]*identityProvider, 0, len(route.Providers))
+	for _, name := range route.Providers {
+		provider := s.provider(name)
+		if provider == nil {
+			return nil, fmt.Errorf("unknown identity provider %q", name)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// buildProviderValidator construit le validateur d'une route : chaque
+// fournisseur accepté valide ses tokens avec la chaîne de la route
+func (s *proxyServer) buildProviderValidator(ctx context.Context, route config.Route) (TokenValidator, error) {
+	providers, err := s.routeProviders(route)
+	if err != nil {
+		return nil, err
+	}
+	validator := &providerValidator{}
+	for _, provider := range providers {
+		chain, err := provider.chain(ctx, route.Validators, s.tokenCache)
+		if err != nil {
+			return nil, err
+		}
+		validator.providers = append(validator.providers, providerRoute{provider: provider, validator: chain})
+	}
+	return validator, nil
+}
+
+// validatorFor retourne le validateur applicable à une route
+func (s *proxyServer) validatorFor(route *compiledRoute) TokenValidator {
+	if route.validator != nil {
+		return route.validator
+	}
+	return s.defaultValidator
+}
+
+// userInfoValidator valide le token en interrogeant l'endpoint userinfo
+type userInfoValidator struct {
+	url    func() string
+	issuer string
+	client *http.Client
+}
+
+// newUserInfoValidator crée un validateur basé sur l'endpoint userinfo.
+// La réponse userinfo ne contenant pas de claim iss, l'issuer du fournisseur
+// interrogé est attribué au token.
+func newUserInfoValidator(url func() string, issuer string) *userInfoValidator {
+	return &userInfoValidator{
+		url:    url,
+		issuer: issuer,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Validate extrait et parse les informations du token via l'endpoint userinfo
+func (v *userInfoValidator) Validate(ctx context.Context, tokenString string) (*TokenInfo, error) {
+	// Créer la requête vers l'endpoint userinfo
+	req, err := http.NewRequestWithContext(ctx, "GET", v.url(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Ajouter le token Bearer dans les headers
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+	req.Header.Set("Content-Type", "application/json")
+
+	// Exécuter la requête
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Vérifier le statut de la réponse
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo endpoint returned status: %d", resp.StatusCode)
+	}
+
+	// Décoder la réponse JSON
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user info: %w", err)
+	}
+	var tokenInfo TokenInfo
+	if err := json.Unmarshal(body, &tokenInfo); err != nil {
+		return nil, fmt.Errorf("failed to decode user info: %w", err)
+	}
+	if err := tokenInfo.decodeClaims(body); err != nil {
+		return nil, err
+	}
+	if tokenInfo.Issuer == "" {
+		tokenInfo.Issuer = v.issuer
+	}
+
+	if err := tokenInfo.normalize(); err != nil {
+		return nil, err
+	}
+	return &tokenInfo, nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/laurentpoirierfr/api-security-oauth2/internal/config"
+)
+
+// stubValidator retourne toujours le même résultat et compte ses appels
+type stubValidator struct {
+	info  *TokenInfo
+	err   error
+	calls atomic.Int32
+}
+
+// Validate implémente TokenValidator
+func (v *stubValidator) Validate(ctx context.Context, tokenString string) (*TokenInfo, error) {
+	v.calls.Add(1)
+	return v.info, v.err
+}
+
+func TestValidatorChain(t *testing.T) {
+	errSignature := errors.New("invalid signature")
+	alice := &TokenInfo{Sub: "alice"}
+	bob := &TokenInfo{Sub: "bob"}
+	unsupported := func() *stubValidator { return &stubValidator{err: errTokenNotSupported} }
+
+	tests := []struct {
+		name       string
+		validators []*stubValidator
+		wantSub    string
+		wantErr    error
+		wantCalls  []int32
+	}{
+		{name: "empty chain", wantErr: errTokenNotSupported},
+		{name: "first validator", validators: []*stubValidator{{info: alice}, {info: bob}}, wantSub: "alice", wantCalls: []int32{1, 0}},
+		{name: "unsupported format", validators: []*stubValidator{unsupported(), {info: bob}}, wantSub: "bob", wantCalls: []int32{1, 1}},
+		{name: "definitive error", validators: []*stubValidator{{err: errSignature}, {info: bob}}, wantErr: errSignature, wantCalls: []int32{1, 0}},
+		{name: "wrapped unsupported format", validators: []*stubValidator{{err: errors.Join(errTokenNotSupported, errSignature)}, {info: bob}}, wantSub: "bob", wantCalls: []int32{1, 1}},
+		{name: "no validator supports the format", validators: []*stubValidator{unsupported(), unsupported()}, wantErr: errTokenNotSupported, wantCalls: []int32{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := &validatorChain{name: tt.name}
+			for _, validator := range tt.validators {
+				chain.validators = append(chain.validators, validator)
+			}
+			info, err := chain.Validate(t.Context(), "token")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Validate() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil || info.Sub != tt.wantSub {
+				t.Errorf("Validate() = %+v, %v, want sub %q", info, err, tt.wantSub)
+			}
+			for i, validator := range tt.validators {
+				if calls := validator.calls.Load(); calls != tt.wantCalls[i] {
+					t.Errorf("validator %d called %d times, want %d", i, calls, tt.wantCalls[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidatorNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider []string
+		route    []string
+		want     []string
+	}{
+		{name: "userinfo by default", want: []string{validatorUserInfo}},
+		{name: "provider chain", provider: []string{validatorJWKS, validatorIntrospection}, want: []string{validatorJWKS, validatorIntrospection}},
+		{name: "route chain", provider: []string{validatorJWKS}, route: []string{validatorIntrospection}, want: []string{validatorIntrospection}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newIdentityProvider(config.Provider{Validators: tt.provider})
+			if got := provider.validatorNames(tt.route); !slices.Equal(got, tt.want) {
+				t.Errorf("validatorNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Les JWT sont vérifiés localement, les tokens opaques par introspection ;
+// une route peut imposer sa propre chaîne
+func TestValidatorChainOnRoutes(t *testing.T) {
+	var introspections atomic.Int32
+	introspection := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		introspections.Add(1)
+		active := r.PostFormValue("token") != "revoked-token"
+		json.NewEncoder(w).Encode(map[string]any{"active": active, "sub": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+	}))
+	t.Cleanup(introspection.Close)
+
+	issuer := newTestIssuer(t)
+	backend, _ := newTestBackend(t)
+	cfg := testConfig(issuer,
+		config.Route{Path: "/api", Target: backend.URL, Auth: authRequired},
+		config.Route{Path: "/admin", Target: backend.URL, Auth: authRequired, Validators: []string{validatorIntrospection}},
+		config.Route{Path: "/local", Target: backend.URL, Auth: authRequired, Validators: []string{validatorJWKS}},
+	)
+	cfg.Server.OAuth2.Provider.Endpoints.IntrospectionURL = introspection.URL
+	cfg.Server.OAuth2.Provider.Validators = []string{validatorJWKS, validatorIntrospection}
+	_, gateway := newTestGateway(t, cfg)
+
+	jwt := issuer.token(t, "alice", nil)
+	forged := newTestIssuer(t).token(t, "alice", nil)
+	tests := []struct {
+		name               string
+		path               string
+		token              string
+		want               int
+		wantIntrospections int32
+	}{
+		{name: "JWT verified locally", path: "/api", token: jwt, want: http.StatusOK},
+		{name: "opaque token introspected", path: "/api", token: "opaque-token", want: http.StatusOK, wantIntrospections: 1},
+		{name: "inactive opaque token", path: "/api", token: "revoked-token", want: http.StatusUnauthorized, wantIntrospections: 1},
+		{name: "invalid signature is definitive", path: "/api", token: forged, want: http.StatusUnauthorized},
+		{name: "route introspects JWT", path: "/admin", token: jwt, want: http.StatusOK, wantIntrospections: 1},
+		{name: "route without introspection", path: "/local", token: "opaque-token", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			introspections.Store(0)
+			if resp := doRequest(t, gateway, http.MethodGet, tt.path, bearer(tt.token)); resp.StatusCode != tt.want {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+			if got := introspections.Load(); got != tt.wantIntrospections {
+				t.Errorf("%d introspections, want %d", got, tt.wantIntrospections)
+			}
+		})
+	}
+}
+
+func TestValidatorChainConfiguration(t *testing.T) {
+	issuer := newTestIssuer(t)
+	tests := []struct {
+		name       string
+		validators []string
+		wantErr    bool
+	}{
+		{name: "jwks", validators: []string{validatorJWKS}},
+		{name: "unknown validator", validators: []string{validatorJWKS, "opaque"}, wantErr: true},
+		{name: "missing introspection endpoint", validators: []string{validatorIntrospection}, wantErr: true},
+		{name: "missing userinfo endpoint", validators: []string{validatorUserInfo}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(issuer, config.Route{Path: "/api", Target: "http://backend", Validators: tt.validators})
+			s := &proxyServer{cfg: cfg}
+			if err := s.initTokenValidation(t.Context()); (err != nil) != tt.wantErr {
+				t.Errorf("initTokenValidation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Les routes déclarant la même chaîne partagent ses validateurs
+func TestValidatorChainIsShared(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newIdentityProvider(issuer.provider())
+	first, err := provider.chain(t.Context(), []string{validatorJWKS}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := provider.chain(t.Context(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("the provider chain was built twice")
+	}
+	if requests := issuer.jwksRequests.Load(); requests != 1 {
+		t.Errorf("JWKS downloaded %d times, want 1", requests)
+	}
+}
@@ -0,0 +1,26 @@
+# Exemple de politique évaluée par le proxy (server.rego.directory: "./policies")
+# et référencée par une route avec rego: "data.httpapi.authz.allow"
+package httpapi.authz
+
+import rego.v1
+
+default allow := false
+
+# Lecture ouverte aux membres de l'équipe plateforme
+allow if {
+	input.request.method in {"GET", "HEAD"}
+	"platform-ops" in input.token.groups
+}
+
+# Écriture réservée aux comptes de l'entreprise ayant le rôle admin
+allow if {
+	input.request.method in {"POST", "PUT", "PATCH", "DELETE"}
+	endswith(input.token.email, "@corp.com")
+	"admin" in input.token.roles
+	not contractor
+}
+
+contractor if {
+	some group in input.token.groups
+	startswith(group, "contractor")
+}